@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blewater/zh/types"
+)
+
+const candlesPath = "/candles"
+
+// Candles keeps each product's recent closed candles of each interval in a
+// bounded ring.
+type Candles struct {
+	intervals []time.Duration
+	size      int
+
+	mu    sync.RWMutex
+	rings map[candleKey]*candleRing
+}
+
+type candleKey struct {
+	productID string
+	interval  time.Duration
+}
+
+// candleRing is a product's last candles of an interval overwriting the oldest
+// when full.
+type candleRing struct {
+	candles []*types.Candle
+	next    int
+	full    bool
+}
+
+// NewCandles returns the history of the last size candles of each product and
+// interval. The first interval is the served one by default.
+func NewCandles(intervals []time.Duration, size int) *Candles {
+	return &Candles{
+		intervals: intervals,
+		size:      size,
+		rings:     make(map[candleKey]*candleRing),
+	}
+}
+
+// Write keeps the closed candle.
+func (cs *Candles) Write(c *types.Candle) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	key := candleKey{productID: c.ProductID, interval: c.Interval}
+	r, ok := cs.rings[key]
+	if !ok {
+		r = &candleRing{candles: make([]*types.Candle, cs.size)}
+		cs.rings[key] = r
+	}
+
+	r.candles[r.next] = c
+	r.next = (r.next + 1) % cs.size
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns up to the product's last limit candles of the interval oldest
+// first. A non positive limit returns all the retained candles.
+func (cs *Candles) Recent(productID string, interval time.Duration, limit int) []*types.Candle {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	r, ok := cs.rings[candleKey{productID: productID, interval: interval}]
+	if !ok {
+		return nil
+	}
+
+	n := r.next
+	if r.full {
+		n = cs.size
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	recent := make([]*types.Candle, limit)
+	for i := range recent {
+		recent[i] = r.candles[(r.next-limit+i+cs.size)%cs.size]
+	}
+
+	return recent
+}
+
+// interval returns the kept interval matching its text i.e. 1m, the first one
+// when empty.
+func (cs *Candles) interval(text string) (time.Duration, bool) {
+	if text == "" {
+		return cs.intervals[0], true
+	}
+
+	interval, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, false
+	}
+	for _, kept := range cs.intervals {
+		if kept == interval {
+			return interval, true
+		}
+	}
+
+	return 0, false
+}
+
+// candleJSON is the JSON object of a closed candle.
+type candleJSON struct {
+	Start  time.Time `json:"start"`
+	Open   string    `json:"open"`
+	High   string    `json:"high"`
+	Low    string    `json:"low"`
+	Close  string    `json:"close"`
+	Volume string    `json:"volume"`
+	Vwap   string    `json:"vwap"`
+	Trades uint64    `json:"trades"`
+}
+
+// candlesJSON is the JSON object of a product's recent candles of an interval
+// oldest first.
+type candlesJSON struct {
+	ProductID string       `json:"product_id"`
+	Interval  string       `json:"interval"`
+	Candles   []candleJSON `json:"candles"`
+}
+
+// handleCandles serves /candles/{productID}?interval=1m&limit=N.
+func (s *Server) handleCandles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	productID := strings.TrimPrefix(r.URL.Path, candlesPath+"/")
+	if productID == "" || strings.Contains(productID, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if _, err := s.snapshots.Snapshot(productID); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	interval, ok := s.candles.interval(r.URL.Query().Get("interval"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "interval must be one of the candles' intervals")
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	precision := s.precision(productID)
+	recent := s.candles.Recent(productID, interval, limit)
+	candles := make([]candleJSON, len(recent))
+	for i, c := range recent {
+		candles[i] = candleJSON{
+			Start:  c.Start,
+			Open:   c.Open.Text('f', precision),
+			High:   c.High.Text('f', precision),
+			Low:    c.Low.Text('f', precision),
+			Close:  c.Close.Text('f', precision),
+			Volume: c.Volume.Text('f', -1),
+			Vwap:   c.Vwap.Text('f', precision),
+			Trades: c.Trades,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, candlesJSON{
+		ProductID: productID,
+		Interval:  interval.String(),
+		Candles:   candles,
+	})
+}
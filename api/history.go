@@ -0,0 +1,84 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/blewater/zh/sink"
+)
+
+// History is the sink keeping each product's recent results in a bounded
+// ring.
+type History struct {
+	mu    sync.RWMutex
+	size  int
+	rings map[string]*ring
+}
+
+// ring is a product's last results overwriting the oldest when full.
+type ring struct {
+	results []*sink.Result
+	next    int
+	full    bool
+}
+
+// NewHistory returns the history of the last size results of each product.
+func NewHistory(size int) *History {
+	return &History{
+		size:  size,
+		rings: make(map[string]*ring),
+	}
+}
+
+func (h *History) Write(res *sink.Result) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rings[res.ProductID]
+	if !ok {
+		r = &ring{results: make([]*sink.Result, h.size)}
+		h.rings[res.ProductID] = r
+	}
+
+	r.results[r.next] = res
+	r.next = (r.next + 1) % h.size
+	if r.next == 0 {
+		r.full = true
+	}
+
+	return nil
+}
+
+// Recent returns up to the product's last limit results oldest first. A non
+// positive limit returns all the retained results.
+func (h *History) Recent(productID string, limit int) []*sink.Result {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.rings[productID]
+	if !ok {
+		return nil
+	}
+
+	n := r.next
+	if r.full {
+		n = h.size
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	recent := make([]*sink.Result, limit)
+	for i := range recent {
+		recent[i] = r.results[(r.next-limit+i+h.size)%h.size]
+	}
+
+	return recent
+}
+
+func (h *History) Close() error {
+	return nil
+}
+
+func (h *History) String() string {
+	return "history"
+}
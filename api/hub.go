@@ -0,0 +1,319 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blewater/zh/sink"
+	"github.com/gorilla/websocket"
+)
+
+// The WebSocket message types. The clients send
+//
+//	{"type":"subscribe","product_ids":["BTC-USD"]}
+//	{"type":"unsubscribe","product_ids":["BTC-USD"]}
+//
+// and receive a snapshot per subscribed product followed by its VWAP updates.
+const (
+	SubscribeMsgType   = "subscribe"
+	UnsubscribeMsgType = "unsubscribe"
+	SnapshotMsgType    = "snapshot"
+	VWAPMsgType        = "vwap"
+	ErrorMsgType       = "error"
+)
+
+const (
+	wsPath = "/ws"
+	// wsWriteTimeout bounds a client's write of a message
+	wsWriteTimeout = 5 * time.Second
+)
+
+// wsRequest is a client's subscription message.
+type wsRequest struct {
+	Type       string   `json:"type"`
+	ProductIDs []string `json:"product_ids"`
+}
+
+// wsSnapshot is the snapshot message of a subscribed product.
+type wsSnapshot struct {
+	Type string `json:"type"`
+	productJSON
+}
+
+// wsResult is the update message of a VWAP result.
+type wsResult struct {
+	Type      string `json:"type"`
+	ProductID string `json:"product_id"`
+	resultJSON
+}
+
+// wsError is the error message of an invalid request.
+type wsError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// Hub is the sink rebroadcasting the VWAP results to the WebSocket clients
+// subscribed to their product. A client whose send buffer is full is dropped,
+// so that a slow client does not hold up the others.
+type Hub struct {
+	// dropped counts the slow clients dropped
+	dropped atomic.Uint64
+
+	server   *Server
+	sendLen  int
+	upgrader websocket.Upgrader
+
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+}
+
+// wsClient is a connected client and its subscribed products.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	// mu orders the snapshot on subscribe before the product's updates
+	mu       sync.Mutex
+	products map[string]bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// WebSocket serves the hub rebroadcasting the results at /ws. Each client
+// buffers up to sendLen messages. The browsers' handshakes are accepted from
+// the origins i.e. https://example.com, any origin with "*", or else the
+// same origin only. The hub is to be written as a sink.
+func (s *Server) WebSocket(sendLen int, origins []string) *Hub {
+	s.hub = &Hub{
+		server:  s,
+		sendLen: sendLen,
+		clients: make(map[*wsClient]struct{}),
+	}
+	if len(origins) > 0 {
+		s.hub.upgrader.CheckOrigin = checkOrigin(origins)
+	}
+
+	return s.hub
+}
+
+// checkOrigin returns the handshake check of the Origin header against the
+// allowed origins. Non browser clients sending no Origin are accepted.
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		for _, allowed := range origins {
+			if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), u.Scheme+"://"+u.Host) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func (h *Hub) Write(res *sink.Result) error {
+	var msg []byte
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		c.mu.Lock()
+		if c.products[res.ProductID] {
+			if msg == nil {
+				var err error
+				if msg, err = json.Marshal(wsResult{
+					Type:       VWAPMsgType,
+					ProductID:  res.ProductID,
+					resultJSON: newResultJSON(res),
+				}); err != nil {
+					c.mu.Unlock()
+					return err
+				}
+			}
+			if !c.enqueue(msg) {
+				h.dropped.Add(1)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Dropped returns the number of slow clients dropped.
+func (h *Hub) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// Clients returns the number of connected clients.
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients)
+}
+
+// Close disconnects the clients.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		c.close()
+	}
+
+	return nil
+}
+
+func (h *Hub) String() string {
+	return "websocket"
+}
+
+func (h *Hub) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader replied with the error
+		return
+	}
+
+	c := &wsClient{
+		conn:     conn,
+		send:     make(chan []byte, h.sendLen),
+		products: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	go c.writeLoop()
+	h.readLoop(c)
+
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.close()
+}
+
+// readLoop applies the client's subscription messages until it disconnects.
+func (h *Hub) readLoop(c *wsClient) {
+	for {
+		var req wsRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		switch req.Type {
+		case SubscribeMsgType:
+			for _, productID := range req.ProductIDs {
+				h.subscribe(c, productID)
+			}
+		case UnsubscribeMsgType:
+			c.mu.Lock()
+			for _, productID := range req.ProductIDs {
+				delete(c.products, productID)
+			}
+			c.mu.Unlock()
+		default:
+			c.enqueueError("unknown message type " + req.Type)
+		}
+	}
+}
+
+// subscribe queues the product's snapshot ahead of its updates.
+func (h *Hub) subscribe(c *wsClient, productID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot, err := h.server.snapshots.Snapshot(productID)
+	if err != nil {
+		c.enqueueErrorLocked(err.Error())
+		return
+	}
+
+	msg, err := json.Marshal(wsSnapshot{
+		Type:        SnapshotMsgType,
+		productJSON: h.server.productJSON(snapshot),
+	})
+	if err != nil {
+		c.enqueueErrorLocked(err.Error())
+		return
+	}
+
+	c.products[productID] = true
+	if !c.enqueue(msg) {
+		h.dropped.Add(1)
+	}
+}
+
+// enqueue queues the message or else drops the slow client returning false.
+func (c *wsClient) enqueue(msg []byte) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		c.close()
+		return false
+	}
+}
+
+func (c *wsClient) enqueueError(errMsg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enqueueErrorLocked(errMsg)
+}
+
+func (c *wsClient) enqueueErrorLocked(errMsg string) {
+	if msg, err := json.Marshal(wsError{Type: ErrorMsgType, Error: errMsg}); err == nil {
+		c.enqueue(msg)
+	}
+}
+
+// writeLoop writes the queued messages until the client is closed.
+func (c *wsClient) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			// nolint:errcheck
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// close disconnects the client once which ends its read and write loops.
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		// nolint:errcheck
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,196 @@
+package api_test
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/api"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/vwap"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+type wsMsg struct {
+	Type      string `json:"type"`
+	ProductID string `json:"product_id"`
+	Vwap      string `json:"vwap"`
+	Error     string `json:"error"`
+	Windows   []struct {
+		Vwap string `json:"vwap"`
+	} `json:"windows"`
+}
+
+func setupHub(t *testing.T, sendLen int, origins ...string) (*api.Hub, *httptest.Server) {
+	t.Helper()
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+
+	productsVwap := vwap.New([]string{"BTC-USD", "ETH-USD"}, 2)
+	if err := productsVwap.ProduceVwap(ctx, "BTC-USD", big.NewFloat(10), big.NewFloat(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-productsVwap.GetResultsQ()
+
+	srv := api.New("", productsVwap, api.NewHistory(1), map[string]int{"BTC-USD": 1, "ETH-USD": 1})
+	hub := srv.WebSocket(sendLen, origins)
+
+	return hub, httptest.NewServer(srv.Handler())
+}
+
+func dialHub(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func newSinkResult(productID string, vwap float64) *sink.Result {
+	res := &sink.Result{Precision: 1}
+	res.ProductID = productID
+	res.Window = "2"
+	res.Vwap = big.NewFloat(vwap)
+	return res
+}
+
+func TestHub_Subscriptions(t *testing.T) {
+	hub, srv := setupHub(t, 16)
+	defer srv.Close()
+	defer hub.Close()
+
+	conn := dialHub(t, srv)
+	defer conn.Close()
+	read := func() wsMsg {
+		t.Helper()
+		var msg wsMsg
+		// nolint:errcheck
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	send := func(msgType string, productIDs ...string) {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]interface{}{"type": msgType, "product_ids": productIDs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(api.SubscribeMsgType, "BTC-USD", "LTC-USD")
+	if msg := read(); msg.Type != api.SnapshotMsgType || msg.ProductID != "BTC-USD" || msg.Windows[0].Vwap != "10.0" {
+		t.Fatalf("subscribe message = %+v, want the BTC-USD snapshot", msg)
+	}
+	if msg := read(); msg.Type != api.ErrorMsgType {
+		t.Fatalf("subscribe message = %+v, want an unknown product error", msg)
+	}
+
+	// the subscription is registered once the snapshot is received
+	if err := hub.Write(newSinkResult("ETH-USD", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Write(newSinkResult("BTC-USD", 11)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != api.VWAPMsgType || msg.ProductID != "BTC-USD" || msg.Vwap != "11.0" {
+		t.Fatalf("update = %+v, want BTC-USD 11.0", msg)
+	}
+
+	send(api.UnsubscribeMsgType, "BTC-USD")
+	send(api.SubscribeMsgType, "ETH-USD")
+	if msg := read(); msg.Type != api.SnapshotMsgType || msg.ProductID != "ETH-USD" {
+		t.Fatalf("subscribe message = %+v, want the ETH-USD snapshot", msg)
+	}
+	if err := hub.Write(newSinkResult("BTC-USD", 12)); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Write(newSinkResult("ETH-USD", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.ProductID != "ETH-USD" || msg.Vwap != "2.0" {
+		t.Fatalf("update = %+v, want ETH-USD 2.0 after unsubscribing BTC-USD", msg)
+	}
+
+	send("heartbeat")
+	if msg := read(); msg.Type != api.ErrorMsgType {
+		t.Fatalf("message = %+v, want an unknown type error", msg)
+	}
+}
+
+func TestHub_DropsSlowClient(t *testing.T) {
+	hub, srv := setupHub(t, 1)
+	defer srv.Close()
+	defer hub.Close()
+
+	slow := dialHub(t, srv)
+	defer slow.Close()
+	if err := slow.WriteJSON(map[string]interface{}{"type": api.SubscribeMsgType, "product_ids": []string{"BTC-USD"}}); err != nil {
+		t.Fatal(err)
+	}
+	var msg wsMsg
+	if err := slow.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the slow client stops reading until the socket buffers fill up
+	for i := 0; hub.Dropped() == 0; i++ {
+		if i == 1000000 {
+			t.Fatal("the slow client was not dropped")
+		}
+		if err := hub.Write(newSinkResult("BTC-USD", float64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Clients() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Clients() = %d, want 0 after dropping the slow client", hub.Clients())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHub_Origins(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{name: "No origin", origin: "", want: true},
+		{name: "Other origin by default", origin: "https://example.com", want: false},
+		{name: "Allowed origin", origins: []string{"https://example.com"}, origin: "https://Example.com", want: true},
+		{name: "Disallowed origin", origins: []string{"https://example.com"}, origin: "https://other.com", want: false},
+		{name: "Any origin", origins: []string{"*"}, origin: "https://other.com", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, srv := setupHub(t, 16, tt.origins...)
+			defer srv.Close()
+			defer hub.Close()
+
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+			if err == nil {
+				conn.Close()
+			}
+			if got := err == nil; got != tt.want {
+				t.Fatalf("Dial() with origin %q error = %v, want accepted %v", tt.origin, err, tt.want)
+			}
+			if !tt.want && resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Dial() status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+			}
+		})
+	}
+}
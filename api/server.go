@@ -0,0 +1,347 @@
+// Package api serves the products' current and recent VWAP values over HTTP.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+const (
+	vwapPath     = "/vwap"
+	historyPath  = "/history"
+	metricsPath  = "/metrics"
+	productsPath = "/products"
+	// shutdownTimeout bounds the wait for the in-flight requests on shutdown
+	shutdownTimeout = time.Second
+)
+
+// Server is the HTTP API of
+//   - GET /vwap: every product's snapshot
+//   - GET /vwap/{productID}: the product's snapshot
+//   - GET /vwap/{productID}/history?limit=N: the product's last N results
+//   - /ws: the WebSocket updates when enabled. See Hub.
+//   - GET /metrics: the Prometheus metrics when enabled. See Metrics.
+//   - GET /products, PUT and DELETE /products/{productID}: the runtime
+//     subscriptions when enabled. See Control.
+//   - GET /candles/{productID}?interval=1m&limit=N: the product's last N
+//     candles when enabled. See Candles.
+type Server struct {
+	addr      string
+	snapshots vwap.Snapshotter
+	history   *History
+	hub       *Hub
+	metrics   http.Handler
+	control   Controller
+	candles   *Candles
+
+	// precisionsMu guards the precisions of the products added at runtime
+	precisionsMu sync.RWMutex
+	precisions   map[string]int
+}
+
+// Controller adds and removes the products at runtime.
+type Controller interface {
+	// Subscribe adds the products.
+	Subscribe(ctx context.Context, productIDs []string) error
+	// Unsubscribe removes the products.
+	Unsubscribe(ctx context.Context, productIDs []string) error
+	// Products returns the products ordered by product ID.
+	Products() []string
+}
+
+// New returns the API server listening to addr. The VWAP values are formatted
+// to each product's precision.
+func New(addr string, snapshots vwap.Snapshotter, history *History, precisions map[string]int) *Server {
+	s := &Server{
+		addr:       addr,
+		snapshots:  snapshots,
+		history:    history,
+		precisions: make(map[string]int, len(precisions)),
+	}
+	for p, precision := range precisions {
+		s.precisions[p] = precision
+	}
+
+	return s
+}
+
+// windowJSON is the JSON object of a window snapshot.
+type windowJSON struct {
+	Window  string    `json:"window"`
+	Vwap    string    `json:"vwap"`
+	Fill    int       `json:"fill"`
+	Size    int       `json:"size,omitempty"`
+	Trades  uint64    `json:"trades"`
+	Suspect bool      `json:"suspect"`
+	Updated time.Time `json:"updated"`
+}
+
+// productJSON is the JSON object of a product snapshot.
+type productJSON struct {
+	ProductID string       `json:"product_id"`
+	Windows   []windowJSON `json:"windows"`
+}
+
+// resultJSON is the JSON object of a recent result. Vwap is the value of its
+// Metric: vwap or twap. StdDev and Bands are the VWAP's σ bands when enabled.
+type resultJSON struct {
+	Window       string          `json:"window"`
+	Metric       string          `json:"metric"`
+	Vwap         string          `json:"vwap"`
+	StdDev       string          `json:"stddev,omitempty"`
+	Bands        []sink.JSONBand `json:"bands,omitempty"`
+	Suspect      bool            `json:"suspect"`
+	SessionClose bool            `json:"session_close"`
+}
+
+// historyJSON is the JSON object of a product's recent results oldest first.
+type historyJSON struct {
+	ProductID string       `json:"product_id"`
+	Results   []resultJSON `json:"results"`
+}
+
+// productsJSON is the JSON object of the subscribed products.
+type productsJSON struct {
+	ProductIDs []string `json:"product_ids"`
+}
+
+// errorJSON is the JSON object of an erred request.
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// ListenAndServe serves the API until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: s.Handler(),
+		// the requests' contexts carry the logger
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("HTTP API shutdown erred", zap.Error(err))
+		}
+	}()
+
+	logger.Info("Serving the HTTP API", zap.String("addr", s.addr))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// Metrics serves the metrics scrapes of h at /metrics.
+func (s *Server) Metrics(h http.Handler) {
+	s.metrics = h
+}
+
+// Control serves the runtime subscriptions of ctl at /products.
+func (s *Server) Control(ctl Controller) {
+	s.control = ctl
+}
+
+// Candles serves the closed candles kept in cs at /candles.
+func (s *Server) Candles(cs *Candles) {
+	s.candles = cs
+}
+
+// SetPrecision sets the decimal digits of the product's VWAP values i.e. of a
+// product added at runtime.
+func (s *Server) SetPrecision(productID string, precision int) {
+	s.precisionsMu.Lock()
+	defer s.precisionsMu.Unlock()
+
+	s.precisions[productID] = precision
+}
+
+func (s *Server) precision(productID string) int {
+	s.precisionsMu.RLock()
+	defer s.precisionsMu.RUnlock()
+
+	return s.precisions[productID]
+}
+
+// Handler returns the API's routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(vwapPath, s.handleProducts)
+	mux.HandleFunc(vwapPath+"/", s.handleProduct)
+	if s.hub != nil {
+		mux.HandleFunc(wsPath, s.hub.serveWS)
+	}
+	if s.metrics != nil {
+		mux.Handle(metricsPath, s.metrics)
+	}
+	if s.control != nil {
+		mux.HandleFunc(productsPath, s.handleSubscriptions)
+		mux.HandleFunc(productsPath+"/", s.handleSubscription)
+	}
+	if s.candles != nil {
+		mux.HandleFunc(candlesPath+"/", s.handleCandles)
+	}
+
+	return mux
+}
+
+func (s *Server) handleProducts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	snapshots := s.snapshots.Snapshots()
+	products := make([]productJSON, len(snapshots))
+	for i, snapshot := range snapshots {
+		products[i] = s.productJSON(snapshot)
+	}
+
+	writeJSON(w, http.StatusOK, products)
+}
+
+// handleProduct serves /vwap/{productID} and /vwap/{productID}/history.
+func (s *Server) handleProduct(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	productID := strings.TrimPrefix(r.URL.Path, vwapPath+"/")
+	history := strings.HasSuffix(productID, historyPath)
+	productID = strings.TrimSuffix(productID, historyPath)
+	if productID == "" || strings.Contains(productID, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	snapshot, err := s.snapshots.Snapshot(productID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if !history {
+		writeJSON(w, http.StatusOK, s.productJSON(snapshot))
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	recent := s.history.Recent(productID, limit)
+	results := make([]resultJSON, len(recent))
+	for i, res := range recent {
+		results[i] = newResultJSON(res)
+	}
+
+	writeJSON(w, http.StatusOK, historyJSON{ProductID: productID, Results: results})
+}
+
+// handleSubscriptions serves the subscribed products.
+func (s *Server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, productsJSON{ProductIDs: s.control.Products()})
+}
+
+// handleSubscription subscribes the product of /products/{productID} on PUT
+// and unsubscribes it on DELETE serving the subscribed products.
+func (s *Server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	productID := strings.TrimPrefix(r.URL.Path, productsPath+"/")
+	if productID == "" || strings.Contains(productID, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		err = s.control.Subscribe(r.Context(), []string{productID})
+	case http.MethodDelete:
+		err = s.control.Unsubscribe(r.Context(), []string{productID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, productsJSON{ProductIDs: s.control.Products()})
+}
+
+func newResultJSON(res *sink.Result) resultJSON {
+	result := resultJSON{
+		Window:       res.Window,
+		Metric:       res.Metric.String(),
+		Vwap:         res.Vwap.Text('f', res.Precision),
+		Bands:        sink.JSONBands(res.Bands, res.Precision),
+		Suspect:      res.Suspect,
+		SessionClose: res.SessionClose,
+	}
+	if res.StdDev != nil {
+		result.StdDev = res.StdDev.Text('f', res.Precision)
+	}
+
+	return result
+}
+
+func (s *Server) productJSON(snapshot vwap.ProductSnapshot) productJSON {
+	product := productJSON{
+		ProductID: snapshot.ProductID,
+		Windows:   make([]windowJSON, len(snapshot.Windows)),
+	}
+	for i, ws := range snapshot.Windows {
+		product.Windows[i] = windowJSON{
+			Window:  ws.Window,
+			Vwap:    ws.Vwap.Text('f', s.precision(snapshot.ProductID)),
+			Fill:    ws.Fill,
+			Size:    ws.Size,
+			Trades:  ws.Trades,
+			Suspect: ws.Suspect,
+			Updated: ws.Updated,
+		}
+	}
+
+	return product
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// nolint:errcheck
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorJSON{Error: msg})
+}
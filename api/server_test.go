@@ -0,0 +1,340 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/api"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+func TestHistory_Recent(t *testing.T) {
+	history := api.NewHistory(3)
+	write := func(vwap int64) {
+		res := &sink.Result{}
+		res.ProductID = "BTC-USD"
+		res.Vwap = big.NewFloat(float64(vwap))
+		if err := history.Write(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recent := func(limit int) []int64 {
+		var vwaps []int64
+		for _, res := range history.Recent("BTC-USD", limit) {
+			v, _ := res.Vwap.Int64()
+			vwaps = append(vwaps, v)
+		}
+		return vwaps
+	}
+
+	if got := history.Recent("ETH-USD", 1); len(got) != 0 {
+		t.Errorf("Recent(ETH-USD) = %v, want none", got)
+	}
+
+	write(1)
+	write(2)
+	if got := recent(0); !equal(got, []int64{1, 2}) {
+		t.Errorf("Recent(0) = %v, want [1 2]", got)
+	}
+
+	write(3)
+	write(4)
+	tests := []struct {
+		limit int
+		want  []int64
+	}{
+		{0, []int64{2, 3, 4}},
+		{1, []int64{4}},
+		{2, []int64{3, 4}},
+		{10, []int64{2, 3, 4}},
+	}
+	for _, tt := range tests {
+		if got := recent(tt.limit); !equal(got, tt.want) {
+			t.Errorf("Recent(%d) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestServer_Handler(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	productsVwap := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"BTC-USD": {vwap.CountWindowSpec(2)},
+		"ETH-USD": {vwap.CountWindowSpec(3)},
+	})
+	history := api.NewHistory(10)
+
+	for _, price := range []float64{1, 2, 4} {
+		if err := productsVwap.ProduceVwap(ctx, "BTC-USD", big.NewFloat(price), big.NewFloat(1)); err != nil {
+			t.Fatal(err)
+		}
+		res := <-productsVwap.GetResultsQ()
+		if err := history.Write(&sink.Result{VWAPResult: *res, Precision: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(
+		api.New("", productsVwap, history, map[string]int{"BTC-USD": 1, "ETH-USD": 2}).Handler(),
+	)
+	defer srv.Close()
+
+	get := func(path string, wantStatus int, v interface{}) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("GET %s status = %d, want %d", path, resp.StatusCode, wantStatus)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	type window struct {
+		Window string `json:"window"`
+		Vwap   string `json:"vwap"`
+		Fill   int    `json:"fill"`
+		Size   int    `json:"size"`
+		Trades uint64 `json:"trades"`
+	}
+	type product struct {
+		ProductID string   `json:"product_id"`
+		Windows   []window `json:"windows"`
+	}
+
+	var products []product
+	get("/vwap", http.StatusOK, &products)
+	if len(products) != 2 || products[0].ProductID != "BTC-USD" || products[1].ProductID != "ETH-USD" {
+		t.Fatalf("GET /vwap = %+v", products)
+	}
+	if got, want := products[1].Windows[0], (window{Window: "3", Vwap: "0.00", Size: 3}); got != want {
+		t.Errorf("GET /vwap ETH-USD window = %+v, want %+v", got, want)
+	}
+
+	var btc product
+	get("/vwap/BTC-USD", http.StatusOK, &btc)
+	if got, want := btc.Windows[0], (window{Window: "2", Vwap: "3.0", Fill: 2, Size: 2, Trades: 3}); got != want {
+		t.Errorf("GET /vwap/BTC-USD window = %+v, want %+v", got, want)
+	}
+
+	var recent struct {
+		ProductID string `json:"product_id"`
+		Results   []struct {
+			Vwap string `json:"vwap"`
+		} `json:"results"`
+	}
+	get("/vwap/BTC-USD/history?limit=2", http.StatusOK, &recent)
+	if len(recent.Results) != 2 || recent.Results[0].Vwap != "1.5" || recent.Results[1].Vwap != "3.0" {
+		t.Errorf("GET /vwap/BTC-USD/history?limit=2 = %+v", recent)
+	}
+
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	get("/vwap/LTC-USD", http.StatusNotFound, &apiErr)
+	get("/vwap/BTC-USD/history?limit=x", http.StatusBadRequest, &apiErr)
+	get("/vwap/BTC-USD/trades", http.StatusNotFound, &apiErr)
+}
+
+// controller is the fake runtime subscriptions of the API's tests.
+type controller struct {
+	products []string
+}
+
+func (c *controller) Subscribe(_ context.Context, productIDs []string) error {
+	c.products = append(c.products, productIDs...)
+	return nil
+}
+
+func (c *controller) Unsubscribe(_ context.Context, productIDs []string) error {
+	for _, productID := range productIDs {
+		i := 0
+		for i < len(c.products) && c.products[i] != productID {
+			i++
+		}
+		if i == len(c.products) {
+			return fmt.Errorf("product ID %s is not subscribed", productID)
+		}
+		c.products = append(c.products[:i], c.products[i+1:]...)
+	}
+	return nil
+}
+
+func (c *controller) Products() []string {
+	return c.products
+}
+
+func TestServer_Control(t *testing.T) {
+	productsVwap := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"BTC-USD": {vwap.CountWindowSpec(2)},
+	})
+	s := api.New("", productsVwap, api.NewHistory(10), map[string]int{"BTC-USD": 2})
+	s.Control(&controller{products: []string{"BTC-USD"}})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		want       []string
+	}{
+		{http.MethodGet, "/products", http.StatusOK, []string{"BTC-USD"}},
+		{http.MethodPut, "/products/ETH-USD", http.StatusOK, []string{"BTC-USD", "ETH-USD"}},
+		{http.MethodDelete, "/products/BTC-USD", http.StatusOK, []string{"ETH-USD"}},
+		{http.MethodDelete, "/products/LTC-USD", http.StatusBadRequest, nil},
+		{http.MethodPost, "/products/LTC-USD", http.StatusMethodNotAllowed, nil},
+		{http.MethodPut, "/products/", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var products struct {
+				ProductIDs []string `json:"product_ids"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&products); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(products.ProductIDs, tt.want) {
+				t.Errorf("product IDs = %v, want %v", products.ProductIDs, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Candles(t *testing.T) {
+	productsVwap := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"BTC-USD": {vwap.CountWindowSpec(2)},
+	})
+	candles := api.NewCandles([]time.Duration{time.Minute, time.Hour}, 2)
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	for i, price := range []float64{1, 2, 4} {
+		candles.Write(&types.Candle{
+			ProductID: "BTC-USD",
+			Interval:  time.Minute,
+			Start:     t0.Add(time.Duration(i) * time.Minute),
+			Open:      big.NewFloat(price),
+			High:      big.NewFloat(price + 1),
+			Low:       big.NewFloat(price - 1),
+			Close:     big.NewFloat(price),
+			Volume:    big.NewFloat(1.5),
+			Vwap:      big.NewFloat(price),
+			Trades:    2,
+		})
+	}
+
+	s := api.New("", productsVwap, api.NewHistory(10), map[string]int{"BTC-USD": 1})
+	s.Candles(candles)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	type candle struct {
+		Start  time.Time `json:"start"`
+		Open   string    `json:"open"`
+		High   string    `json:"high"`
+		Low    string    `json:"low"`
+		Close  string    `json:"close"`
+		Volume string    `json:"volume"`
+		Vwap   string    `json:"vwap"`
+		Trades uint64    `json:"trades"`
+	}
+	type productCandles struct {
+		ProductID string   `json:"product_id"`
+		Interval  string   `json:"interval"`
+		Candles   []candle `json:"candles"`
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		want       productCandles
+	}{
+		{"/candles/BTC-USD", http.StatusOK, productCandles{
+			ProductID: "BTC-USD",
+			Interval:  "1m0s",
+			Candles: []candle{
+				{t0.Add(time.Minute), "2.0", "3.0", "1.0", "2.0", "1.5", "2.0", 2},
+				{t0.Add(2 * time.Minute), "4.0", "5.0", "3.0", "4.0", "1.5", "4.0", 2},
+			},
+		}},
+		{"/candles/BTC-USD?interval=1m&limit=1", http.StatusOK, productCandles{
+			ProductID: "BTC-USD",
+			Interval:  "1m0s",
+			Candles: []candle{
+				{t0.Add(2 * time.Minute), "4.0", "5.0", "3.0", "4.0", "1.5", "4.0", 2},
+			},
+		}},
+		{"/candles/BTC-USD?interval=1h", http.StatusOK, productCandles{
+			ProductID: "BTC-USD",
+			Interval:  "1h0m0s",
+			Candles:   []candle{},
+		}},
+		{"/candles/BTC-USD?interval=5m", http.StatusBadRequest, productCandles{}},
+		{"/candles/BTC-USD?limit=0", http.StatusBadRequest, productCandles{}},
+		{"/candles/LTC-USD", http.StatusNotFound, productCandles{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got productCandles
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GET %s = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+// Package batch reads historical trades off CSV or JSON Lines files for the
+// offline VWAP computation. The files are streamed a trade at a time, so that
+// their size does not bound the memory, and may be gzip compressed as a
+// whole.
+package batch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blewater/zh/types"
+)
+
+// readBufferLen is the read buffer of the trade files.
+const readBufferLen = 1 << 20
+
+// MaxLineLen bounds a JSON Lines line against corrupt files.
+const MaxLineLen = 1 << 20
+
+// InputFormat is the encoding of a trades file.
+type InputFormat uint8
+
+const (
+	// CSVInput is a CSV record per trade following a header record naming
+	// the columns.
+	CSVInput InputFormat = iota
+	// JSONLinesInput is a JSON object per trade per line.
+	JSONLinesInput
+)
+
+func (f InputFormat) String() string {
+	switch f {
+	case CSVInput:
+		return "csv"
+	case JSONLinesInput:
+		return "jsonl"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseInputFormat returns the input format matching its name.
+func ParseInputFormat(name string) (InputFormat, error) {
+	for _, f := range []InputFormat{CSVInput, JSONLinesInput} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+
+	return CSVInput, fmt.Errorf("unknown trades format %q", name)
+}
+
+// DetectInputFormat returns the input format of the file's extension i.e.
+// trades.csv or trades.jsonl.gz.
+func DetectInputFormat(path string) (InputFormat, error) {
+	ext := filepath.Ext(strings.TrimSuffix(path, ".gz"))
+	switch ext {
+	case ".csv":
+		return CSVInput, nil
+	case ".jsonl", ".ndjson":
+		return JSONLinesInput, nil
+	}
+
+	return CSVInput, fmt.Errorf("unknown trades format of %s, please supply it", path)
+}
+
+// Columns name the trade fields' CSV header columns or JSON keys.
+type Columns struct {
+	ProductID string
+	Price     string
+	Size      string
+	Time      string
+}
+
+// DefaultColumns are the Coinbase matches' field names.
+func DefaultColumns() Columns {
+	return Columns{
+		ProductID: "product_id",
+		Price:     "price",
+		Size:      "size",
+		Time:      "time",
+	}
+}
+
+// ParseColumns returns the default columns overridden by the mapping of the
+// trade fields product_id, price, size and time to their columns i.e.
+// price=px.
+func ParseColumns(mapping map[string]string) (Columns, error) {
+	columns := DefaultColumns()
+	for field, column := range mapping {
+		if column == "" {
+			return columns, fmt.Errorf("empty column of the %s field", field)
+		}
+		switch field {
+		case "product_id":
+			columns.ProductID = column
+		case "price":
+			columns.Price = column
+		case "size":
+			columns.Size = column
+		case "time":
+			columns.Time = column
+		default:
+			return columns, fmt.Errorf("unknown trade field %q", field)
+		}
+	}
+
+	return columns, nil
+}
+
+// Time layouts of the Unix epoch trade times besides the time.Parse layouts.
+const (
+	// UnixLayout is the seconds optionally with a fraction i.e.
+	// 1636578000.123456.
+	UnixLayout = "unix"
+	// UnixMilliLayout is the integer milliseconds i.e. 1636578000123.
+	UnixMilliLayout = "unixms"
+)
+
+// ReaderConfig configures the trades file decoding.
+type ReaderConfig struct {
+	Format  InputFormat
+	Columns Columns
+	// TimeLayout parses the trade times: a time.Parse layout i.e.
+	// time.RFC3339Nano, UnixLayout or UnixMilliLayout
+	TimeLayout string
+	// Precision is the mantissa bits of the parsed prices and sizes
+	Precision uint
+	// RoundingMode rounds the parsed decimal prices and sizes to Precision
+	RoundingMode big.RoundingMode
+}
+
+// Reader decodes the trades of a plain or gzip compressed trades file.
+type Reader struct {
+	cfg  ReaderConfig
+	line int
+
+	// csv and its columns' indexes
+	csv                             *csv.Reader
+	productID, price, size, timeIdx int
+
+	// lines and their fields' keys
+	lines                                  *bufio.Scanner
+	productIDKey, priceKey, sizeKey, tsKey types.FieldKey
+}
+
+// NewReader detects the compression of r and reads the CSV header.
+func NewReader(r io.Reader, cfg ReaderConfig) (*Reader, error) {
+	br := bufio.NewReaderSize(r, readBufferLen)
+	if gzipped, err := br.Peek(2); err == nil && gzipped[0] == 0x1f && gzipped[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReaderSize(zr, readBufferLen)
+	}
+
+	tr := &Reader{
+		cfg: cfg,
+	}
+	switch cfg.Format {
+	case CSVInput:
+		tr.csv = csv.NewReader(br)
+		tr.csv.ReuseRecord = true
+		if err := tr.readHeader(); err != nil {
+			return nil, err
+		}
+	case JSONLinesInput:
+		tr.lines = bufio.NewScanner(br)
+		tr.lines.Buffer(make([]byte, 64<<10), MaxLineLen)
+		tr.productIDKey = fieldKey(cfg.Columns.ProductID)
+		tr.priceKey = fieldKey(cfg.Columns.Price)
+		tr.sizeKey = fieldKey(cfg.Columns.Size)
+		tr.tsKey = fieldKey(cfg.Columns.Time)
+	default:
+		return nil, fmt.Errorf("unknown trades format %d", cfg.Format)
+	}
+
+	return tr, nil
+}
+
+func fieldKey(name string) types.FieldKey {
+	return types.FieldKey(strconv.Quote(name))
+}
+
+// readHeader indexes the columns of the CSV header.
+func (r *Reader) readHeader() error {
+	header, err := r.csv.Read()
+	if err == io.EOF {
+		return fmt.Errorf("missing the CSV header")
+	}
+	if err != nil {
+		return err
+	}
+	r.line++
+
+	index := func(column string) (int, error) {
+		for i, name := range header {
+			if strings.TrimSpace(name) == column {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("missing the CSV column %q", column)
+	}
+	if r.productID, err = index(r.cfg.Columns.ProductID); err != nil {
+		return err
+	}
+	if r.price, err = index(r.cfg.Columns.Price); err != nil {
+		return err
+	}
+	if r.size, err = index(r.cfg.Columns.Size); err != nil {
+		return err
+	}
+	if r.timeIdx, err = index(r.cfg.Columns.Time); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Read decodes the next trade into trade whose price and size are set. It
+// returns io.EOF at the end of the trades. The errors carry the line number.
+func (r *Reader) Read(trade *types.TradeValue) error {
+	trade.Price.SetPrec(r.cfg.Precision).SetMode(r.cfg.RoundingMode)
+	trade.Size.SetPrec(r.cfg.Precision).SetMode(r.cfg.RoundingMode)
+
+	var err error
+	if r.csv != nil {
+		err = r.readCSV(trade)
+	} else {
+		err = r.readLine(trade)
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("line %d: %w", r.line, err)
+	}
+
+	return err
+}
+
+func (r *Reader) readCSV(trade *types.TradeValue) error {
+	record, err := r.csv.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			r.line = parseErr.Line
+		}
+		return err
+	}
+	r.line, _ = r.csv.FieldPos(0)
+
+	trade.ProductID = record[r.productID]
+	if _, _, err := trade.Price.Parse(record[r.price], 10); err != nil || !types.ValidAmount(trade.Price) {
+		return &types.FieldError{Field: r.cfg.Columns.Price, Err: types.ErrFieldMalformed}
+	}
+	if _, _, err := trade.Size.Parse(record[r.size], 10); err != nil || !types.ValidAmount(trade.Size) {
+		return &types.FieldError{Field: r.cfg.Columns.Size, Err: types.ErrFieldMalformed}
+	}
+	if trade.Time, err = r.parseTime([]byte(record[r.timeIdx])); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Reader) readLine(trade *types.TradeValue) error {
+	var line []byte
+	for len(line) == 0 {
+		if !r.lines.Scan() {
+			if err := r.lines.Err(); err != nil {
+				r.line++
+				return err
+			}
+			return io.EOF
+		}
+		r.line++
+		line = r.lines.Bytes()
+		// blank lines are skipped
+		for len(line) > 0 && (line[0] == ' ' || line[0] == '\t' || line[0] == '\r') {
+			line = line[1:]
+		}
+	}
+
+	var err error
+	if trade.ProductID, err = types.ParseString(r.productIDKey, line); err != nil {
+		return err
+	}
+	if err := types.ParseBigFloat(r.priceKey, line, trade.Price); err != nil {
+		return err
+	}
+	if err := types.ParseBigFloat(r.sizeKey, line, trade.Size); err != nil {
+		return err
+	}
+	val, _, err := types.ParseVal(r.tsKey, line)
+	if err != nil {
+		return err
+	}
+	if trade.Time, err = r.parseTime(val); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Malformed returns whether err, of Read, is a malformed trade that the
+// reading may skip, as opposed to an unreadable file.
+func Malformed(err error) bool {
+	var (
+		fieldErr *types.FieldError
+		parseErr *csv.ParseError
+	)
+
+	return errors.As(err, &fieldErr) || errors.As(err, &parseErr)
+}
+
+// parseTime parses the trade time of the configured layout.
+func (r *Reader) parseTime(val []byte) (time.Time, error) {
+	var (
+		t   time.Time
+		err error
+	)
+	switch r.cfg.TimeLayout {
+	case UnixLayout:
+		t, err = parseUnix(string(val))
+	case UnixMilliLayout:
+		var ms int64
+		if ms, err = strconv.ParseInt(string(val), 10, 64); err == nil {
+			t = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+		}
+	default:
+		t, err = time.Parse(r.cfg.TimeLayout, string(val))
+	}
+	if err != nil {
+		return time.Time{}, &types.FieldError{Field: r.cfg.Columns.Time, Err: types.ErrFieldMalformed}
+	}
+
+	return t, nil
+}
+
+// parseUnix parses the Unix seconds and their optional fraction to the
+// nanosecond without the float64 rounding.
+func parseUnix(val string) (time.Time, error) {
+	secs, frac := val, ""
+	if i := strings.IndexByte(val, '.'); i >= 0 {
+		secs, frac = val[:i], val[i+1:]
+	}
+
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ns int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if ns, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil || frac[0] == '-' || frac[0] == '+' {
+			return time.Time{}, fmt.Errorf("invalid fraction %q", frac)
+		}
+	}
+
+	return time.Unix(s, ns).UTC(), nil
+}
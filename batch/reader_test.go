@@ -0,0 +1,214 @@
+package batch_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/batch"
+	"github.com/blewater/zh/types"
+)
+
+// trade is a decoded trade's fields.
+type trade struct {
+	productID, price, size string
+	time                   time.Time
+}
+
+// readAll reads the trades of the file.
+func readAll(t *testing.T, r io.Reader, cfg batch.ReaderConfig) ([]trade, error) {
+	t.Helper()
+
+	tr, err := batch.NewReader(r, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var trades []trade
+	for {
+		tv := &types.TradeValue{Price: new(big.Float), Size: new(big.Float)}
+		if err := tr.Read(tv); err == io.EOF {
+			return trades, nil
+		} else if err != nil {
+			return trades, err
+		}
+		if tv.Price.Prec() != cfg.Precision {
+			t.Errorf("price precision = %d, want %d", tv.Price.Prec(), cfg.Precision)
+		}
+		trades = append(trades, trade{tv.ProductID, tv.Price.Text('g', 20), tv.Size.Text('g', 20), tv.Time})
+	}
+}
+
+func TestReader_Read(t *testing.T) {
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 123456789, time.UTC)
+	want := []trade{
+		{"BTC-USD", "60749.99", "0.00269988", t0},
+		{"ETH-USD", "4606.8", "1.5", t0.Add(time.Second)},
+	}
+
+	gzipped := func(text string) io.Reader {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write([]byte(text)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &b
+	}
+
+	tests := []struct {
+		name    string
+		in      io.Reader
+		format  batch.InputFormat
+		columns map[string]string
+		layout  string
+	}{
+		{
+			name: "CSV",
+			in: strings.NewReader("time,product_id,price,size\n" +
+				"2021-11-10T21:00:00.123456789Z,BTC-USD,60749.99,0.00269988\n" +
+				"2021-11-10T21:00:01.123456789Z,ETH-USD,4606.8,1.5\n"),
+			format: batch.CSVInput,
+			layout: time.RFC3339Nano,
+		},
+		{
+			name: "Mapped CSV columns and Unix times",
+			in: strings.NewReader("symbol,px,qty,ts,side\n" +
+				"BTC-USD,60749.99,0.00269988,1636578000.123456789,buy\n" +
+				"ETH-USD,4606.8,1.5,1636578001.123456789,sell\n"),
+			format:  batch.CSVInput,
+			columns: map[string]string{"product_id": "symbol", "price": "px", "size": "qty", "time": "ts"},
+			layout:  batch.UnixLayout,
+		},
+		{
+			name: "JSON Lines of quoted and unquoted numbers",
+			in: strings.NewReader(`{"product_id":"BTC-USD","price":"60749.99","size":0.00269988,"time":"2021-11-10T21:00:00.123456789Z"}` + "\n\n" +
+				`{"time":"2021-11-10T21:00:01.123456789Z","size":"1.5","price":4606.8,"product_id":"ETH-USD"}`),
+			format: batch.JSONLinesInput,
+			layout: time.RFC3339Nano,
+		},
+		{
+			name: "Gzipped JSON Lines of mapped keys",
+			in: gzipped(`{"s":"BTC-USD","p":"60749.99","q":"0.00269988","T":1636578000123}` + "\n" +
+				`{"s":"ETH-USD","p":"4606.8","q":"1.5","T":1636578001123}` + "\n"),
+			format:  batch.JSONLinesInput,
+			columns: map[string]string{"product_id": "s", "price": "p", "size": "q", "time": "T"},
+			layout:  batch.UnixMilliLayout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := batch.ParseColumns(tt.columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readAll(t, tt.in, batch.ReaderConfig{
+				Format:     tt.format,
+				Columns:    columns,
+				TimeLayout: tt.layout,
+				Precision:  128,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("read %d trades, want %d", len(got), len(want))
+			}
+			for i := range want {
+				w := want[i]
+				if tt.layout == batch.UnixMilliLayout {
+					w.time = w.time.Truncate(time.Millisecond)
+				}
+				if got[i].productID != w.productID || got[i].price != w.price || got[i].size != w.size || !got[i].time.Equal(w.time) {
+					t.Errorf("trade %d = %+v, want %+v", i, got[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		format  batch.InputFormat
+		wantErr string
+	}{
+		{
+			name:    "Missing CSV column",
+			in:      "product_id,price,time\nBTC-USD,1,2021-11-10T21:00:00Z\n",
+			format:  batch.CSVInput,
+			wantErr: `missing the CSV column "size"`,
+		},
+		{
+			name:    "Malformed CSV price",
+			in:      "product_id,price,size,time\nBTC-USD,1,1,2021-11-10T21:00:00Z\nBTC-USD,x,1,2021-11-10T21:00:00Z\n",
+			format:  batch.CSVInput,
+			wantErr: "line 3: malformed field price",
+		},
+		{
+			name:    "Infinite CSV size",
+			in:      "product_id,price,size,time\nBTC-USD,1,Inf,2021-11-10T21:00:00Z\n",
+			format:  batch.CSVInput,
+			wantErr: "line 2: malformed field size",
+		},
+		{
+			name:    "Negative JSON Lines price",
+			in:      `{"product_id":"BTC-USD","price":"-1","size":"1","time":"2021-11-10T21:00:00Z"}`,
+			format:  batch.JSONLinesInput,
+			wantErr: "line 1: malformed field price",
+		},
+		{
+			name:    "Malformed JSON Lines time",
+			in:      `{"product_id":"BTC-USD","price":"1","size":"1","time":"yesterday"}`,
+			format:  batch.JSONLinesInput,
+			wantErr: "line 1: malformed field time",
+		},
+		{
+			name:    "Missing JSON Lines size",
+			in:      `{"product_id":"BTC-USD","price":"1","time":"2021-11-10T21:00:00Z"}`,
+			format:  batch.JSONLinesInput,
+			wantErr: "line 1: missing field size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readAll(t, strings.NewReader(tt.in), batch.ReaderConfig{
+				Format:     tt.format,
+				Columns:    batch.DefaultColumns(),
+				TimeLayout: time.RFC3339Nano,
+				Precision:  64,
+			})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	var fieldErr *types.FieldError
+	_, err := readAll(t, strings.NewReader(tests[1].in), batch.ReaderConfig{
+		Columns:    batch.DefaultColumns(),
+		TimeLayout: time.RFC3339Nano,
+		Precision:  64,
+	})
+	if !errors.As(err, &fieldErr) || !errors.Is(err, types.ErrFieldMalformed) {
+		t.Errorf("error %v is not a malformed field error", err)
+	}
+
+	if _, err := batch.ParseColumns(map[string]string{"qty": "size"}); err == nil {
+		t.Error("ParseColumns() of an unknown field expected an error")
+	}
+	if _, err := batch.DetectInputFormat("trades.parquet"); err == nil {
+		t.Error("DetectInputFormat() of an unknown extension expected an error")
+	}
+	if f, err := batch.DetectInputFormat("trades.jsonl.gz"); err != nil || f != batch.JSONLinesInput {
+		t.Errorf("DetectInputFormat(trades.jsonl.gz) = %v, %v", f, err)
+	}
+}
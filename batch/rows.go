@@ -0,0 +1,36 @@
+package batch
+
+import "fmt"
+
+// Rows selects the results output by the batch computation.
+type Rows uint8
+
+const (
+	// TradeRows outputs each trade's results in the trades order.
+	TradeRows Rows = iota
+	// ProductRows outputs each product's final results once every trade is
+	// computed.
+	ProductRows
+)
+
+func (r Rows) String() string {
+	switch r {
+	case TradeRows:
+		return "trade"
+	case ProductRows:
+		return "product"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseRows returns the rows matching its name.
+func ParseRows(name string) (Rows, error) {
+	for _, r := range []Rows{TradeRows, ProductRows} {
+		if r.String() == name {
+			return r, nil
+		}
+	}
+
+	return TradeRows, fmt.Errorf("unknown rows %q", name)
+}
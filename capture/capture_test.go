@@ -0,0 +1,233 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/capture"
+	"github.com/blewater/zh/log"
+	"go.uber.org/zap"
+)
+
+var testMsgs = [][]byte{
+	[]byte(`{"type":"subscriptions","channels":[{"name":"matches","product_ids":["BTC-USD"]}]}`),
+	[]byte(`{"type":"match","sequence":1,"product_id":"BTC-USD","size":"0.00269988","price":"60749.99"}`),
+	// a malformed frame is recorded as is
+	[]byte(`{"type":"match","pri`),
+	{},
+}
+
+// readAll reads the records of the capture file.
+func readAll(t *testing.T, r io.Reader) []capture.Record {
+	t.Helper()
+
+	cr, err := capture.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []capture.Record
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestWriter_Reader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1634472000, 123456789)
+	for i, msg := range testMsgs {
+		if _, err := w.Write(capture.Record{Received: t0.Add(time.Duration(i)), Msg: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recs := readAll(t, bytes.NewReader(buf.Bytes()))
+	if len(recs) != len(testMsgs) {
+		t.Fatalf("read %d records, want %d", len(recs), len(testMsgs))
+	}
+	for i, rec := range recs {
+		if !bytes.Equal(rec.Msg, testMsgs[i]) {
+			t.Errorf("record %d msg = %s, want %s", i, rec.Msg, testMsgs[i])
+		}
+		if want := t0.Add(time.Duration(i)); !rec.Received.Equal(want) {
+			t.Errorf("record %d received = %v, want %v", i, rec.Received, want)
+		}
+	}
+
+	// a truncated last record
+	cr, err := capture.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(testMsgs)-1; i++ {
+		if _, err := cr.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cr.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next() of a truncated record err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	if _, err := capture.NewReader(bytes.NewReader([]byte(`{"type":"match"}`))); err != capture.ErrNotCapture {
+		t.Errorf("NewReader() err = %v, want %v", err, capture.ErrNotCapture)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, name := range []string{"none", "gzip", "zstd"} {
+		c, err := capture.ParseCompression(name)
+		if err != nil || c.String() != name {
+			t.Errorf("ParseCompression(%s) = %v, %v", name, c, err)
+		}
+	}
+	for _, name := range []string{"lz4", "Gzip"} {
+		if _, err := capture.ParseCompression(name); err == nil {
+			t.Errorf("ParseCompression(%s) expected an error", name)
+		}
+	}
+}
+
+func TestRecorder_KeepsExistingCapture(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+
+	path := filepath.Join(t.TempDir(), "feed.vcap")
+	previous := []byte("previous capture")
+	if err := os.WriteFile(path, previous, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := capture.NewRecorder(capture.RecorderConfig{Path: path, BufferLen: len(testMsgs)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start(ctx)
+	for _, msg := range testMsgs {
+		r.Record(ctx, msg, time.Now())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kept, previous) {
+		t.Errorf("existing capture = %q, want %q", kept, previous)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(path), "feed-*.vcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("recorded %d stamped files %v, want 1", len(paths), paths)
+	}
+	if msgs := readFiles(t, paths); len(msgs) != len(testMsgs) {
+		t.Errorf("read %d messages, want %d", len(msgs), len(testMsgs))
+	}
+}
+
+// record records the test messages and returns the capture files in order.
+func record(t *testing.T, cfg capture.RecorderConfig, step time.Duration) []string {
+	t.Helper()
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+
+	cfg.Path = filepath.Join(t.TempDir(), "feed.vcap")
+	cfg.BufferLen = len(testMsgs)
+	r, err := capture.NewRecorder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start(ctx)
+	t0 := time.Now()
+	for i, msg := range testMsgs {
+		r.Record(ctx, msg, t0.Add(time.Duration(i)*step))
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Dropped() != 0 {
+		t.Errorf("Dropped() = %d, want 0", r.Dropped())
+	}
+
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(cfg.Path), "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// readFiles reads the messages of the capture files in order.
+func readFiles(t *testing.T, paths []string) [][]byte {
+	t.Helper()
+
+	var msgs [][]byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range readAll(t, f) {
+			msgs = append(msgs, rec.Msg)
+		}
+		f.Close()
+	}
+
+	return msgs
+}
+
+func TestRecorder(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   capture.RecorderConfig
+		step  time.Duration
+		files int
+	}{
+		{name: "Single file", cfg: capture.RecorderConfig{}, files: 1},
+		{name: "Gzip", cfg: capture.RecorderConfig{Compression: capture.GzipCompression}, files: 1},
+		{name: "Zstd", cfg: capture.RecorderConfig{Compression: capture.ZstdCompression}, files: 1},
+		{name: "Zstd size rotation", cfg: capture.RecorderConfig{Compression: capture.ZstdCompression, MaxSize: 1}, files: len(testMsgs)},
+		// the header and any record exceed the size
+		{name: "Size rotation", cfg: capture.RecorderConfig{MaxSize: 1}, files: len(testMsgs)},
+		{name: "Time rotation", cfg: capture.RecorderConfig{RotateEvery: time.Minute}, step: 40 * time.Second, files: 2},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				paths := record(t, tt.cfg, tt.step)
+				if len(paths) != tt.files {
+					t.Fatalf("recorded %d files %v, want %d", len(paths), paths, tt.files)
+				}
+
+				msgs := readFiles(t, paths)
+				if len(msgs) != len(testMsgs) {
+					t.Fatalf("read %d messages, want %d", len(msgs), len(testMsgs))
+				}
+				for i, msg := range msgs {
+					if !bytes.Equal(msg, testMsgs[i]) {
+						t.Errorf("message %d = %s, want %s", i, msg, testMsgs[i])
+					}
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,177 @@
+// Package capture records the raw socket messages with their receive time to
+// capture files for reproducing the feed offline.
+//
+// A capture file starts with the 8 bytes header "VWAPCAP" followed by the
+// format version. Each record is
+//   - the receive time in Unix nanoseconds as a big endian uint64
+//   - the message length as a big endian uint32
+//   - the raw message bytes
+//
+// The file may be gzip or zstd compressed as a whole.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Version is the format version written by Writer.
+const Version = 1
+
+// magic prefixes the capture files followed by the version byte.
+const magic = "VWAPCAP"
+
+// headerLen is the byte length of the file header.
+const headerLen = len(magic) + 1
+
+// recordHeaderLen is the byte length of the receive time and the message
+// length preceding each message.
+const recordHeaderLen = 8 + 4
+
+// MaxMessageLen bounds the read message length against corrupt files.
+const MaxMessageLen = 16 << 20
+
+// ErrNotCapture reports a file missing the capture header.
+var ErrNotCapture = errors.New("not a capture file")
+
+// Record is a raw socket message with its receive time.
+type Record struct {
+	Received time.Time
+	Msg      []byte
+}
+
+// Compression is the compression of the capture files.
+type Compression uint8
+
+const (
+	// NoCompression writes the records as is.
+	NoCompression Compression = iota
+	// GzipCompression gzips the file.
+	GzipCompression
+	// ZstdCompression compresses the file with zstd, faster than gzip at a
+	// similar ratio.
+	ZstdCompression
+)
+
+// zstdMagic prefixes a zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case GzipCompression:
+		return "gzip"
+	case ZstdCompression:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCompression returns the compression matching its name.
+func ParseCompression(name string) (Compression, error) {
+	for _, c := range []Compression{NoCompression, GzipCompression, ZstdCompression} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+
+	return NoCompression, fmt.Errorf("unknown compression %q", name)
+}
+
+// Writer encodes the records of a capture file.
+type Writer struct {
+	w   io.Writer
+	buf [recordHeaderLen]byte
+}
+
+// NewWriter writes the capture header to w and returns its records writer.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := w.Write(append([]byte(magic), Version)); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// Write encodes the record returning the number of bytes written.
+func (w *Writer) Write(rec Record) (int, error) {
+	binary.BigEndian.PutUint64(w.buf[:8], uint64(rec.Received.UnixNano()))
+	binary.BigEndian.PutUint32(w.buf[8:], uint32(len(rec.Msg)))
+	n, err := w.w.Write(w.buf[:])
+	if err != nil {
+		return n, err
+	}
+	m, err := w.w.Write(rec.Msg)
+
+	return n + m, err
+}
+
+// Reader decodes the records of a plain, gzip or zstd compressed capture file.
+type Reader struct {
+	r   *bufio.Reader
+	buf [recordHeaderLen]byte
+}
+
+// NewReader reads the capture header of r detecting its compression.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	if gzipped, err := br.Peek(2); err == nil && gzipped[0] == 0x1f && gzipped[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	} else if zstded, err := br.Peek(len(zstdMagic)); err == nil && bytes.Equal(zstded, zstdMagic) {
+		// a single go routine decodes synchronously, so the Reader needs
+		// no closing
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, ErrNotCapture
+	}
+	if version := header[len(magic)]; version != Version {
+		return nil, fmt.Errorf("unsupported capture version %d", version)
+	}
+
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record or io.EOF at the end of the records. A
+// truncated last record returns io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Record, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		return Record{}, err
+	}
+
+	received := int64(binary.BigEndian.Uint64(r.buf[:8]))
+	msgLen := binary.BigEndian.Uint32(r.buf[8:])
+	if msgLen > MaxMessageLen {
+		return Record{}, fmt.Errorf("capture message length %d exceeds %d", msgLen, MaxMessageLen)
+	}
+
+	msg := make([]byte, msgLen)
+	if _, err := io.ReadFull(r.r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+
+	return Record{Received: time.Unix(0, received), Msg: msg}, nil
+}
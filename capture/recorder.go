@@ -0,0 +1,262 @@
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+const (
+	// dropsLogEvery throttles the logging of the dropped records.
+	dropsLogEvery = 1000
+	// flushEvery bounds the records lingering in the write buffers.
+	flushEvery = time.Second
+	// rotatedTimeLayout stamps the rotated files' names.
+	rotatedTimeLayout = "20060102T150405.000000000Z"
+)
+
+// RecorderConfig configures the capture files of a Recorder.
+type RecorderConfig struct {
+	// Path is the capture file. Rotated files are stamped with their opening
+	// time before the extension i.e. feed-20211017T120000.000000000Z.vcap.
+	Path        string
+	Compression Compression
+	// MaxSize when positive rotates the file past these bytes written.
+	MaxSize int64
+	// RotateEvery when positive rotates the file at this age.
+	RotateEvery time.Duration
+	// BufferLen is the records queued to the writing go routine beyond which
+	// they are dropped.
+	BufferLen int
+}
+
+func (c RecorderConfig) rotates() bool {
+	return c.MaxSize > 0 || c.RotateEvery > 0
+}
+
+// Recorder writes the raw socket messages to capture files in its own go
+// routine, so that recording does not hold up the ingestion.
+type Recorder struct {
+	// dropped counts the records the full queue dropped
+	dropped atomic.Uint64
+
+	cfg RecorderConfig
+	q   chan Record
+	wg  sync.WaitGroup
+	err error
+
+	file    *os.File
+	counter *countingWriter
+	zw      io.WriteCloser
+	bw      *bufio.Writer
+	w       *Writer
+	opened  time.Time
+	// records counts the current file's records
+	records int
+}
+
+// NewRecorder creates the first capture file.
+func NewRecorder(cfg RecorderConfig) (*Recorder, error) {
+	if cfg.BufferLen <= 0 {
+		return nil, fmt.Errorf("invalid record buffer %d", cfg.BufferLen)
+	}
+
+	r := &Recorder{
+		cfg: cfg,
+		q:   make(chan Record, cfg.BufferLen),
+	}
+	if err := r.open(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Record queues the message received at the time without blocking. msg is
+// shared read-only with the recorder afterwards.
+func (r *Recorder) Record(ctx context.Context, msg []byte, received time.Time) {
+	select {
+	case r.q <- Record{Received: received, Msg: msg}:
+	default:
+		if dropped := r.dropped.Add(1); dropped%dropsLogEvery == 1 {
+			log.FromContext(ctx).Warn("slow recording dropped messages", zap.Uint64("dropped", dropped))
+		}
+	}
+}
+
+// Dropped returns the number of messages dropped by a slow recording.
+func (r *Recorder) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// Start writes the queued records until Close. Writing errors are logged and
+// stop the recording.
+func (r *Recorder) Start(ctx context.Context) {
+	logger := log.FromContext(ctx)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(flushEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case rec, ok := <-r.q:
+				if !ok {
+					return
+				}
+				if r.err != nil {
+					continue
+				}
+				if r.err = r.write(rec); r.err != nil {
+					logger.Error("recording erred", zap.String("path", r.file.Name()), zap.Error(r.err))
+				}
+			case <-ticker.C:
+				if r.err == nil {
+					r.err = r.bw.Flush()
+				}
+			}
+		}
+	}()
+}
+
+// Close writes the queued records and closes the capture file. Record must
+// not be called afterwards.
+func (r *Recorder) Close() error {
+	close(r.q)
+	r.wg.Wait()
+
+	if err := r.closeFile(); err != nil && r.err == nil {
+		r.err = err
+	}
+
+	return r.err
+}
+
+// write writes the record rotating the file beforehand when due.
+func (r *Recorder) write(rec Record) error {
+	if r.due(rec.Received) {
+		if err := r.closeFile(); err != nil {
+			return err
+		}
+		if err := r.open(rec.Received); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.w.Write(rec)
+	r.records++
+
+	return err
+}
+
+// due reports whether the file is to be rotated before writing a record
+// received at the time. A file holds at least a record. The compressed file
+// size lags the buffered records.
+func (r *Recorder) due(received time.Time) bool {
+	if r.records == 0 {
+		return false
+	}
+	if r.cfg.MaxSize > 0 && r.counter.n+int64(r.bw.Buffered()) >= r.cfg.MaxSize {
+		return true
+	}
+
+	return r.cfg.RotateEvery > 0 && received.Sub(r.opened) >= r.cfg.RotateEvery
+}
+
+// open creates the capture file opened at the time.
+func (r *Recorder) open(at time.Time) error {
+	file, err := r.create(at)
+	if err != nil {
+		return err
+	}
+
+	r.file, r.opened, r.records = file, at, 0
+	r.counter = &countingWriter{w: file}
+	var w io.Writer = r.counter
+	r.zw = nil
+	switch r.cfg.Compression {
+	case GzipCompression:
+		r.zw = gzip.NewWriter(w)
+		w = r.zw
+	case ZstdCompression:
+		if r.zw, err = zstd.NewWriter(w); err != nil {
+			return err
+		}
+		w = r.zw
+	}
+	r.bw = bufio.NewWriter(w)
+	r.w, err = NewWriter(r.bw)
+
+	return err
+}
+
+// create creates the capture file. A rotated file is stamped with the time,
+// advanced past any existing file's stamp so that none is overwritten and
+// the files sort in recording order. A file that does not rotate falls back
+// to the stamped name when its path exists, keeping the earlier capture.
+func (r *Recorder) create(at time.Time) (*os.File, error) {
+	if !r.cfg.rotates() {
+		file, err := os.OpenFile(r.cfg.Path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return file, err
+		}
+	}
+
+	for {
+		file, err := os.OpenFile(rotatedPath(r.cfg.Path, at), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return file, err
+		}
+		at = at.Add(time.Nanosecond)
+	}
+}
+
+// closeFile flushes and closes the current file.
+func (r *Recorder) closeFile() error {
+	err := r.bw.Flush()
+	if r.zw != nil {
+		if zerr := r.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// rotatedPath stamps the path with the UTC time before its extension.
+func rotatedPath(path string, at time.Time) string {
+	ext := filepath.Ext(path)
+
+	return strings.TrimSuffix(path, ext) + "-" + at.UTC().Format(rotatedTimeLayout) + ext
+}
+
+// countingWriter counts the bytes written to the file.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,114 @@
+// nolint:errcheck
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blewater/zh/batch"
+	"github.com/blewater/zh/sink"
+	"github.com/spf13/cobra"
+)
+
+// stdout is the results file name of the standard output.
+const stdout = "-"
+
+var (
+	computeIn   string
+	computeOut  string
+	inFormat    string
+	outFormat   string
+	columns     map[string]string
+	timeLayout  string
+	computeRows string
+	maxErrors   int
+)
+
+// computeCmd computes the VWAPs of historical trades files
+var computeCmd = &cobra.Command{
+	Use:   "compute --in trades.csv --out vwap.csv",
+	Short: "Computes the VWAPs of a historical trades file",
+	Long: `Computes the VWAP results of the trades of a CSV or JSON Lines file, optionally 
+gzipped, with the same windows as the live feed but without any network. The file 
+is streamed a trade at a time, so that files of several GB are computed in constant 
+memory. Each trade's product is computed with its products section's windows or 
+else the command line's. The results are written a row per trade in the trades 
+order or a row per product window once every trade is computed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if computeIn == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Please supply the trades file")
+			os.Exit(1)
+		}
+		compute := &flags.Compute
+		// the config file's products are read in compute mode
+		compute.In, compute.Out = computeIn, computeOut
+		compute.MaxErrors = maxErrors
+		parseFlags()
+		if flags.Record.Path != "" || flags.Snapshot.Path != "" || flags.HTTPAddr != "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Please record, snapshot or serve the live feed only")
+			os.Exit(1)
+		}
+		if len(flags.Venues) > 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "Please compute a single exchange's trades")
+			os.Exit(1)
+		}
+
+		var err error
+		if inFormat == "" {
+			compute.Reader.Format, err = batch.DetectInputFormat(computeIn)
+		} else {
+			compute.Reader.Format, err = batch.ParseInputFormat(inFormat)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if compute.Reader.Columns, err = batch.ParseColumns(columns); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		compute.Reader.TimeLayout = timeLayout
+		compute.Reader.Precision = flags.Precision
+		compute.Reader.RoundingMode = flags.RoundingMode
+
+		if outFormat == "" {
+			outFormat = detectOutFormat(computeOut)
+		}
+		if compute.OutFormat, err = sink.ParseFormat(outFormat); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if compute.Rows, err = batch.ParseRows(computeRows); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	computeCmd.Flags().StringVar(&computeIn, "in", "", "The CSV or JSON Lines trades file, optionally gzipped.")
+	computeCmd.Flags().StringVar(&computeOut, "out", stdout, `The results file or "-" for stdout.`)
+	computeCmd.Flags().StringVar(&inFormat, "in-format", "", "The trades file format: csv (with a header record) or jsonl. Defaults to the file's extension.")
+	computeCmd.Flags().StringVar(&outFormat, "out-format", "", "The results file format: text, jsonl or csv. Defaults to the file's extension or else text.")
+	computeCmd.Flags().StringToStringVar(&columns, "columns", nil, "The CSV columns or JSON keys of the trade fields i.e. product_id=symbol,price=px,size=qty,time=ts. Defaults to product_id, price, size and time.")
+	computeCmd.Flags().StringVar(&timeLayout, "time-layout", time.RFC3339Nano, `The trade time layout: a Go time layout, "unix" for the seconds with an optional fraction or "unixms" for the milliseconds.`)
+	computeCmd.Flags().StringVar(&computeRows, "rows", batch.TradeRows.String(), "The results rows: trade for each trade's results or product for each product's final results.")
+	computeCmd.Flags().IntVar(&maxErrors, "max-errors", 0, "The malformed trades skipped and logged before failing, or -1 to skip them all.")
+	rootCmd.AddCommand(computeCmd)
+}
+
+// detectOutFormat returns the results format name of the file's extension.
+func detectOutFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return sink.CSVFormat.String()
+	case ".jsonl", ".ndjson":
+		return sink.JSONLinesFormat.String()
+	}
+
+	return sink.TextFormat.String()
+}
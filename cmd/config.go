@@ -1,15 +1,301 @@
 package cmd
 
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/blewater/zh/batch"
+	"github.com/blewater/zh/capture"
+	"github.com/blewater/zh/exchange"
+	"github.com/blewater/zh/mockfeed"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+)
+
 type Config struct {
 	// WorkerPoolSize is the number of go routines VWAP producers
 	WorkerPoolSize uint16
 	// WindowsSize is the moving window size of VWAP data points i.e. 200
-	WindowsSize    uint16
+	WindowsSize uint16
+	// WindowDuration when positive selects the moving window of the trades
+	// within this time horizon i.e. 5m instead of WindowsSize trades
+	WindowDuration time.Duration
+	// Windows when set are the windows computed off the same trades i.e. 50,
+	// 200 and 1000 trades instead of the single window of the above
+	Windows []vwap.WindowSpec
+	// Calculators are the metrics computed off the windows i.e. vwap and
+	// twap, the vwap alone when empty
+	Calculators []vwap.Calculator
+	// Bands when set are the σ multipliers of the VWAP ±kσ bands i.e. 1 and
+	// 2, none when empty
+	Bands []float64
+	// Products are the per-product settings of the config file overriding
+	// the above windows and calculators
+	Products map[string]ProductConfig
+	// OutputPrecision is the decimal digits of the printed VWAP results
+	OutputPrecision int
+	// Sinks are the stdout encodings of the VWAP results
+	Sinks []sink.Format
+	// SinkBufferLen is the results buffered per sink beyond which a slow sink
+	// drops them
+	SinkBufferLen int
+	// HTTPAddr when set serves the HTTP API of the current and recent VWAP
+	// values i.e. ":8080"
+	HTTPAddr string
+	// Control when set serves the runtime subscribe and unsubscribe of
+	// products on the HTTP API
+	Control bool
+	// HistoryLen is the recent results retained per product for the HTTP API
+	HistoryLen int
+	// Candles when set are the intervals of the OHLCV candles served by the
+	// HTTP API i.e. 1m and 1h
+	Candles []time.Duration
+	// CandleHistoryLen is the recent candles retained per product and
+	// interval for the HTTP API
+	CandleHistoryLen int
+	// WSSendLen is the messages buffered per WebSocket client of the HTTP API
+	// beyond which a slow client is dropped
+	WSSendLen int
+	// WSOrigins are the browser origins allowed to the WebSocket updates of
+	// the HTTP API besides its own or "*" for any
+	WSOrigins []string
+	// Record when its path is set captures the raw socket messages
+	Record capture.RecorderConfig
+	// Snapshot when its path is set saves the windows for warm restarts
+	Snapshot SnapshotConfig
+	// Replay when its paths are set drives the pipeline off the capture
+	// files instead of the socket
+	Replay ReplayConfig
+	// Compute when its input is set computes the VWAPs of a trades file
+	// instead of the socket's
+	Compute ComputeConfig
+	// MockFeed when its address is set serves the mock feed instead of
+	// calculating VWAPs
+	MockFeed MockFeedConfig
+	// Anchor when set selects the anchored session VWAP reset on its
+	// schedule instead of a moving window
+	Anchor vwap.Schedule
 	// True for development level logging, false for production.
-	DevLogLevel    bool
-	CfgFile        string
-	// SocketURL is the host URL for the matches channel
-	SocketURL      string
+	DevLogLevel bool
+	CfgFile     string
+	// Exchange selects the adapter of the trade stream
+	Exchange exchange.Kind
+	// SocketURL is the host URL for the exchange's trade stream
+	SocketURL string
 	// Products IDs to subscribe trades "BTC-USD","ETH-USD","ETH-BTC"
-	ProductIDs     []string
+	ProductIDs []string
+	// Venues when set stream several exchanges at once instead of the above
+	// exchange's products. Each venue's products are keyed by venue i.e.
+	// "binance:BTC-USDT".
+	Venues []VenueConfig
+	// Consolidated are the canonical instruments whose VWAP spans the venues
+	Consolidated []InstrumentConfig
+	// ReconnectMinDelay is the first redial delay after the socket drops
+	ReconnectMinDelay time.Duration
+	// ReconnectMaxDelay caps the exponentially growing redial delay
+	ReconnectMaxDelay time.Duration
+	// GapPolicy is the action taken on a product's VWAP upon a sequence gap
+	GapPolicy types.GapPolicy
+	// Precision is the mantissa bits of the parsed trade prices and sizes
+	Precision uint
+	// RoundingMode rounds the parsed decimal prices and sizes to Precision
+	RoundingMode big.RoundingMode
+	// Engine selects the big.Float or the fixed-point VWAP arithmetic
+	Engine vwap.EngineKind
+	// FixedScale is the decimal digits of the fixed-point engine integers of
+	// the products without their own
+	FixedScale vwap.FixedScale
+}
+
+// ReplayConfig is the capture files of a replay.
+type ReplayConfig struct {
+	// Paths are the capture files replayed in order
+	Paths []string
+	// Speed is the replay speed up of the recorded pace i.e. 1 for the
+	// original and 10 for 10x or else 0 for as fast as possible
+	Speed float64
+}
+
+// ComputeConfig is the offline VWAP computation of a trades file.
+type ComputeConfig struct {
+	// In is the CSV or JSON Lines trades file
+	In string
+	// Out is the results file or stdout when "-"
+	Out string
+	// Reader decodes the trades file
+	Reader batch.ReaderConfig
+	// OutFormat is the encoding of the results file
+	OutFormat sink.Format
+	// Rows selects a row per trade or a row per product
+	Rows batch.Rows
+	// MaxErrors is the malformed trades skipped before failing or else
+	// negative for skipping them all
+	MaxErrors int
+}
+
+// SnapshotConfig is the windows' state saved across restarts.
+type SnapshotConfig struct {
+	// Path is the state file restored on startup
+	Path string
+	// Interval is the period of the state saves besides the one on shutdown
+	Interval time.Duration
+	// MaxAge is the age beyond which a state is not restored
+	MaxAge time.Duration
+}
+
+// MockFeedConfig is the served mock feed.
+type MockFeedConfig struct {
+	// Addr is the listening address i.e. ":8765"
+	Addr string
+	Feed mockfeed.Config
+}
+
+// VenueConfig is an exchange's trade stream among several.
+type VenueConfig struct {
+	Exchange exchange.Kind
+	// SocketURL is the venue's trade stream URL
+	SocketURL  string
+	ProductIDs []string
+}
+
+// Name names the venue by its exchange.
+func (v VenueConfig) Name() string {
+	return v.Exchange.String()
+}
+
+// Key returns the venue qualified product ID i.e. "binance:BTC-USDT".
+func (v VenueConfig) Key(productID string) string {
+	return v.Name() + ":" + productID
+}
+
+// InstrumentConfig is a canonical instrument consolidating venue products.
+type InstrumentConfig struct {
+	// Instrument is the canonical product ID i.e. "BTC-USD"
+	Instrument string
+	// Weights scale the trade sizes of the venue qualified products. A zero
+	// weight excludes the venue.
+	Weights map[string]float64
+}
+
+// ProductConfig is a product's settings of the config file.
+type ProductConfig struct {
+	// Windows are the product's windows computed off the same trades
+	Windows []vwap.WindowSpec
+	// Calculators are the metrics computed off the product's windows
+	Calculators []vwap.Calculator
+	// Bands are the σ multipliers of the product's VWAP bands
+	Bands []float64
+	// OutputPrecision is the decimal digits of the printed results
+	OutputPrecision int
+	// FixedScale is the decimal digits of the product's fixed-point engine
+	// integers
+	FixedScale vwap.FixedScale
+}
+
+// Product returns the product's settings of the config file or else those of
+// the command line. The config file's products outside the product IDs, of
+// the offline compute, match case insensitively.
+func (c Config) Product(productID string) ProductConfig {
+	if product, ok := c.Products[productID]; ok {
+		return product
+	}
+	for p, product := range c.Products {
+		if strings.EqualFold(p, productID) {
+			return product
+		}
+	}
+
+	return ProductConfig{
+		Windows:         c.WindowSpecs(),
+		Calculators:     c.CalculatorsOrDefault(),
+		Bands:           c.Bands,
+		OutputPrecision: c.OutputPrecision,
+		FixedScale:      c.FixedScale,
+	}
+}
+
+// ProductScales returns the fixed-point scales of the products of the config
+// file.
+func (c Config) ProductScales() map[string]vwap.FixedScale {
+	scales := make(map[string]vwap.FixedScale, len(c.Products))
+	for p, product := range c.Products {
+		scales[p] = product.FixedScale
+	}
+
+	return scales
+}
+
+// CalculatorsOrDefault returns the configured calculators or else the VWAP.
+func (c Config) CalculatorsOrDefault() []vwap.Calculator {
+	if len(c.Calculators) > 0 {
+		return c.Calculators
+	}
+
+	return []vwap.Calculator{vwap.VWAPCalculator}
+}
+
+// ProductWindows returns the windows of each of the engine's products.
+func (c Config) ProductWindows() map[string][]vwap.WindowSpec {
+	productIDs := c.EngineProductIDs()
+	windows := make(map[string][]vwap.WindowSpec, len(productIDs))
+	for _, p := range productIDs {
+		windows[p] = c.Product(p).Windows
+	}
+
+	return windows
+}
+
+// EngineProductIDs returns the products computed by the VWAP engine: the
+// venues' qualified products followed by the consolidated instruments, or
+// else the product IDs.
+func (c Config) EngineProductIDs() []string {
+	if len(c.Venues) == 0 {
+		return c.ProductIDs
+	}
+
+	var productIDs []string
+	for _, venue := range c.Venues {
+		for _, p := range venue.ProductIDs {
+			productIDs = append(productIDs, venue.Key(p))
+		}
+	}
+	for _, instrument := range c.Consolidated {
+		productIDs = append(productIDs, instrument.Instrument)
+	}
+
+	return productIDs
+}
+
+// Enabled returns whether the calculator applies to the product.
+func (p ProductConfig) Enabled(calculator vwap.Calculator) bool {
+	for _, c := range p.Calculators {
+		if c == calculator {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WindowSpecs returns the configured windows.
+func (c Config) WindowSpecs() []vwap.WindowSpec {
+	if len(c.Windows) > 0 {
+		return c.Windows
+	}
+
+	return []vwap.WindowSpec{c.WindowSpec()}
+}
+
+// WindowSpec returns the configured single window.
+func (c Config) WindowSpec() vwap.WindowSpec {
+	if c.Anchor != nil {
+		return vwap.AnchoredWindowSpec(c.Anchor)
+	}
+	if c.WindowDuration > 0 {
+		return vwap.TimeWindowSpec(c.WindowDuration)
+	}
+
+	return vwap.CountWindowSpec(c.WindowsSize)
 }
@@ -0,0 +1,60 @@
+// nolint:errcheck
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/blewater/zh/mockfeed"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fixturePath  string
+	mockFeedAddr string
+)
+
+// mockFeedCmd serves a local mock of the Coinbase matches feed
+var mockFeedCmd = &cobra.Command{
+	Use:   "mock-feed",
+	Short: "Serves a local mock Coinbase matches feed",
+	Long: `Serves a local mock of the Coinbase matches feed speaking its subscribe, 
+subscriptions, match, last_match and error messages. The trades follow a seeded 
+random walk or replay a fixture, and disconnects, malformed frames and delays may 
+be injected. Point the calculator to it with --url ws://localhost:8765.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if mockFeedAddr == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Please supply the mock feed's listening address")
+			os.Exit(1)
+		}
+		flags.MockFeed.Addr = mockFeedAddr
+		if fixturePath != "" {
+			fixture, err := mockfeed.LoadFixture(fixturePath)
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			flags.MockFeed.Feed.Fixture = fixture
+		}
+		feed := flags.MockFeed.Feed
+		if feed.Interval < 0 || feed.Delay < 0 || feed.DisconnectAfter < 0 || feed.MalformedEvery < 0 || feed.DelayEvery < 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "Please supply non negative mock feed settings")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	feed := &flags.MockFeed.Feed
+	mockFeedCmd.Flags().StringVar(&mockFeedAddr, "addr", ":8765", "The listening address of the mock feed.")
+	mockFeedCmd.Flags().Int64Var(&feed.Seed, "seed", 1, "The seed of the random walk trades.")
+	mockFeedCmd.Flags().Float64Var(&feed.StartPrice, "start-price", 100, "The random walk's starting price of each product.")
+	mockFeedCmd.Flags().DurationVar(&feed.Interval, "interval", 0, "The pause between a connection's trades i.e. 10ms. None when 0.")
+	mockFeedCmd.Flags().StringVar(&fixturePath, "fixture", "", "A capture file recorded by --record or a file of a message per line sent in order instead of the random walk trades.")
+	mockFeedCmd.Flags().IntVar(&feed.DisconnectAfter, "disconnect-after", 0, "Drops each connection without a close frame after these trades. Disabled when 0.")
+	mockFeedCmd.Flags().IntVar(&feed.MalformedEvery, "malformed-every", 0, "Truncates every n-th trade frame. Disabled when 0.")
+	mockFeedCmd.Flags().IntVar(&feed.DelayEvery, "delay-every", 0, "Stalls --delay before every n-th trade. Disabled when 0.")
+	mockFeedCmd.Flags().DurationVar(&feed.Delay, "delay", 0, "The stall of every --delay-every trade.")
+	rootCmd.AddCommand(mockFeedCmd)
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blewater/zh/vwap"
+	"github.com/spf13/viper"
+)
+
+// productsKey is the config file's section of the per-product settings keyed
+// by the venue qualified products and the instruments when streaming several
+// venues i.e.
+//
+//	products:
+//	  BTC-USD:
+//	    window_type: count
+//	    window_size: 1000
+//	    calculators: [vwap, twap]
+//	    bands: [1, 2]
+//	    output_precision: 2
+//	    price_decimals: 2
+//	    size_decimals: 8
+//	  ETH-BTC:
+//	    window_type: time
+//	    window_duration: 5m
+const productsKey = "products"
+
+// productSection is a product's settings in the config file. The unset ones
+// take on the command line's.
+type productSection struct {
+	// WindowType is count (default), time or anchored
+	WindowType     string        `mapstructure:"window_type"`
+	WindowSize     uint16        `mapstructure:"window_size"`
+	WindowDuration time.Duration `mapstructure:"window_duration"`
+	Anchor         string        `mapstructure:"anchor"`
+	// Windows are several count or time windows i.e. [50, 200, 5m]
+	// instead of the single window of the above
+	Windows         []string  `mapstructure:"windows"`
+	Calculators     []string  `mapstructure:"calculators"`
+	Bands           []float64 `mapstructure:"bands"`
+	OutputPrecision *int      `mapstructure:"output_precision"`
+	// PriceDecimals and SizeDecimals are the fixed-point engine's scale
+	PriceDecimals *uint8 `mapstructure:"price_decimals"`
+	SizeDecimals  *uint8 `mapstructure:"size_decimals"`
+}
+
+// readProducts returns the products' settings of the config file keyed by the
+// matching configured product IDs. The offline compute takes on the other
+// products too, keyed as in the config file, as it adds the file's products.
+func readProducts(c Config) (map[string]ProductConfig, error) {
+	var sections map[string]productSection
+	if err := viper.UnmarshalKey(productsKey, &sections); err != nil {
+		return nil, fmt.Errorf("config %s section: %w", productsKey, err)
+	}
+
+	products := make(map[string]ProductConfig, len(sections))
+	for key, section := range sections {
+		// the config keys are case insensitive
+		productID := ""
+		for _, p := range c.EngineProductIDs() {
+			if strings.EqualFold(p, key) {
+				productID = p
+			}
+		}
+		if productID == "" && c.Compute.In != "" {
+			productID = key
+		}
+		if productID == "" {
+			return nil, fmt.Errorf("config product %s is not among the products IDs %v", key, c.EngineProductIDs())
+		}
+
+		product, err := section.productConfig(c)
+		if err != nil {
+			return nil, fmt.Errorf("config product %s: %w", productID, err)
+		}
+		products[productID] = product
+	}
+
+	return products, nil
+}
+
+// productConfig returns the section's settings over those of c.
+func (s productSection) productConfig(c Config) (ProductConfig, error) {
+	product := ProductConfig{
+		Windows:         c.WindowSpecs(),
+		Calculators:     c.CalculatorsOrDefault(),
+		Bands:           c.Bands,
+		OutputPrecision: c.OutputPrecision,
+		FixedScale:      c.FixedScale,
+	}
+
+	switch {
+	case len(s.Windows) > 0:
+		product.Windows = nil
+		for _, label := range s.Windows {
+			window, err := vwap.ParseWindowSpec(label)
+			if err != nil {
+				return product, err
+			}
+			product.Windows = append(product.Windows, window)
+		}
+	case s.WindowType == "" && s.WindowSize > 0, s.WindowType == vwap.CountWindow.String():
+		size := s.WindowSize
+		if size == 0 {
+			size = c.WindowsSize
+		}
+		product.Windows = []vwap.WindowSpec{vwap.CountWindowSpec(size)}
+	case s.WindowType == vwap.TimeWindow.String():
+		product.Windows = []vwap.WindowSpec{vwap.TimeWindowSpec(s.WindowDuration)}
+	case s.WindowType == vwap.AnchoredWindow.String():
+		anchor, err := vwap.ParseSchedule(s.Anchor)
+		if err != nil {
+			return product, err
+		}
+		product.Windows = []vwap.WindowSpec{vwap.AnchoredWindowSpec(anchor)}
+	case s.WindowType != "":
+		return product, fmt.Errorf("unknown window type %q", s.WindowType)
+	}
+	for _, window := range product.Windows {
+		if err := window.Validate(); err != nil {
+			return product, err
+		}
+	}
+
+	if len(s.Calculators) > 0 {
+		product.Calculators = nil
+		for _, name := range s.Calculators {
+			calculator, err := vwap.ParseCalculator(name)
+			if err != nil {
+				return product, err
+			}
+			product.Calculators = append(product.Calculators, calculator)
+		}
+	}
+
+	if len(s.Bands) > 0 {
+		product.Bands = s.Bands
+	}
+
+	if s.OutputPrecision != nil {
+		if *s.OutputPrecision < 0 {
+			return product, fmt.Errorf("negative output precision %d", *s.OutputPrecision)
+		}
+		product.OutputPrecision = *s.OutputPrecision
+	}
+
+	if s.PriceDecimals != nil {
+		product.FixedScale.PriceDecimals = *s.PriceDecimals
+	}
+	if s.SizeDecimals != nil {
+		product.FixedScale.SizeDecimals = *s.SizeDecimals
+	}
+
+	return product, nil
+}
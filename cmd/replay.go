@@ -0,0 +1,69 @@
+// nolint:errcheck
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// maxSpeed replays the capture as fast as possible.
+const maxSpeed = "max"
+
+var speed string
+
+// replayCmd drives the pipeline off capture files recorded by --record
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>...",
+	Short: "Replays capture files through the VWAP pipeline",
+	Long: `Replays the raw messages of capture files recorded by --record through the same 
+parsing, workers and VWAP windows as the live feed without any network. Rotated 
+files are replayed in the given order. The results depend on the recorded trades 
+alone, so that a market session can regression test the VWAP math.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		parseFlags()
+		if flags.Record.Path != "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Please record the live feed only")
+			os.Exit(1)
+		}
+		if flags.Snapshot.Path != "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Please snapshot the live feed's windows only")
+			os.Exit(1)
+		}
+		if len(flags.Venues) > 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "Please replay a single exchange's capture")
+			os.Exit(1)
+		}
+
+		var err error
+		flags.Replay.Speed, err = parseSpeed(speed)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		flags.Replay.Paths = args
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&speed, "speed", "1x", `The replay pace: "1x" for the recorded one, a speed up i.e. "2x" or "10x" or "max" for as fast as possible.`)
+	rootCmd.AddCommand(replayCmd)
+}
+
+// parseSpeed returns the speed up of its "10x" or "10" text or 0 for max.
+func parseSpeed(text string) (float64, error) {
+	if text == maxSpeed {
+		return 0, nil
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(text, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q", text)
+	}
+
+	return speed, nil
+}
@@ -3,15 +3,36 @@ package cmd
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/blewater/zh/capture"
+	"github.com/blewater/zh/exchange"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var flags Config
+var (
+	flags        Config
+	gapPolicy    string
+	roundingMode string
+	engine       string
+	anchor       string
+	windows      []string
+	calculators  []string
+	compression  string
+	exchangeName string
+)
+
+// sinkKey is the flag and config file key of the results sinks.
+const sinkKey = "sink"
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -21,37 +42,221 @@ var rootCmd = &cobra.Command{
 to the coinbase websocket feed to stream in trade executions and update the VWAP 
 for each trading pair as updates become available.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if flags.WorkerPoolSize == 0 {
-			_, _ = fmt.Fprintln(os.Stderr, "Please supply a positive workers pool number")
+		parseFlags()
+	},
+}
+
+// parseFlags validates the flags shared by the commands into their typed
+// configuration exiting on the first invalid one.
+func parseFlags() {
+	if flags.WorkerPoolSize == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Please supply a positive workers pool number")
+		os.Exit(1)
+	}
+	if len(flags.ProductIDs) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Please supply products IDs")
+		os.Exit(1)
+	}
+	regexc, err := regexp.Compile("[A-Z]{3}-[A-Z]{3}")
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	for i, product := range flags.ProductIDs {
+		product = strings.TrimSpace(product)
+		match := regexc.Match([]byte(product))
+		if !match {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid product ID position:%d, %s %s\n", i, product, err)
+			os.Exit(1)
+		}
+		flags.ProductIDs[i] = product
+	}
+	flags.Exchange, err = exchange.ParseKind(exchangeName)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if flags.SocketURL == "" {
+		feed, err := exchange.New(flags.Exchange, flags.ProductIDs)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		if len(flags.ProductIDs) == 0 {
-			_, _ = fmt.Fprintln(os.Stderr, "Please supply products IDs")
+		flags.SocketURL = feed.DefaultURL()
+	}
+	flags.GapPolicy, err = types.ParseGapPolicy(gapPolicy)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if flags.Precision == 0 || flags.Precision > big.MaxPrec {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid precision %d\n", flags.Precision)
+		os.Exit(1)
+	}
+	flags.RoundingMode, err = parseRoundingMode(roundingMode)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	flags.Engine, err = vwap.ParseEngineKind(engine)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if anchor != "" {
+		if flags.WindowDuration > 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "Please supply either a window duration or an anchor")
 			os.Exit(1)
 		}
-		regexc, err := regexp.Compile("[A-Z]{3}-[A-Z]{3}")
+		flags.Anchor, err = vwap.ParseSchedule(anchor)
 		if err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		for i, product := range flags.ProductIDs {
-			product = strings.TrimSpace(product)
-			match := regexc.Match([]byte(product))
-			if !match {
-				_, _ = fmt.Fprintf(os.Stderr, "Invalid product ID position:%d, %s %s\n", i, product, err)
+	}
+	for _, label := range windows {
+		window, err := vwap.ParseWindowSpec(label)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		flags.Windows = append(flags.Windows, window)
+	}
+	if len(flags.Windows) > 0 && flags.Anchor != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Please supply either windows or an anchor")
+		os.Exit(1)
+	}
+	for _, name := range calculators {
+		calculator, err := vwap.ParseCalculator(strings.TrimSpace(name))
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		flags.Calculators = append(flags.Calculators, calculator)
+	}
+	if flags.OutputPrecision < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid output precision %d\n", flags.OutputPrecision)
+		os.Exit(1)
+	}
+	for _, name := range viper.GetStringSlice(sinkKey) {
+		format, err := sink.ParseFormat(name)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		flags.Sinks = append(flags.Sinks, format)
+	}
+	if flags.SinkBufferLen <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid sink buffer %d\n", flags.SinkBufferLen)
+		os.Exit(1)
+	}
+	if flags.Control && flags.HTTPAddr == "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Please supply the HTTP API address of the control API")
+		os.Exit(1)
+	}
+	if flags.HistoryLen <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid history length %d\n", flags.HistoryLen)
+		os.Exit(1)
+	}
+	if len(flags.Candles) > 0 {
+		if flags.HTTPAddr == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "Please supply the HTTP API address of the candles")
+			os.Exit(1)
+		}
+		if flags.Engine != vwap.BigFloatEngine {
+			_, _ = fmt.Fprintf(os.Stderr, "The %s engine does not aggregate candles\n", flags.Engine)
+			os.Exit(1)
+		}
+		if flags.CandleHistoryLen <= 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid candle history length %d\n", flags.CandleHistoryLen)
+			os.Exit(1)
+		}
+	}
+	if flags.WSSendLen <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid WebSocket buffer %d\n", flags.WSSendLen)
+		os.Exit(1)
+	}
+	flags.Record.Compression, err = capture.ParseCompression(compression)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if flags.Record.BufferLen <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid record buffer %d\n", flags.Record.BufferLen)
+		os.Exit(1)
+	}
+	if flags.Record.MaxSize < 0 || flags.Record.RotateEvery < 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "Please supply non negative record rotation limits")
+		os.Exit(1)
+	}
+	if flags.Snapshot.Path != "" {
+		if flags.Snapshot.Interval <= 0 || flags.Snapshot.MaxAge <= 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "Please supply positive snapshot interval and max age")
+			os.Exit(1)
+		}
+		if flags.Engine != vwap.BigFloatEngine {
+			_, _ = fmt.Fprintf(os.Stderr, "The %s engine does not snapshot its windows\n", flags.Engine)
+			os.Exit(1)
+		}
+	}
+	flags.Venues, flags.Consolidated, err = readVenues()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if len(flags.Venues) > 0 && flags.Record.Path != "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Please record a single exchange's feed")
+		os.Exit(1)
+	}
+	if len(flags.Consolidated) > 0 && flags.Engine != vwap.BigFloatEngine {
+		_, _ = fmt.Fprintf(os.Stderr, "The %s engine does not consolidate venues\n", flags.Engine)
+		os.Exit(1)
+	}
+	flags.Products, err = readProducts(flags)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	for _, productID := range flags.EngineProductIDs() {
+		product := flags.Product(productID)
+		for _, calculator := range product.Calculators {
+			if flags.Engine == vwap.FixedEngine && calculator != vwap.VWAPCalculator {
+				_, _ = fmt.Fprintf(os.Stderr, "The fixed engine does not compute the %s\n", calculator)
 				os.Exit(1)
 			}
-			flags.ProductIDs[i] = product
 		}
-	},
+		if len(product.Bands) > 0 && flags.Engine == vwap.FixedEngine {
+			_, _ = fmt.Fprintln(os.Stderr, "The fixed engine does not compute the VWAP bands")
+			os.Exit(1)
+		}
+		for _, k := range product.Bands {
+			if !(k > 0) || math.IsInf(k, 1) {
+				_, _ = fmt.Fprintf(os.Stderr, "Invalid band multiplier %v\n", k)
+				os.Exit(1)
+			}
+		}
+	}
+	for _, windows := range flags.ProductWindows() {
+		for _, window := range windows {
+			if err := window.Validate(); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			if flags.Engine == vwap.FixedEngine && window.Kind != vwap.CountWindow {
+				_, _ = fmt.Fprintln(os.Stderr, "The fixed engine supports count windows only")
+				os.Exit(1)
+			}
+		}
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() Config {
-	cobra.CheckErr(rootCmd.Execute())
+	executed, err := rootCmd.ExecuteC()
+	cobra.CheckErr(err)
 
-	if rootCmd.Flags().Lookup("help").Value.String() == "true" {
+	if executed.Flags().Lookup("help").Value.String() == "true" {
 		os.Exit(0)
 	}
 
@@ -62,19 +267,63 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVarP(&flags.CfgFile, "config", "c", "", "config file (default is $HOME/.vwap.yaml)")
 	rootCmd.PersistentFlags().StringSliceVarP(&flags.ProductIDs, "productids", "p", []string{"BTC-USD", "ETH-USD", "ETH-BTC"}, "The comma separated trading product ID pairs to calculate the current 200 VWAP data points e.g. BTC-USD, ETH-USD, ETH-BTC")
-	rootCmd.PersistentFlags().StringVarP(&flags.SocketURL, "url", "u", "wss://ws-feed.exchange.coinbase.com", "The Coinbase URL with two choices: wss://ws-feed.exchange.coinbase.com --OR-- wss://ws-feed-public.sandbox.exchange.coinbase.com")
+	rootCmd.PersistentFlags().StringVarP(&flags.SocketURL, "url", "u", "", "The exchange's trade stream URL. Defaults to the exchange's public feed i.e. wss://ws-feed.exchange.coinbase.com --OR-- the Coinbase sandbox wss://ws-feed-public.sandbox.exchange.coinbase.com")
+	rootCmd.PersistentFlags().StringVar(&exchangeName, "exchange", exchange.CoinbaseExchange.String(), "The exchange of the trade stream: coinbase (matches channel) or binance (@trade streams of the products' symbols i.e. BTC-USDT as BTCUSDT).")
 	rootCmd.PersistentFlags().Uint16VarP(&flags.WorkerPoolSize, "workers", "w", 5, "The workers pool size for processing the ingested trades. There is a performance affinity between the Go routines and number of products to subscribe.")
 	rootCmd.PersistentFlags().Uint16VarP(&flags.WindowsSize, "windowsize", "s", 200, "The VWAP moving data points windows size. Defaults to 200.")
+	rootCmd.PersistentFlags().DurationVar(&flags.WindowDuration, "window-duration", 0, "The VWAP moving window time horizon i.e. 5m instead of the windowsize trades. Expired trades are evicted on every new trade and on a timer.")
+	rootCmd.PersistentFlags().DurationVar(&flags.ReconnectMinDelay, "reconnect-min", 500*time.Millisecond, "The first delay before redialing a dropped socket connection. Subsequent delays grow exponentially with jitter.")
+	rootCmd.PersistentFlags().DurationVar(&flags.ReconnectMaxDelay, "reconnect-max", 30*time.Second, "The maximum delay between socket redial attempts.")
+	rootCmd.PersistentFlags().StringVar(&gapPolicy, "gap-policy", types.GapPolicySuspect.String(), "The action upon a product's trades sequence gap or regression: ignore, suspect (flag the VWAP results until the window rolls past the gap) or reset (empty the product's window).")
+	rootCmd.PersistentFlags().UintVar(&flags.Precision, "precision", 128, "The mantissa bits of the big.Float trade prices and sizes parsed straight from the exchange's decimal text.")
+	rootCmd.PersistentFlags().StringVar(&roundingMode, "rounding", big.ToNearestEven.String(), "The rounding mode of the parsed decimal prices and sizes: ToNearestEven, ToNearestAway, ToZero, AwayFromZero, ToNegativeInf, ToPositiveInf.")
+	rootCmd.PersistentFlags().StringSliceVar(&windows, "windows", nil, "The VWAP windows computed off the same trades i.e. 50,200,1000 trades or 5m durations instead of the single windowsize or window-duration window. Each result is labelled with its window.")
+	rootCmd.PersistentFlags().StringVar(&anchor, "anchor", "", `The anchored session VWAP reset schedule instead of a moving window: "midnight" (UTC), a time of day i.e. "17:00" or a 5 fields cron expression i.e. "0 17 * * 1-5", optionally time zone prefixed i.e. "TZ=America/New_York 17:00". A session close VWAP precedes each reset.`)
+	rootCmd.PersistentFlags().StringSliceVar(&calculators, "calculators", []string{vwap.VWAPCalculator.String()}, "The metrics computed off the same windows and trades: vwap and twap (each trade price weighted by how long it stood until the next trade). Each result is labelled with its metric. A product's section of the config file may override it.")
+	rootCmd.PersistentFlags().Float64SliceVar(&flags.Bands, "bands", nil, "The σ multipliers of the VWAP ±kσ bands i.e. 1,2 computed off each window's volume-weighted price variance. Each VWAP result then carries its standard deviation and its lower and upper bands. A product's section of the config file may override it. Disabled when empty.")
+	rootCmd.PersistentFlags().IntVar(&flags.OutputPrecision, "output-precision", 6, "The decimal digits of the printed VWAP results. A product's section of the config file may override it.")
+	rootCmd.PersistentFlags().StringSlice(sinkKey, []string{sink.TextFormat.String()}, "The comma separated stdout sinks of the VWAP results: text, jsonl (JSON Lines) or csv. Also the sink list of the config file.")
+	rootCmd.PersistentFlags().IntVar(&flags.SinkBufferLen, "sink-buffer", 1024, "The results buffered per sink. A slow sink drops the results beyond it without blocking the other sinks.")
+	rootCmd.PersistentFlags().StringVar(&flags.HTTPAddr, "http-addr", "", "The listening address of the HTTP API i.e. :8080 serving GET /vwap, /vwap/{productID} and /vwap/{productID}/history?limit=N. Disabled when empty.")
+	rootCmd.PersistentFlags().BoolVar(&flags.Control, "control", false, "Serves the runtime subscriptions on the HTTP API: GET /products lists the products, PUT /products/{productID} subscribes a product and DELETE /products/{productID} unsubscribes it without restarting.")
+	rootCmd.PersistentFlags().IntVar(&flags.HistoryLen, "history", 100, "The recent VWAP results retained per product for the HTTP API history.")
+	rootCmd.PersistentFlags().DurationSliceVar(&flags.Candles, "candles", nil, "The intervals of the OHLCV candles of each product i.e. 1s,1m,5m,1h served by the HTTP API at GET /candles/{productID}?interval=1m&limit=N. The candles close on the intervals' wall-clock boundaries, flat at the previous close without trades. Disabled when empty.")
+	rootCmd.PersistentFlags().IntVar(&flags.CandleHistoryLen, "candle-history", 500, "The recent candles retained per product and interval for the HTTP API.")
+	rootCmd.PersistentFlags().IntVar(&flags.WSSendLen, "ws-buffer", 256, "The messages buffered per client of the HTTP API's /ws WebSocket updates. A slower client is dropped.")
+	rootCmd.PersistentFlags().StringSliceVar(&flags.WSOrigins, "ws-origins", nil, "The browser origins allowed to the HTTP API's /ws WebSocket updates i.e. https://example.com, or * for any. Defaults to the same origin only.")
+	rootCmd.PersistentFlags().StringVar(&flags.Record.Path, "record", "", "The capture file recording every raw socket message with its receive time for reproducing the feed offline. Disabled when empty.")
+	rootCmd.PersistentFlags().StringVar(&compression, "record-compress", capture.NoCompression.String(), "The capture file compression: none, gzip or zstd.")
+	rootCmd.PersistentFlags().Int64Var(&flags.Record.MaxSize, "record-max-size", 0, "Rotates the capture file past these bytes. Rotated files are stamped with their opening time. Disabled when 0.")
+	rootCmd.PersistentFlags().DurationVar(&flags.Record.RotateEvery, "record-rotate", 0, "Rotates the capture file at this age i.e. 1h. Disabled when 0.")
+	rootCmd.PersistentFlags().IntVar(&flags.Record.BufferLen, "record-buffer", 4096, "The messages queued for recording. A slow disk drops the messages beyond it without holding up the ingestion.")
+	rootCmd.PersistentFlags().StringVar(&flags.Snapshot.Path, "snapshot", "", "The file saving the VWAP windows periodically and on shutdown. The windows are restored from it on startup, so that the VWAPs carry on across restarts. Disabled when empty.")
+	rootCmd.PersistentFlags().DurationVar(&flags.Snapshot.Interval, "snapshot-interval", 30*time.Second, "The period of the windows snapshots besides the one on shutdown.")
+	rootCmd.PersistentFlags().DurationVar(&flags.Snapshot.MaxAge, "snapshot-max-age", 5*time.Minute, "The age beyond which a snapshot is discarded on startup rather than restored.")
+	rootCmd.PersistentFlags().StringVar(&engine, "engine", vwap.BigFloatEngine.String(), "The VWAP arithmetic: bigfloat or fixed for the higher throughput fixed-point integers.")
+	rootCmd.PersistentFlags().Uint8Var(&flags.FixedScale.PriceDecimals, "price-decimals", 8, "The fixed engine's price decimal digits of the products without their config price_decimals. Excess digits are rounded.")
+	rootCmd.PersistentFlags().Uint8Var(&flags.FixedScale.SizeDecimals, "size-decimals", 8, "The fixed engine's size decimal digits of the products without their config size_decimals. Excess digits are rounded.")
 	rootCmd.PersistentFlags().BoolVarP(
 		&flags.DevLogLevel, "devlogging", "d", false,
 		`by default logging is set to production level generating structured log entries suitable for machine processing i.e. Kafka. This offers the chance to override this to development level for human friendly log output`,
 	)
+	viper.BindPFlag(sinkKey, rootCmd.PersistentFlags().Lookup(sinkKey))
 	viper.BindPFlag("author", rootCmd.PersistentFlags().Lookup("Mario Karagiorgas"))
 	viper.BindPFlag("useViper", rootCmd.PersistentFlags().Lookup("viper"))
 	viper.SetDefault("author", "Mario Karagiorgas salem8@gmail.com")
 	viper.SetDefault("license", "MIT")
 }
 
+// parseRoundingMode returns the big.RoundingMode matching its name.
+func parseRoundingMode(name string) (big.RoundingMode, error) {
+	for mode := big.ToNearestEven; mode <= big.ToPositiveInf; mode++ {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+
+	return big.ToNearestEven, fmt.Errorf("unknown rounding mode %q", name)
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if flags.CfgFile != "" {
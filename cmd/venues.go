@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/blewater/zh/exchange"
+	"github.com/spf13/viper"
+)
+
+// venuesKey is the config file's section of the venues streamed at once i.e.
+//
+//	venues:
+//	  - exchange: coinbase
+//	    products: [BTC-USD]
+//	  - exchange: binance
+//	    url: wss://stream.binance.com:9443/ws
+//	    products: [BTC-USDT]
+const venuesKey = "venues"
+
+// consolidatedKey is the config file's section of the canonical instruments
+// consolidating the venues' products i.e.
+//
+//	consolidated:
+//	  - instrument: BTC-USD
+//	    venues:
+//	      - venue: coinbase
+//	        product: BTC-USD
+//	      - venue: binance
+//	        product: BTC-USDT
+//	        weight: 0.5
+//	    exclude: [binance]
+const consolidatedKey = "consolidated"
+
+// venueSection is a venue's settings in the config file.
+type venueSection struct {
+	Exchange string `mapstructure:"exchange"`
+	// URL defaults to the exchange's public feed
+	URL      string   `mapstructure:"url"`
+	Products []string `mapstructure:"products"`
+}
+
+// instrumentSection is a consolidated instrument's settings in the config
+// file.
+type instrumentSection struct {
+	Instrument string                   `mapstructure:"instrument"`
+	Venues     []instrumentVenueSection `mapstructure:"venues"`
+	// Exclude are the venues left out of the instrument
+	Exclude []string `mapstructure:"exclude"`
+}
+
+// instrumentVenueSection is a venue product of a consolidated instrument.
+type instrumentVenueSection struct {
+	Venue   string `mapstructure:"venue"`
+	Product string `mapstructure:"product"`
+	// Weight scales the product's trade sizes, 1 when unset
+	Weight *float64 `mapstructure:"weight"`
+}
+
+// readVenues returns the venues and consolidated instruments of the config
+// file.
+func readVenues() ([]VenueConfig, []InstrumentConfig, error) {
+	var venueSections []venueSection
+	if err := viper.UnmarshalKey(venuesKey, &venueSections); err != nil {
+		return nil, nil, fmt.Errorf("config %s section: %w", venuesKey, err)
+	}
+	var instrumentSections []instrumentSection
+	if err := viper.UnmarshalKey(consolidatedKey, &instrumentSections); err != nil {
+		return nil, nil, fmt.Errorf("config %s section: %w", consolidatedKey, err)
+	}
+	if len(venueSections) == 0 && len(instrumentSections) > 0 {
+		return nil, nil, fmt.Errorf("config %s section without %s", consolidatedKey, venuesKey)
+	}
+
+	venues := make([]VenueConfig, 0, len(venueSections))
+	// the venue qualified products
+	keys := make(map[string]bool)
+	for _, section := range venueSections {
+		venue, err := section.venueConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range venues {
+			if v.Exchange == venue.Exchange {
+				return nil, nil, fmt.Errorf("config venue %s is repeated", venue.Name())
+			}
+		}
+		for _, p := range venue.ProductIDs {
+			keys[venue.Key(p)] = true
+		}
+		venues = append(venues, venue)
+	}
+
+	instruments := make([]InstrumentConfig, 0, len(instrumentSections))
+	for _, section := range instrumentSections {
+		instrument, err := section.instrumentConfig(keys)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config instrument %s: %w", section.Instrument, err)
+		}
+		for _, i := range instruments {
+			if i.Instrument == instrument.Instrument {
+				return nil, nil, fmt.Errorf("config instrument %s is repeated", i.Instrument)
+			}
+		}
+		instruments = append(instruments, instrument)
+	}
+
+	return venues, instruments, nil
+}
+
+// venueConfig returns the section's venue settings.
+func (s venueSection) venueConfig() (VenueConfig, error) {
+	kind, err := exchange.ParseKind(s.Exchange)
+	if err != nil {
+		return VenueConfig{}, fmt.Errorf("config venue: %w", err)
+	}
+	if len(s.Products) == 0 {
+		return VenueConfig{}, fmt.Errorf("config venue %s without products", kind)
+	}
+
+	venue := VenueConfig{
+		Exchange:   kind,
+		SocketURL:  s.URL,
+		ProductIDs: s.Products,
+	}
+	if venue.SocketURL == "" {
+		feed, err := exchange.New(kind, s.Products)
+		if err != nil {
+			return VenueConfig{}, err
+		}
+		venue.SocketURL = feed.DefaultURL()
+	}
+
+	return venue, nil
+}
+
+// instrumentConfig returns the section's instrument settings whose venue
+// products are among keys.
+func (s instrumentSection) instrumentConfig(keys map[string]bool) (InstrumentConfig, error) {
+	if s.Instrument == "" {
+		return InstrumentConfig{}, fmt.Errorf("missing the instrument name")
+	}
+	if keys[s.Instrument] {
+		return InstrumentConfig{}, fmt.Errorf("the instrument is a venue product")
+	}
+	if len(s.Venues) == 0 {
+		return InstrumentConfig{}, fmt.Errorf("no venue products")
+	}
+
+	excluded := make(map[string]bool, len(s.Exclude))
+	for _, venue := range s.Exclude {
+		excluded[venue] = true
+	}
+
+	instrument := InstrumentConfig{
+		Instrument: s.Instrument,
+		Weights:    make(map[string]float64, len(s.Venues)),
+	}
+	for _, v := range s.Venues {
+		key := v.Venue + ":" + v.Product
+		if !keys[key] {
+			return instrument, fmt.Errorf("%s is not among the venues' products", key)
+		}
+
+		weight := 1.0
+		if v.Weight != nil {
+			weight = *v.Weight
+		}
+		if weight < 0 {
+			return instrument, fmt.Errorf("negative %s weight %v", key, weight)
+		}
+		if math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return instrument, fmt.Errorf("non-finite %s weight %v", key, weight)
+		}
+		if excluded[v.Venue] {
+			weight = 0
+		}
+		instrument.Weights[key] = weight
+	}
+
+	return instrument, nil
+}
@@ -0,0 +1,186 @@
+package exchange
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	// binanceTradeStream suffixes a symbol's trade stream name
+	binanceTradeStream = "@trade"
+	// binanceTradeEvent is the event type of the trade messages
+	binanceTradeEvent = "trade"
+	// binanceSubscribe is the method of the subscribe requests
+	binanceSubscribe = "SUBSCRIBE"
+	// binanceUnsubscribe is the method of the unsubscribe requests
+	binanceUnsubscribe = "UNSUBSCRIBE"
+)
+
+// The Binance trade message keys i.e.
+// {"e":"trade","E":1634472000123,"s":"BTCUSDT","t":1130002,"p":"60749.99","q":"0.00269988","T":1634472000120,"m":true,"M":true}
+var (
+	binanceEventKey  = types.FieldKey(`"e"`)
+	binanceSymbolKey = types.FieldKey(`"s"`)
+	binanceTradeKey  = types.FieldKey(`"t"`)
+	binancePriceKey  = types.FieldKey(`"p"`)
+	binanceSizeKey   = types.FieldKey(`"q"`)
+	binanceTimeKey   = types.FieldKey(`"T"`)
+	binanceResultKey = types.FieldKey(`"result"`)
+	binanceErrorKey  = types.FieldKey(`"error"`)
+)
+
+// Binance adapts the Binance trade streams. Its symbols join the canonical
+// product's assets i.e. BTC-USDT trades as BTCUSDT on the btcusdt@trade
+// stream. The trade IDs increase by one per symbol, so they serve as the
+// sequence numbers.
+type Binance struct {
+	// requests numbers the subscribe requests
+	requests atomic.Uint64
+
+	mu sync.RWMutex
+	// products maps the subscribed symbols to their product IDs
+	products map[string]string
+}
+
+// NewBinance returns the Binance adapter of the products. Subscribed
+// products are added to them.
+func NewBinance(productIDs []string) *Binance {
+	b := &Binance{products: make(map[string]string, len(productIDs))}
+	for _, p := range productIDs {
+		b.products[BinanceSymbol(p)] = p
+	}
+
+	return b
+}
+
+// BinanceSymbol returns the product's Binance symbol i.e. BTCUSDT of BTC-USDT.
+func BinanceSymbol(productID string) string {
+	return strings.ToUpper(strings.ReplaceAll(productID, "-", ""))
+}
+
+func (*Binance) Name() string {
+	return BinanceExchange.String()
+}
+
+func (*Binance) DefaultURL() string {
+	return "wss://stream.binance.com:9443/ws"
+}
+
+func (*Binance) Dial(ctx context.Context, url string) (*websocket.Conn, error) {
+	return dial(ctx, url)
+}
+
+// binanceReq is a Binance stream request.
+type binanceReq struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     uint64   `json:"id"`
+}
+
+func (b *Binance) Subscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error {
+	streams := make([]string, len(productIDs))
+	b.mu.Lock()
+	for i, p := range productIDs {
+		symbol := BinanceSymbol(p)
+		b.products[symbol] = p
+		streams[i] = strings.ToLower(symbol) + binanceTradeStream
+	}
+	b.mu.Unlock()
+
+	return b.request(ctx, conn, binanceSubscribe, streams)
+}
+
+// Unsubscribe sends the unsubscription of the products' trade streams on the
+// live connection. The symbols stay mapped to their products for the trades
+// still in flight.
+func (b *Binance) Unsubscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error {
+	streams := make([]string, len(productIDs))
+	for i, p := range productIDs {
+		streams[i] = strings.ToLower(BinanceSymbol(p)) + binanceTradeStream
+	}
+
+	return b.request(ctx, conn, binanceUnsubscribe, streams)
+}
+
+// request sends the method's request of the streams.
+func (b *Binance) request(ctx context.Context, conn *websocket.Conn, method string, streams []string) error {
+	err := conn.WriteJSON(&binanceReq{
+		Method: method,
+		Params: streams,
+		ID:     b.requests.Add(1),
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("Sending a "+method+" msg erred", zap.Error(err))
+	}
+
+	return err
+}
+
+// Classify classifies the trade events, the requests' results and errors. The
+// combined streams' wrapped events are classified alike.
+func (*Binance) Classify(msg []byte) (MessageKind, string, error) {
+	event, err := types.ParseBytes(binanceEventKey, msg)
+	if err == nil {
+		if string(event) == binanceTradeEvent {
+			return TradeMessage, binanceTradeEvent, nil
+		}
+		return UnknownMessage, string(event), nil
+	}
+
+	if _, _, err := types.ParseVal(binanceResultKey, msg); err == nil {
+		return SubscribedMessage, binanceResultKey.Name(), nil
+	}
+	if _, _, err := types.ParseVal(binanceErrorKey, msg); err == nil {
+		return ErrorMessage, binanceErrorKey.Name(), nil
+	}
+
+	return UnknownMessage, "", err
+}
+
+func (b *Binance) ParseTrade(msg []byte, trade *types.TradeValue) error {
+	symbol, err := types.ParseBytes(binanceSymbolKey, msg)
+	if err != nil {
+		return err
+	}
+	if err := types.ParseBigFloat(binancePriceKey, msg, trade.Price); err != nil {
+		return err
+	}
+	if err := types.ParseBigFloat(binanceSizeKey, msg, trade.Size); err != nil {
+		return err
+	}
+	tradeID, err := types.ParseInt64(binanceTradeKey, msg)
+	if err != nil {
+		return err
+	}
+	tradeMillis, err := types.ParseInt64(binanceTimeKey, msg)
+	if err != nil {
+		return err
+	}
+
+	trade.ProductID = b.productID(symbol)
+	trade.Sequence = tradeID
+	trade.Time = time.Unix(0, tradeMillis*int64(time.Millisecond)).UTC()
+
+	return nil
+}
+
+// productID returns the subscribed symbol's product ID or else a copy of the
+// symbol.
+func (b *Binance) productID(symbol []byte) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if p, ok := b.products[string(symbol)]; ok {
+		return p
+	}
+
+	return string(symbol)
+}
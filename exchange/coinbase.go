@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"context"
+
+	"github.com/blewater/zh/server"
+	"github.com/blewater/zh/types"
+	"github.com/gorilla/websocket"
+)
+
+// Coinbase adapts the Coinbase matches channel whose product IDs are the
+// canonical ones.
+type Coinbase struct{}
+
+func (Coinbase) Name() string {
+	return CoinbaseExchange.String()
+}
+
+func (Coinbase) DefaultURL() string {
+	return "wss://ws-feed.exchange.coinbase.com"
+}
+
+func (Coinbase) Dial(ctx context.Context, url string) (*websocket.Conn, error) {
+	return dial(ctx, url)
+}
+
+func (Coinbase) Subscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error {
+	return server.Subscribe(ctx, conn, productIDs)
+}
+
+func (Coinbase) Unsubscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error {
+	return server.Unsubscribe(ctx, conn, productIDs)
+}
+
+// Classify classifies the match and the undocumented last_match message
+// propagating the same info as trades. The known types are compared without
+// copying them.
+func (Coinbase) Classify(msg []byte) (MessageKind, string, error) {
+	msgType, err := types.ParseBytes(types.TypeKey, msg)
+	if err != nil {
+		return UnknownMessage, "", err
+	}
+
+	switch string(msgType) {
+	case server.MatchMsgType:
+		return TradeMessage, server.MatchMsgType, nil
+	case server.MatchLastMsgType:
+		return TradeMessage, server.MatchLastMsgType, nil
+	case server.SubAckMsgType:
+		return SubscribedMessage, server.SubAckMsgType, nil
+	case server.ErrorMsgType:
+		return ErrorMessage, server.ErrorMsgType, nil
+	default:
+		return UnknownMessage, string(msgType), nil
+	}
+}
+
+func (Coinbase) ParseTrade(msg []byte, trade *types.TradeValue) error {
+	productID, err := types.ParseBytes(types.ProductIDKey, msg)
+	if err != nil {
+		return err
+	}
+	if err := types.ParseBigPrice(msg, trade.Price); err != nil {
+		return err
+	}
+	if err := types.ParseBigVolume(msg, trade.Size); err != nil {
+		return err
+	}
+	sequence, err := types.ParseSequence(msg)
+	if err != nil {
+		return err
+	}
+	tradeTime, err := types.ParseTime(msg)
+	if err != nil {
+		return err
+	}
+
+	// a recycled trade of the same product keeps its product ID sparing the
+	// copy
+	if string(productID) != trade.ProductID {
+		trade.ProductID = string(productID)
+	}
+	trade.Sequence = sequence
+	trade.Time = tradeTime
+
+	return nil
+}
@@ -0,0 +1,111 @@
+// Package exchange adapts the exchanges' trade streams to the VWAP pipeline.
+// An Exchange dials the stream, subscribes the products, classifies the
+// messages and extracts their trades into types.TradeValue.
+package exchange
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blewater/zh/server"
+	"github.com/blewater/zh/types"
+	"github.com/gorilla/websocket"
+)
+
+// MessageKind classifies an exchange's stream messages.
+type MessageKind uint8
+
+const (
+	// UnknownMessage is a message of a type the pipeline does not process.
+	UnknownMessage MessageKind = iota
+	// TradeMessage carries a trade.
+	TradeMessage
+	// SubscribedMessage acknowledges a subscription.
+	SubscribedMessage
+	// ErrorMessage reports an exchange error.
+	ErrorMessage
+)
+
+func (k MessageKind) String() string {
+	switch k {
+	case TradeMessage:
+		return "trade"
+	case SubscribedMessage:
+		return "subscribed"
+	case ErrorMessage:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Exchange adapts an exchange's trade stream. The products are named in the
+// pipeline's canonical form i.e. BTC-USD and mapped to the exchange's symbols.
+type Exchange interface {
+	// Name names the exchange in logs and metrics.
+	Name() string
+	// DefaultURL is the exchange's public trade stream.
+	DefaultURL() string
+	// Dial connects to the stream at the URL.
+	Dial(ctx context.Context, url string) (*websocket.Conn, error)
+	// Subscribe sends the subscription of the products' trades on conn.
+	Subscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error
+	// Unsubscribe sends the unsubscription of the products' trades on the
+	// live conn.
+	Unsubscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error
+	// Classify returns the message's kind and its exchange type name.
+	Classify(msg []byte) (MessageKind, string, error)
+	// ParseTrade fills the trade value's product, price, size, sequence and
+	// time from a trade message. The price and size are parsed into the
+	// trade value's big.Float values at their precision.
+	ParseTrade(msg []byte, trade *types.TradeValue) error
+}
+
+// Kind selects an exchange adapter.
+type Kind uint8
+
+const (
+	// CoinbaseExchange is the Coinbase matches channel.
+	CoinbaseExchange Kind = iota
+	// BinanceExchange is the Binance trade streams.
+	BinanceExchange
+)
+
+func (k Kind) String() string {
+	switch k {
+	case CoinbaseExchange:
+		return "coinbase"
+	case BinanceExchange:
+		return "binance"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseKind returns the exchange kind matching its name.
+func ParseKind(name string) (Kind, error) {
+	for _, k := range []Kind{CoinbaseExchange, BinanceExchange} {
+		if k.String() == name {
+			return k, nil
+		}
+	}
+
+	return CoinbaseExchange, fmt.Errorf("unknown exchange %q", name)
+}
+
+// New returns the exchange adapter of the kind streaming the products.
+func New(kind Kind, productIDs []string) (Exchange, error) {
+	switch kind {
+	case CoinbaseExchange:
+		return Coinbase{}, nil
+	case BinanceExchange:
+		return NewBinance(productIDs), nil
+	default:
+		return nil, fmt.Errorf("unknown exchange %d", kind)
+	}
+}
+
+// dial connects to the stream at the URL.
+func dial(ctx context.Context, url string) (*websocket.Conn, error) {
+	return server.Connect(ctx, url)
+}
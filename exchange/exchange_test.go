@@ -0,0 +1,305 @@
+package exchange_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/exchange"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/mockfeed"
+	"github.com/blewater/zh/mockfeed/feedtest"
+	"github.com/blewater/zh/types"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+func newTrade() *types.TradeValue {
+	return &types.TradeValue{Price: new(big.Float).SetPrec(128), Size: new(big.Float).SetPrec(128)}
+}
+
+// stream dials the fixture server, subscribes the products and returns the
+// kinds of the next n messages along with their parsed trades.
+func stream(t *testing.T, ex exchange.Exchange, url string, productIDs []string, n int) ([]exchange.MessageKind, []*types.TradeValue) {
+	t.Helper()
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+
+	conn, err := ex.Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := ex.Subscribe(ctx, conn, productIDs); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []exchange.MessageKind
+	var trades []*types.TradeValue
+	for i := 0; i < n; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		kind, _, err := ex.Classify(msg)
+		if err != nil {
+			t.Fatalf("Classify(%s) err = %v", msg, err)
+		}
+		kinds = append(kinds, kind)
+		if kind == exchange.TradeMessage {
+			trade := newTrade()
+			if err := ex.ParseTrade(msg, trade); err != nil {
+				t.Fatalf("ParseTrade(%s) err = %v", msg, err)
+			}
+			trades = append(trades, trade)
+		}
+	}
+
+	return kinds, trades
+}
+
+func TestCoinbase(t *testing.T) {
+	_, url := feedtest.Start(t, mockfeed.Config{Seed: 1})
+
+	kinds, trades := stream(t, exchange.Coinbase{}, url, []string{"BTC-USD"}, 4)
+
+	want := []exchange.MessageKind{exchange.SubscribedMessage, exchange.TradeMessage, exchange.TradeMessage, exchange.TradeMessage}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("kinds = %v, want %v", kinds, want)
+	}
+	for i, trade := range trades {
+		if trade.ProductID != "BTC-USD" || trade.Sequence != int64(i+1) || trade.Price.Sign() <= 0 || trade.Time.IsZero() {
+			t.Errorf("trade %d = %+v", i, trade)
+		}
+	}
+}
+
+// binanceFixture serves the Binance trade streams' fixture messages after
+// checking the subscribe request.
+func binanceFixture(t *testing.T, wantStreams []string, msgs ...string) string {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+			ID     int      `json:"id"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if req.Method != "SUBSCRIBE" || !reflect.DeepEqual(req.Params, wantStreams) {
+			t.Errorf("subscribe request = %+v, want the streams %v", req, wantStreams)
+		}
+		ack, _ := json.Marshal(map[string]interface{}{"result": nil, "id": req.ID})
+		_ = conn.WriteMessage(websocket.TextMessage, ack)
+		for _, msg := range msgs {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+		// until the client hangs up
+		_, _, _ = conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestBinance(t *testing.T) {
+	url := binanceFixture(
+		t, []string{"btcusdt@trade", "ethbtc@trade"},
+		`{"e":"trade","E":1634472000123,"s":"BTCUSDT","t":1130002,"p":"60749.99000000","q":"0.00269988","T":1634472000120,"m":true,"M":true}`,
+		`{"stream":"ethbtc@trade","data":{"e":"trade","E":1634472000200,"s":"ETHBTC","t":77,"p":"0.07083000","q":"1.50000000","T":1634472000199,"m":false,"M":true}}`,
+		`{"e":"aggTrade","E":1634472000300,"s":"BTCUSDT","a":5,"p":"60750.00","q":"1"}`,
+		`{"error":{"code":2,"msg":"Invalid request"},"id":2}`,
+	)
+
+	kinds, trades := stream(t, exchange.NewBinance(nil), url, []string{"BTC-USDT", "ETH-BTC"}, 5)
+
+	wantKinds := []exchange.MessageKind{
+		exchange.SubscribedMessage, exchange.TradeMessage, exchange.TradeMessage, exchange.UnknownMessage, exchange.ErrorMessage,
+	}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Fatalf("kinds = %v, want %v", kinds, wantKinds)
+	}
+
+	want := []struct {
+		productID string
+		price     string
+		size      string
+		sequence  int64
+		time      time.Time
+	}{
+		{"BTC-USDT", "60749.99", "0.00269988", 1130002, time.Unix(1634472000, 120e6)},
+		{"ETH-BTC", "0.07083", "1.5", 77, time.Unix(1634472000, 199e6)},
+	}
+	for i, w := range want {
+		got := trades[i]
+		if got.ProductID != w.productID || got.Sequence != w.sequence || !got.Time.Equal(w.time) {
+			t.Errorf("trade %d = %s %d %v, want %s %d %v", i, got.ProductID, got.Sequence, got.Time, w.productID, w.sequence, w.time)
+		}
+		// exact decimals
+		if price, size := got.Price.Text('f', -1), got.Size.Text('f', -1); price != w.price || size != w.size {
+			t.Errorf("trade %d price, size = %s, %s, want %s, %s", i, price, size, w.price, w.size)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tests := []struct {
+		name string
+		ex   exchange.Exchange
+		want string
+	}{
+		{
+			name: "Coinbase",
+			ex:   exchange.Coinbase{},
+			want: `{"type":"unsubscribe","product_ids":["ETH-USD"],"channels":["matches"]}`,
+		},
+		{
+			name: "Binance",
+			ex:   exchange.NewBinance(nil),
+			want: `{"method":"UNSUBSCRIBE","params":["ethusd@trade"],"id":2}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the server relays the requests following the subscription
+			reqs := make(chan string, 1)
+			upgrader := websocket.Upgrader{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				if _, msg, err := conn.ReadMessage(); err == nil {
+					reqs <- strings.TrimSpace(string(msg))
+				}
+			}))
+			defer srv.Close()
+
+			ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+			conn, err := tt.ex.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if err := tt.ex.Subscribe(ctx, conn, []string{"BTC-USD", "ETH-USD"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.ex.Unsubscribe(ctx, conn, []string{"ETH-USD"}); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case got := <-reqs:
+				if got != tt.want {
+					t.Errorf("unsubscribe request = %s, want %s", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the unsubscribe request")
+			}
+		})
+	}
+}
+
+func TestParseTrade_FieldErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		ex    exchange.Exchange
+		msg   string
+		field string
+	}{
+		{
+			name:  "Coinbase missing price",
+			ex:    exchange.Coinbase{},
+			msg:   `{"type":"match","size":"1","product_id":"BTC-USD","sequence":1,"time":"2021-10-17T12:00:00.000000Z"}`,
+			field: "price",
+		},
+		{
+			name:  "Coinbase malformed time",
+			ex:    exchange.Coinbase{},
+			msg:   `{"type":"match","price":"1","size":"1","product_id":"BTC-USD","sequence":1,"time":"yesterday"}`,
+			field: "time",
+		},
+		{
+			name:  "Coinbase infinite price",
+			ex:    exchange.Coinbase{},
+			msg:   `{"type":"match","price":"Inf","size":"1","product_id":"BTC-USD","sequence":1,"time":"2021-10-17T12:00:00.000000Z"}`,
+			field: "price",
+		},
+		{
+			name:  "Coinbase zero size",
+			ex:    exchange.Coinbase{},
+			msg:   `{"type":"match","price":"1","size":"0","product_id":"BTC-USD","sequence":1,"time":"2021-10-17T12:00:00.000000Z"}`,
+			field: "size",
+		},
+		{
+			name:  "Binance negative price",
+			ex:    exchange.NewBinance([]string{"BTC-USDT"}),
+			msg:   `{"e":"trade","s":"BTCUSDT","t":1,"p":"-1","q":"1","T":1634472000120}`,
+			field: "p",
+		},
+		{
+			name:  "Binance malformed quantity",
+			ex:    exchange.NewBinance([]string{"BTC-USDT"}),
+			msg:   `{"e":"trade","s":"BTCUSDT","t":1,"p":"1","q":"x","T":1634472000120}`,
+			field: "q",
+		},
+		{
+			name:  "Binance quoted trade ID",
+			ex:    exchange.NewBinance([]string{"BTC-USDT"}),
+			msg:   `{"e":"trade","s":"BTCUSDT","t":"1","p":"1","q":"1","T":1634472000120}`,
+			field: "t",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := tt.ex.ParseTrade([]byte(tt.msg), newTrade())
+				var fieldErr *types.FieldError
+				if !errors.As(err, &fieldErr) || fieldErr.Field != tt.field {
+					t.Errorf("ParseTrade() err = %v, want a %s field error", err, tt.field)
+				}
+			},
+		)
+	}
+}
+
+func TestBinanceSymbol(t *testing.T) {
+	for productID, want := range map[string]string{"BTC-USDT": "BTCUSDT", "eth-btc": "ETHBTC", "BNBBTC": "BNBBTC"} {
+		if got := exchange.BinanceSymbol(productID); got != want {
+			t.Errorf("BinanceSymbol(%s) = %s, want %s", productID, got, want)
+		}
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for _, kind := range []exchange.Kind{exchange.CoinbaseExchange, exchange.BinanceExchange} {
+		got, err := exchange.ParseKind(kind.String())
+		if err != nil || got != kind {
+			t.Errorf("ParseKind(%s) = %v, %v", kind, got, err)
+		}
+		if ex, err := exchange.New(kind, nil); err != nil || ex.Name() != kind.String() {
+			t.Errorf("New(%s) = %v, %v", kind, ex, err)
+		}
+	}
+	if _, err := exchange.ParseKind("kraken"); err == nil {
+		t.Errorf("ParseKind(kraken) expected an error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"github.com/blewater/zh/cmd"
 	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/mockfeed"
 	"github.com/blewater/zh/workflow"
 	"go.uber.org/zap"
 	"os"
@@ -15,15 +16,40 @@ import (
 func main() {
 	cfg, logger := bootstrap()
 
-	w := workflow.New(cfg)
+	if cfg.MockFeed.Addr != "" {
+		serveMockFeed(cfg.MockFeed, logger)
+		return
+	}
+
+	if cfg.Compute.In != "" {
+		compute(cfg, logger)
+		return
+	}
+
+	w, err := workflow.New(cfg)
+	if err != nil {
+		logger.Error("Invalid configuration", zap.Error(err))
+		os.Exit(1)
+	}
 
 	ctx, cancel := context.WithCancel(
 		log.ContextWithLogger(context.Background(), logger))
 
+	if len(cfg.Replay.Paths) > 0 {
+		go waitInterruptSignal(cancel)
+		if err := w.Replay(ctx, cfg.Replay.Paths, cfg.Replay.Speed); err != nil {
+			logger.Error("Replay erred", zap.Error(err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Precedes socket communication to start the pool
 	go w.StartPool(ctx)
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		if err := w.TradesToVwap(ctx); err != nil {
 			// socket failed to connect
 			os.Exit(1)
@@ -31,6 +57,32 @@ func main() {
 	}()
 
 	waitInterruptSignal(cancel)
+	// the sinks and the windows snapshot are flushed on shutdown
+	<-done
+}
+
+// compute computes the VWAPs of the trades file until done or interrupted.
+func compute(cfg cmd.Config, logger *zap.Logger) {
+	ctx, cancel := context.WithCancel(
+		log.ContextWithLogger(context.Background(), logger))
+	go waitInterruptSignal(cancel)
+
+	if err := workflow.Compute(ctx, cfg); err != nil {
+		logger.Error("Compute erred", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+// serveMockFeed serves the mock feed until interrupted.
+func serveMockFeed(cfg cmd.MockFeedConfig, logger *zap.Logger) {
+	ctx, cancel := context.WithCancel(
+		log.ContextWithLogger(context.Background(), logger))
+	go waitInterruptSignal(cancel)
+
+	if err := mockfeed.New(cfg.Feed).ListenAndServe(ctx, cfg.Addr); err != nil {
+		logger.Error("Mock feed erred", zap.Error(err))
+		os.Exit(1)
+	}
 }
 
 func waitInterruptSignal(cancel context.CancelFunc) {
@@ -0,0 +1,27 @@
+// Package feedtest serves the mock feed to tests.
+package feedtest
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blewater/zh/mockfeed"
+)
+
+// Start serves the mock feed of the configuration for the test's duration and
+// returns it along with its ws:// URL.
+func Start(tb testing.TB, cfg mockfeed.Config) (*mockfeed.Server, string) {
+	tb.Helper()
+
+	feed := mockfeed.New(cfg)
+	srv := httptest.NewServer(feed)
+	tb.Cleanup(
+		func() {
+			feed.Close()
+			srv.Close()
+		},
+	)
+
+	return feed, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
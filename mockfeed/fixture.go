@@ -0,0 +1,58 @@
+package mockfeed
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/blewater/zh/capture"
+)
+
+// LoadFixture reads the raw messages of a capture file recorded by --record
+// or else of a file of a message per line i.e. JSON Lines.
+func LoadFixture(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr, err := capture.NewReader(f)
+	if err == capture.ErrNotCapture {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return readLines(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs [][]byte
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, rec.Msg)
+	}
+}
+
+// readLines reads the non blank lines as messages.
+func readLines(r io.Reader) ([][]byte, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), capture.MaxMessageLen)
+
+	var msgs [][]byte
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			msgs = append(msgs, append([]byte(nil), line...))
+		}
+	}
+
+	return msgs, scanner.Err()
+}
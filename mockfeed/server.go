@@ -0,0 +1,429 @@
+// Package mockfeed serves a local mock of the Coinbase matches feed for tests
+// and offline runs. It speaks the subscribe, subscriptions, match,
+// last_match and error messages, generates trades off a seeded random walk or
+// replays a fixture, and injects faults i.e. disconnects, malformed frames
+// and delays.
+package mockfeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/server"
+	"github.com/blewater/zh/types"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	// unsubscribeMsgType cancels the subscription of the product IDs
+	unsubscribeMsgType = "unsubscribe"
+	// shutdownTimeout bounds the wait for the in-flight requests on shutdown
+	shutdownTimeout = time.Second
+	// tradeTimeLayout is the feed's microseconds trade time
+	tradeTimeLayout = "2006-01-02T15:04:05.000000Z"
+	// resubscribePoll paces the wait of a connection without subscriptions
+	resubscribePoll = 10 * time.Millisecond
+)
+
+// Config is the mock feed's trades and injected faults. The zero value
+// streams random walk trades as fast as the clients read them.
+type Config struct {
+	// Seed seeds the random walk of the trades
+	Seed int64
+	// StartPrice is the random walk's starting price of each product. It
+	// defaults to 100.
+	StartPrice float64
+	// Interval is the pause between a connection's trades
+	Interval time.Duration
+	// Fixture when set is the raw messages sent in order upon the first
+	// subscription instead of the random walk trades
+	Fixture [][]byte
+	// DisconnectAfter when positive drops each connection without a close
+	// frame after sending it these trades
+	DisconnectAfter int
+	// MalformedEvery when positive truncates every n-th trade frame
+	MalformedEvery int
+	// DelayEvery when positive stalls Delay before every n-th trade
+	DelayEvery int
+	Delay      time.Duration
+}
+
+// Server is the mock feed. The random walk and the products' sequences are
+// shared across the connections, so that a client reconnecting carries on
+// from where it dropped.
+type Server struct {
+	// trades counts the sent trades
+	trades atomic.Uint64
+
+	cfg      Config
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	prices  map[string]float64
+	seqs    map[string]int64
+	tradeID int64
+	conns   map[*websocket.Conn]struct{}
+	// connsTotal counts the accepted connections
+	connsTotal int
+}
+
+// New returns the mock feed of the configuration.
+func New(cfg Config) *Server {
+	if cfg.StartPrice <= 0 {
+		cfg.StartPrice = 100
+	}
+
+	return &Server{
+		cfg:    cfg,
+		rnd:    rand.New(rand.NewSource(cfg.Seed)),
+		prices: make(map[string]float64),
+		seqs:   make(map[string]int64),
+		conns:  make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// Trades returns the number of trades sent.
+func (s *Server) Trades() uint64 {
+	return s.trades.Load()
+}
+
+// Connections returns the number of accepted connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connsTotal
+}
+
+// Close drops the open connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// ListenAndServe serves the feed at addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	logger := log.FromContext(ctx)
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("mock feed shutdown erred", zap.Error(err))
+		}
+	}()
+
+	logger.Info("Serving the mock feed", zap.String("addr", addr))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// errorMsg is the feed's error message.
+type errorMsg struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// channelMsg is a subscribed channel of the subscriptions message.
+type channelMsg struct {
+	Name       string   `json:"name"`
+	ProductIDs []string `json:"product_ids"`
+}
+
+// subscriptionsMsg acknowledges the subscriptions of a connection.
+type subscriptionsMsg struct {
+	Type     string       `json:"type"`
+	Channels []channelMsg `json:"channels"`
+}
+
+// matchMsg is a trade of the matches channel.
+type matchMsg struct {
+	Type         string `json:"type"`
+	TradeID      int64  `json:"trade_id"`
+	MakerOrderID string `json:"maker_order_id"`
+	TakerOrderID string `json:"taker_order_id"`
+	Side         string `json:"side"`
+	Size         string `json:"size"`
+	Price        string `json:"price"`
+	ProductID    string `json:"product_id"`
+	Sequence     int64  `json:"sequence"`
+	Time         string `json:"time"`
+}
+
+// conn is a client connection and its subscribed products.
+type conn struct {
+	ws *websocket.Conn
+	// wmu serializes the writes of the acknowledgements and the trades
+	wmu sync.Mutex
+
+	mu         sync.Mutex
+	products   map[string]bool
+	subscribed chan struct{}
+	// done is closed once the connection drops
+	done chan struct{}
+}
+
+func (c *conn) write(msg []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.ws.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (c *conn) writeJSON(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.write(msg)
+}
+
+// productIDs returns the subscribed products in order.
+func (c *conn) productIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.products))
+	for p := range c.products {
+		ids = append(ids, p)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// ServeHTTP upgrades the request to a feed connection.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[ws] = struct{}{}
+	s.connsTotal++
+	s.mu.Unlock()
+
+	c := &conn{
+		ws:         ws,
+		products:   make(map[string]bool),
+		subscribed: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		s.stream(c)
+	}()
+
+	s.readRequests(c)
+
+	close(c.done)
+	ws.Close()
+	<-streamed
+	s.mu.Lock()
+	delete(s.conns, ws)
+	s.mu.Unlock()
+}
+
+// readRequests handles the subscribe and unsubscribe requests until the
+// connection drops.
+func (s *Server) readRequests(c *conn) {
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req types.SubReq
+		if err := json.Unmarshal(msg, &req); err != nil {
+			c.writeJSON(&errorMsg{Type: server.ErrorMsgType, Message: "Failed to parse the request", Reason: err.Error()})
+			continue
+		}
+		if err := s.handleRequest(c, &req); err != nil {
+			c.writeJSON(&errorMsg{Type: server.ErrorMsgType, Message: "Failed to subscribe", Reason: err.Error()})
+		}
+	}
+}
+
+// handleRequest applies the request to the connection's subscriptions and
+// acknowledges them. Each newly subscribed product is sent its last match.
+func (s *Server) handleRequest(c *conn, req *types.SubReq) error {
+	if req.Type != server.SubReqMsgType && req.Type != unsubscribeMsgType {
+		return fmt.Errorf("unknown request type %q", req.Type)
+	}
+	if len(req.ProductIds) == 0 {
+		return fmt.Errorf("no product IDs")
+	}
+	matches := false
+	for _, ch := range req.Channels {
+		matches = matches || ch == server.MatchesChannelMsgType
+	}
+	if !matches {
+		return fmt.Errorf("the %s channel only is supported", server.MatchesChannelMsgType)
+	}
+
+	var added []string
+	c.mu.Lock()
+	for _, p := range req.ProductIds {
+		if req.Type == unsubscribeMsgType {
+			delete(c.products, p)
+			continue
+		}
+		if !c.products[p] {
+			c.products[p] = true
+			added = append(added, p)
+		}
+	}
+	c.mu.Unlock()
+
+	if err := c.writeJSON(&subscriptionsMsg{
+		Type:     server.SubAckMsgType,
+		Channels: []channelMsg{{Name: server.MatchesChannelMsgType, ProductIDs: c.productIDs()}},
+	}); err != nil {
+		return err
+	}
+
+	if s.cfg.Fixture == nil {
+		for _, p := range added {
+			if err := c.write(s.nextTrade(server.MatchLastMsgType, p)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if req.Type == server.SubReqMsgType {
+		select {
+		case <-c.subscribed:
+		default:
+			close(c.subscribed)
+		}
+	}
+
+	return nil
+}
+
+// stream sends the trades after the first subscription injecting the
+// configured faults.
+func (s *Server) stream(c *conn) {
+	select {
+	case <-c.subscribed:
+	case <-c.done:
+		return
+	}
+
+	for n := 1; ; n++ {
+		msg := s.next(c, n)
+		if msg == nil {
+			return
+		}
+
+		if s.cfg.DelayEvery > 0 && n%s.cfg.DelayEvery == 0 {
+			time.Sleep(s.cfg.Delay)
+		}
+		if s.cfg.MalformedEvery > 0 && n%s.cfg.MalformedEvery == 0 {
+			msg = msg[:len(msg)/2]
+		}
+		if err := c.write(msg); err != nil {
+			return
+		}
+		s.trades.Add(1)
+
+		if s.cfg.DisconnectAfter > 0 && n == s.cfg.DisconnectAfter {
+			// drop without a close frame
+			c.ws.UnderlyingConn().Close()
+			return
+		}
+		if s.cfg.Interval > 0 {
+			time.Sleep(s.cfg.Interval)
+		}
+	}
+}
+
+// next returns the n-th message of the connection or nil when there are no
+// more or the connection dropped.
+func (s *Server) next(c *conn, n int) []byte {
+	if s.cfg.Fixture != nil {
+		if n > len(s.cfg.Fixture) {
+			return nil
+		}
+		return s.cfg.Fixture[n-1]
+	}
+
+	products := c.productIDs()
+	// all unsubscribed, wait for a subscription
+	for len(products) == 0 {
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(resubscribePoll):
+		}
+		products = c.productIDs()
+	}
+
+	s.mu.Lock()
+	p := products[s.rnd.Intn(len(products))]
+	s.mu.Unlock()
+
+	return s.nextTrade(server.MatchMsgType, p)
+}
+
+// nextTrade returns the product's next random walk trade of the message type.
+func (s *Server) nextTrade(msgType, productID string) []byte {
+	s.mu.Lock()
+	price, ok := s.prices[productID]
+	if !ok {
+		price = s.cfg.StartPrice
+	}
+	price *= 1 + (s.rnd.Float64()-0.5)/100
+	s.prices[productID] = price
+	s.seqs[productID]++
+	s.tradeID++
+	side := "buy"
+	if s.rnd.Intn(2) == 0 {
+		side = "sell"
+	}
+	match := &matchMsg{
+		Type:         msgType,
+		TradeID:      s.tradeID,
+		MakerOrderID: fmt.Sprintf("%016x", s.rnd.Int63()),
+		TakerOrderID: fmt.Sprintf("%016x", s.rnd.Int63()),
+		Side:         side,
+		Size:         fmt.Sprintf("%.8f", s.rnd.ExpFloat64()),
+		Price:        fmt.Sprintf("%.8f", price),
+		ProductID:    productID,
+		Sequence:     s.seqs[productID],
+		Time:         time.Now().UTC().Format(tradeTimeLayout),
+	}
+	s.mu.Unlock()
+
+	msg, _ := json.Marshal(match)
+
+	return msg
+}
@@ -0,0 +1,225 @@
+package mockfeed_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/capture"
+	"github.com/blewater/zh/mockfeed"
+	"github.com/blewater/zh/mockfeed/feedtest"
+	"github.com/blewater/zh/types"
+	"github.com/gorilla/websocket"
+)
+
+// feedMsg is the mock feed's message fields under test.
+type feedMsg struct {
+	Type      string `json:"type"`
+	ProductID string `json:"product_id"`
+	Price     string `json:"price"`
+	Sequence  int64  `json:"sequence"`
+	Reason    string `json:"reason"`
+	Channels  []struct {
+		ProductIDs []string `json:"product_ids"`
+	} `json:"channels"`
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func subscribe(t *testing.T, conn *websocket.Conn, productIDs ...string) {
+	t.Helper()
+
+	if err := conn.WriteJSON(&types.SubReq{Type: "subscribe", ProductIds: productIDs, Channels: []string{"matches"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read reads the next message returning its raw frame.
+func read(t *testing.T, conn *websocket.Conn) (feedMsg, []byte) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, raw, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg feedMsg
+	_ = json.Unmarshal(raw, &msg)
+
+	return msg, raw
+}
+
+func TestServer_Subscribe(t *testing.T) {
+	_, url := feedtest.Start(t, mockfeed.Config{Seed: 1})
+	conn := dial(t, url)
+	subscribe(t, conn, "BTC-USD", "ETH-USD")
+
+	ack, _ := read(t, conn)
+	if ack.Type != "subscriptions" || len(ack.Channels) != 1 || len(ack.Channels[0].ProductIDs) != 2 {
+		t.Fatalf("ack = %+v, want the subscriptions of 2 products", ack)
+	}
+
+	seqs := make(map[string]int64)
+	for i := 0; i < 100; i++ {
+		msg, _ := read(t, conn)
+		wantType := "match"
+		if i < 2 {
+			wantType = "last_match"
+		}
+		if msg.Type != wantType {
+			t.Fatalf("message %d type = %s, want %s", i, msg.Type, wantType)
+		}
+		if msg.Sequence != seqs[msg.ProductID]+1 {
+			t.Fatalf("%s sequence %d after %d", msg.ProductID, msg.Sequence, seqs[msg.ProductID])
+		}
+		seqs[msg.ProductID] = msg.Sequence
+	}
+}
+
+func TestServer_Seeded(t *testing.T) {
+	prices := func(seed int64) []string {
+		_, url := feedtest.Start(t, mockfeed.Config{Seed: seed})
+		conn := dial(t, url)
+		subscribe(t, conn, "BTC-USD")
+		read(t, conn)
+
+		var prices []string
+		for i := 0; i < 20; i++ {
+			msg, _ := read(t, conn)
+			prices = append(prices, msg.Price)
+		}
+		return prices
+	}
+
+	first, second, other := prices(7), prices(7), prices(8)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("trade %d price = %s, then %s of the same seed", i, first[i], second[i])
+		}
+	}
+	if first[0] == other[0] {
+		t.Errorf("trade 0 price %s for different seeds", first[0])
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	_, url := feedtest.Start(t, mockfeed.Config{})
+	conn := dial(t, url)
+
+	for _, req := range []string{
+		`{"type":"subscribe","product_ids":["BTC-USD"],"channels":["matches"]`,
+		`{"type":"ping","product_ids":["BTC-USD"],"channels":["matches"]}`,
+		`{"type":"subscribe","product_ids":[],"channels":["matches"]}`,
+		`{"type":"subscribe","product_ids":["BTC-USD"],"channels":["level2"]}`,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := read(t, conn); msg.Type != "error" || msg.Reason == "" {
+			t.Errorf("request %s response = %+v, want an error", req, msg)
+		}
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	feed, url := feedtest.Start(t, mockfeed.Config{DisconnectAfter: 6, MalformedEvery: 3, DelayEvery: 2, Delay: 10 * time.Millisecond})
+	conn := dial(t, url)
+	subscribe(t, conn, "BTC-USD")
+	read(t, conn)
+	// last match
+	read(t, conn)
+
+	start := time.Now()
+	for n := 1; n <= 6; n++ {
+		_, raw := read(t, conn)
+		if malformed := !json.Valid(raw); malformed != (n%3 == 0) {
+			t.Errorf("trade %d malformed = %v", n, malformed)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("6 trades delayed every 2nd took %v, want >= 30ms", elapsed)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("ReadMessage() after the disconnect err = %v, want an abnormal closure", err)
+	}
+
+	// the reconnection carries on the products' sequence
+	conn = dial(t, url)
+	subscribe(t, conn, "BTC-USD")
+	read(t, conn)
+	if msg, _ := read(t, conn); msg.Sequence != 8 {
+		t.Errorf("last match sequence after reconnecting = %d, want 8", msg.Sequence)
+	}
+	if feed.Connections() != 2 {
+		t.Errorf("Connections() = %d, want 2", feed.Connections())
+	}
+}
+
+func TestServer_Fixture(t *testing.T) {
+	fixture := [][]byte{
+		[]byte(`{"type":"match","product_id":"BTC-USD","sequence":1,"price":"1"}`),
+		[]byte(`{"type":"match","product_id":"BTC-USD","sequence":2,"price":"2"}`),
+	}
+	path := filepath.Join(t.TempDir(), "fixture.jsonl")
+	if err := os.WriteFile(path, append(append(fixture[0], '\n', '\n'), fixture[1]...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := mockfeed.LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capPath := filepath.Join(t.TempDir(), "fixture.vcap")
+	f, err := os.Create(capPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := capture.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range fixture {
+		if _, err := w.Write(capture.Record{Received: time.Now(), Msg: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	capLoaded, err := mockfeed.LoadFixture(capPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msgs := range [][][]byte{loaded, capLoaded} {
+		feed, url := feedtest.Start(t, mockfeed.Config{Fixture: msgs})
+		conn := dial(t, url)
+		subscribe(t, conn, "BTC-USD")
+		read(t, conn)
+		for i, want := range fixture {
+			if _, got := read(t, conn); string(got) != string(want) {
+				t.Errorf("message %d = %s, want %s", i, got, want)
+			}
+		}
+		// counted once written
+		deadline := time.Now().Add(time.Second)
+		for feed.Trades() != uint64(len(fixture)) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := feed.Trades(); got != uint64(len(fixture)) {
+			t.Errorf("Trades() = %d, want %d", got, len(fixture))
+		}
+	}
+}
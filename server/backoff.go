@@ -0,0 +1,61 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoffFactor is the growth rate of the redial delay after each failure.
+const backoffFactor = 2
+
+// Backoff computes jittered exponential delays between redial attempts. The
+// n-th delay is drawn uniformly from [d/2, d] where d = min*2^n capped at
+// max, so that several clients dropped at once do not redial in lockstep.
+type Backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt int
+	rnd     *rand.Rand
+}
+
+func NewBackoff(min, max time.Duration) *Backoff {
+	if max < min {
+		max = min
+	}
+
+	return &Backoff{
+		min: min,
+		max: max,
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next returns the delay to wait before the next attempt and advances the
+// attempts counter.
+func (b *Backoff) Next() time.Duration {
+	d := b.min
+	for i := 0; i < b.attempt && d < b.max; i++ {
+		d *= backoffFactor
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempt++
+
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+
+	return half + time.Duration(b.rnd.Int63n(int64(d-half)+1))
+}
+
+// Attempt returns the number of delays handed out since the last Reset.
+func (b *Backoff) Attempt() int {
+	return b.attempt
+}
+
+// Reset starts over from the minimum delay after a successful connection.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff_Next(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max time.Duration
+		attempts int
+		wantLow  time.Duration
+		wantHigh time.Duration
+	}{
+		{
+			name:     "First attempt",
+			min:      100 * time.Millisecond,
+			max:      time.Second,
+			attempts: 1,
+			wantLow:  50 * time.Millisecond,
+			wantHigh: 100 * time.Millisecond,
+		},
+		{
+			name:     "Third attempt doubles twice",
+			min:      100 * time.Millisecond,
+			max:      time.Second,
+			attempts: 3,
+			wantLow:  200 * time.Millisecond,
+			wantHigh: 400 * time.Millisecond,
+		},
+		{
+			name:     "Capped at max",
+			min:      100 * time.Millisecond,
+			max:      time.Second,
+			attempts: 20,
+			wantLow:  500 * time.Millisecond,
+			wantHigh: time.Second,
+		},
+		{
+			name:     "Max below min",
+			min:      time.Second,
+			max:      0,
+			attempts: 5,
+			wantLow:  500 * time.Millisecond,
+			wantHigh: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				b := NewBackoff(tt.min, tt.max)
+				var got time.Duration
+				for i := 0; i < tt.attempts; i++ {
+					got = b.Next()
+				}
+				if got < tt.wantLow || got > tt.wantHigh {
+					t.Errorf("Next() = %v, want within [%v, %v]", got, tt.wantLow, tt.wantHigh)
+				}
+				if b.Attempt() != tt.attempts {
+					t.Errorf("Attempt() = %d, want %d", b.Attempt(), tt.attempts)
+				}
+				b.Reset()
+				if got := b.Next(); got > tt.min {
+					t.Errorf("Next() after Reset() = %v, want <= %v", got, tt.min)
+				}
+			},
+		)
+	}
+}
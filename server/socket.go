@@ -3,6 +3,7 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/blewater/zh/log"
@@ -13,6 +14,7 @@ import (
 
 const (
 	SubReqMsgType         = "subscribe"
+	UnsubReqMsgType       = "unsubscribe"
 	SubAckMsgType         = "subscriptions"
 	MatchesChannelMsgType = "matches"
 	MatchMsgType          = "match"
@@ -25,34 +27,51 @@ func Connect(ctx context.Context, socketAddr string) (*websocket.Conn, error) {
 
 	logger.Debug("connecting", zap.String("host", socketAddr))
 
-	conn, resp, err := websocket.DefaultDialer.Dial(socketAddr, nil)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, socketAddr, nil)
 	if err != nil {
 		logger.Error(
 			"attempting to connect erred:",
 			zap.String("url", socketAddr),
 			zap.Error(err),
 		)
+		// resp is nil when the dial failed before a handshake response
+		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusSwitchingProtocols {
 		logger.Error("new connection failed to switch:", zap.String("resp", resp.Status))
-		return nil, err
+		conn.Close()
+		return nil, fmt.Errorf("connection to %s failed to switch: %s", socketAddr, resp.Status)
 	}
 
-	return conn, err
+	return conn, nil
 }
 
 func Subscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error {
+	return request(ctx, conn, SubReqMsgType, productIDs)
+}
+
+// Unsubscribe sends the unsubscription of the products' matches on the live
+// connection.
+func Unsubscribe(ctx context.Context, conn *websocket.Conn, productIDs []string) error {
+	return request(ctx, conn, UnsubReqMsgType, productIDs)
+}
+
+// request sends the subscribe or unsubscribe request of the products'
+// matches.
+func request(ctx context.Context, conn *websocket.Conn, msgType string, productIDs []string) error {
 	logger := log.FromContext(ctx)
 
 	err := conn.WriteJSON(
 		&types.SubReq{
-			Type:       SubReqMsgType,
+			Type:       msgType,
 			ProductIds: productIDs,
 			Channels:   []string{MatchesChannelMsgType},
 		},
 	)
-	logger.Error("Sending a subscribe msg erred", zap.Error(err))
+	if err != nil {
+		logger.Error("Sending a "+msgType+" msg erred", zap.Error(err))
+	}
 
 	return err
 }
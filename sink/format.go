@@ -0,0 +1,275 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/blewater/zh/types"
+)
+
+// Format is the encoding of a writer sink.
+type Format uint8
+
+const (
+	// TextFormat is the human readable line of the former stderr output i.e.
+	// ProductID:BTC-USD Window:200 VWAP:60749.990000, or TWAP: for the TWAP
+	// results, followed by a consolidated instrument's venue shares i.e.
+	// Venues:binance=37.50%,coinbase=62.50%, and by the VWAP's σ bands i.e.
+	// StdDev:12.500000 Bands:1=60737.490000/60762.490000
+	TextFormat Format = iota
+	// JSONLinesFormat is a JSON object per line.
+	JSONLinesFormat
+	// CSVFormat is a CSV record per line following a header record.
+	CSVFormat
+)
+
+func (f Format) String() string {
+	switch f {
+	case TextFormat:
+		return "text"
+	case JSONLinesFormat:
+		return "jsonl"
+	case CSVFormat:
+		return "csv"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseFormat returns the format matching its name.
+func ParseFormat(name string) (Format, error) {
+	for _, f := range []Format{TextFormat, JSONLinesFormat, CSVFormat} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+
+	return TextFormat, fmt.Errorf("unknown sink %q", name)
+}
+
+// csvHeader names the fields of the CSV records. The venues are a
+// consolidated instrument's venue shares i.e. binance=0.375;coinbase=0.625.
+// The vwap field is the value of the record's metric: vwap or twap. The bands
+// are the VWAP's lower and upper σ bands of each multiplier i.e.
+// 1=60737.49/60762.49;2=60724.99/60774.99.
+var csvHeader = []string{"product_id", "window", "vwap", "suspect", "session_close", "venues", "metric", "stddev", "bands"}
+
+// jsonResult is the JSON Lines object of a result. Vwap is the value of its
+// Metric.
+type jsonResult struct {
+	ProductID    string `json:"product_id"`
+	Window       string `json:"window"`
+	Metric       string `json:"metric"`
+	Vwap         string `json:"vwap"`
+	Suspect      bool   `json:"suspect"`
+	SessionClose bool   `json:"session_close"`
+	// Venues are a consolidated instrument's venue contributions
+	Venues []jsonVenue `json:"venues,omitempty"`
+	// StdDev and Bands are the VWAP's σ bands when enabled
+	StdDev string     `json:"stddev,omitempty"`
+	Bands  []JSONBand `json:"bands,omitempty"`
+}
+
+// JSONBand is the JSON object of a VWAP ±kσ band.
+type JSONBand struct {
+	Multiplier float64 `json:"multiplier"`
+	Lower      string  `json:"lower"`
+	Upper      string  `json:"upper"`
+}
+
+// jsonVenue is a venue's volume contribution to a consolidated VWAP.
+type jsonVenue struct {
+	Venue  string  `json:"venue"`
+	Volume string  `json:"volume"`
+	Share  float64 `json:"share"`
+}
+
+// writerSink encodes the results in its format to a writer i.e. stdout.
+type writerSink struct {
+	format Format
+	w      *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// NewWriterSink returns the sink encoding the results in the format to w.
+// Each result is flushed to w as written.
+func NewWriterSink(format Format, w io.Writer) (Sink, error) {
+	s := &writerSink{
+		format: format,
+		w:      bufio.NewWriter(w),
+	}
+
+	switch format {
+	case TextFormat:
+	case JSONLinesFormat:
+		s.json = json.NewEncoder(s.w)
+	case CSVFormat:
+		s.csv = csv.NewWriter(s.w)
+		if err := s.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown sink format %d", format)
+	}
+
+	return s, nil
+}
+
+func (s *writerSink) Write(res *Result) error {
+	vwap := res.Vwap.Text('f', res.Precision)
+
+	switch s.format {
+	case TextFormat:
+		notes := ""
+		if res.Suspect {
+			notes = " (suspect)"
+		}
+		if res.SessionClose {
+			notes += " (session close)"
+		}
+		if len(res.Venues) > 0 {
+			notes += " Venues:" + formatVenues(res.Venues, ",", func(share float64) string {
+				return strconv.FormatFloat(100*share, 'f', 2, 64) + "%"
+			})
+		}
+		if res.StdDev != nil {
+			notes += " StdDev:" + res.StdDev.Text('f', res.Precision) + " Bands:" + formatBands(res.Bands, ",", res.Precision)
+		}
+		if _, err := fmt.Fprintf(
+			s.w, "ProductID:%s Window:%s %s:%s%s\n", res.ProductID, res.Window, strings.ToUpper(res.Metric.String()), vwap, notes,
+		); err != nil {
+			return err
+		}
+	case JSONLinesFormat:
+		if err := s.json.Encode(jsonResult{
+			ProductID:    res.ProductID,
+			Window:       res.Window,
+			Metric:       res.Metric.String(),
+			Vwap:         vwap,
+			Suspect:      res.Suspect,
+			SessionClose: res.SessionClose,
+			Venues:       jsonVenues(res.Venues),
+			StdDev:       stdDevText(res.StdDev, res.Precision),
+			Bands:        JSONBands(res.Bands, res.Precision),
+		}); err != nil {
+			return err
+		}
+	case CSVFormat:
+		if err := s.csv.Write([]string{
+			res.ProductID,
+			res.Window,
+			vwap,
+			strconv.FormatBool(res.Suspect),
+			strconv.FormatBool(res.SessionClose),
+			formatVenues(res.Venues, ";", func(share float64) string {
+				return strconv.FormatFloat(share, 'f', 6, 64)
+			}),
+			res.Metric.String(),
+			stdDevText(res.StdDev, res.Precision),
+			formatBands(res.Bands, ";", res.Precision),
+		}); err != nil {
+			return err
+		}
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	return s.w.Flush()
+}
+
+func (s *writerSink) Close() error {
+	return s.w.Flush()
+}
+
+func (s *writerSink) String() string {
+	return s.format.String()
+}
+
+// formatVenues joins the venues' shares formatted by format with sep i.e.
+// binance=0.375;coinbase=0.625.
+func formatVenues(venues []types.VenueShare, sep string, format func(share float64) string) string {
+	var b strings.Builder
+	for i, v := range venues {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(v.Venue)
+		b.WriteByte('=')
+		b.WriteString(format(v.Share))
+	}
+
+	return b.String()
+}
+
+func jsonVenues(venues []types.VenueShare) []jsonVenue {
+	if len(venues) == 0 {
+		return nil
+	}
+
+	out := make([]jsonVenue, len(venues))
+	for i, v := range venues {
+		out[i] = jsonVenue{
+			Venue:  v.Venue,
+			Volume: v.Volume.Text('f', -1),
+			Share:  v.Share,
+		}
+	}
+
+	return out
+}
+
+// formatBands joins the bands' multipliers and their lower and upper bounds
+// with sep i.e. 1=60737.49/60762.49;2=60724.99/60774.99.
+func formatBands(bands []types.Band, sep string, precision int) string {
+	var b strings.Builder
+	for i, band := range bands {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(strconv.FormatFloat(band.Multiplier, 'g', -1, 64))
+		b.WriteByte('=')
+		b.WriteString(band.Lower.Text('f', precision))
+		b.WriteByte('/')
+		b.WriteString(band.Upper.Text('f', precision))
+	}
+
+	return b.String()
+}
+
+// stdDevText returns the standard deviation's decimal text, empty without
+// bands.
+func stdDevText(stdDev *big.Float, precision int) string {
+	if stdDev == nil {
+		return ""
+	}
+
+	return stdDev.Text('f', precision)
+}
+
+// JSONBands returns the JSON objects of the bands at the decimal digits of
+// precision, nil without bands.
+func JSONBands(bands []types.Band, precision int) []JSONBand {
+	if len(bands) == 0 {
+		return nil
+	}
+
+	out := make([]JSONBand, len(bands))
+	for i, band := range bands {
+		out[i] = JSONBand{
+			Multiplier: band.Multiplier,
+			Lower:      band.Lower.Text('f', precision),
+			Upper:      band.Upper.Text('f', precision),
+		}
+	}
+
+	return out
+}
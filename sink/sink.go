@@ -0,0 +1,130 @@
+// Package sink outputs the VWAP results to any number of sinks i.e. stdout
+// text, JSON Lines or CSV.
+package sink
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+// Result is a VWAP result as output by the sinks. It is a copy of the pooled
+// result shared read-only across the sinks.
+type Result struct {
+	types.VWAPResult
+	// Precision is the decimal digits of the output VWAP
+	Precision int
+}
+
+// Sink outputs the VWAP results. A sink is written by a single go routine.
+type Sink interface {
+	Write(res *Result) error
+	// Close flushes and releases the sink.
+	Close() error
+	// String names the sink in logs.
+	String() string
+}
+
+// dropsLogEvery throttles the logging of a slow sink's dropped results.
+const dropsLogEvery = 1000
+
+// buffered is a sink's queue of pending results drained by its own go
+// routine.
+type buffered struct {
+	// dropped counts the results the full queue dropped
+	dropped atomic.Uint64
+
+	sink Sink
+	q    chan *Result
+}
+
+// Fanout writes the results to each of its sinks through their own buffer,
+// so that a slow or failing sink neither blocks the results queue nor the
+// other sinks. A slow sink drops the results overflowing its buffer.
+type Fanout struct {
+	outs []*buffered
+	wg   sync.WaitGroup
+}
+
+// NewFanout returns the fan-out to the sinks each buffering up to bufferLen
+// results.
+func NewFanout(bufferLen int, sinks ...Sink) *Fanout {
+	f := &Fanout{
+		outs: make([]*buffered, len(sinks)),
+	}
+	for i, s := range sinks {
+		f.outs[i] = &buffered{
+			sink: s,
+			q:    make(chan *Result, bufferLen),
+		}
+	}
+
+	return f
+}
+
+// Start drains each sink's buffer in its own go routine until Close. The
+// sinks' errors are logged.
+func (f *Fanout) Start(ctx context.Context) {
+	logger := log.FromContext(ctx)
+
+	for _, out := range f.outs {
+		f.wg.Add(1)
+		go func(out *buffered) {
+			defer f.wg.Done()
+			for res := range out.q {
+				if err := out.sink.Write(res); err != nil {
+					logger.Error("sink write erred", zap.Stringer("sink", out.sink), zap.Error(err))
+				}
+			}
+		}(out)
+	}
+}
+
+// Write queues the result to each sink without blocking.
+func (f *Fanout) Write(ctx context.Context, res *Result) {
+	for _, out := range f.outs {
+		select {
+		case out.q <- res:
+		default:
+			if dropped := out.dropped.Add(1); dropped%dropsLogEvery == 1 {
+				log.FromContext(ctx).Warn(
+					"slow sink dropped results",
+					zap.Stringer("sink", out.sink),
+					zap.Uint64("dropped", dropped),
+				)
+			}
+		}
+	}
+}
+
+// Dropped returns the number of results dropped by the slow sinks.
+func (f *Fanout) Dropped() uint64 {
+	var dropped uint64
+	for _, out := range f.outs {
+		dropped += out.dropped.Load()
+	}
+
+	return dropped
+}
+
+// Close drains the buffered results to the sinks and closes them returning
+// the first error. Write must not be called afterwards.
+func (f *Fanout) Close() error {
+	for _, out := range f.outs {
+		close(out.q)
+	}
+	f.wg.Wait()
+
+	var firstErr error
+	for _, out := range f.outs {
+		if err := out.sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,171 @@
+package sink_test
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+func newResult(productID, window, vwap string, suspect, sessionClose bool) *sink.Result {
+	f, _, _ := new(big.Float).SetPrec(128).Parse(vwap, 10)
+	return &sink.Result{
+		VWAPResult: types.VWAPResult{
+			ProductID:    productID,
+			Window:       window,
+			Vwap:         f,
+			Suspect:      suspect,
+			SessionClose: sessionClose,
+		},
+		Precision: 2,
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	results := []*sink.Result{
+		newResult("BTC-USD", "200", "60749.987", false, false),
+		newResult("ETH-USD", "5m0s", "4302.4", true, true),
+		newResult("BTC-USD", "1m0s", "60000", false, false),
+		newResult("ETH-USD", "5m0s", "4300.123", false, false),
+	}
+	results[3].Metric = types.TWAPMetric
+	results[0].StdDev = big.NewFloat(12.5)
+	results[0].Bands = []types.Band{
+		{Multiplier: 1, Lower: big.NewFloat(60737.487), Upper: big.NewFloat(60762.487)},
+		{Multiplier: 2, Lower: big.NewFloat(60724.987), Upper: big.NewFloat(60774.987)},
+	}
+	results[2].Venues = []types.VenueShare{
+		{Venue: "binance", Volume: big.NewFloat(1.5), Share: 0.375},
+		{Venue: "coinbase", Volume: big.NewFloat(2.5), Share: 0.625},
+	}
+
+	tests := []struct {
+		format sink.Format
+		want   string
+	}{
+		{
+			format: sink.TextFormat,
+			want: "ProductID:BTC-USD Window:200 VWAP:60749.99 StdDev:12.50 Bands:1=60737.49/60762.49,2=60724.99/60774.99\n" +
+				"ProductID:ETH-USD Window:5m0s VWAP:4302.40 (suspect) (session close)\n" +
+				"ProductID:BTC-USD Window:1m0s VWAP:60000.00 Venues:binance=37.50%,coinbase=62.50%\n" +
+				"ProductID:ETH-USD Window:5m0s TWAP:4300.12\n",
+		},
+		{
+			format: sink.JSONLinesFormat,
+			want: `{"product_id":"BTC-USD","window":"200","metric":"vwap","vwap":"60749.99","suspect":false,"session_close":false,"stddev":"12.50","bands":[{"multiplier":1,"lower":"60737.49","upper":"60762.49"},{"multiplier":2,"lower":"60724.99","upper":"60774.99"}]}` + "\n" +
+				`{"product_id":"ETH-USD","window":"5m0s","metric":"vwap","vwap":"4302.40","suspect":true,"session_close":true}` + "\n" +
+				`{"product_id":"BTC-USD","window":"1m0s","metric":"vwap","vwap":"60000.00","suspect":false,"session_close":false,"venues":[{"venue":"binance","volume":"1.5","share":0.375},{"venue":"coinbase","volume":"2.5","share":0.625}]}` + "\n" +
+				`{"product_id":"ETH-USD","window":"5m0s","metric":"twap","vwap":"4300.12","suspect":false,"session_close":false}` + "\n",
+		},
+		{
+			format: sink.CSVFormat,
+			want: "product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands\n" +
+				"BTC-USD,200,60749.99,false,false,,vwap,12.50,1=60737.49/60762.49;2=60724.99/60774.99\n" +
+				"ETH-USD,5m0s,4302.40,true,true,,vwap,,\n" +
+				"BTC-USD,1m0s,60000.00,false,false,binance=0.375000;coinbase=0.625000,vwap,,\n" +
+				"ETH-USD,5m0s,4300.12,false,false,,twap,,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			var out bytes.Buffer
+			s, err := sink.NewWriterSink(tt.format, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, res := range results {
+				if err := s.Write(res); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []sink.Format{sink.TextFormat, sink.JSONLinesFormat, sink.CSVFormat} {
+		if got, err := sink.ParseFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParseFormat(%s) = %v, %v", f, got, err)
+		}
+	}
+	if _, err := sink.ParseFormat("xml"); err == nil {
+		t.Errorf("ParseFormat(xml) expected an error")
+	}
+}
+
+// blockedSink blocks its writes until released.
+type blockedSink struct {
+	release chan struct{}
+	mu      sync.Mutex
+	written int
+}
+
+func (s *blockedSink) Write(*sink.Result) error {
+	<-s.release
+	s.mu.Lock()
+	s.written++
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *blockedSink) Close() error   { return nil }
+func (s *blockedSink) String() string { return "blocked" }
+
+func TestFanout_SlowSinkDoesNotBlock(t *testing.T) {
+	const results, bufferLen = 100, 10
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+
+	var out bytes.Buffer
+	fast, err := sink.NewWriterSink(sink.CSVFormat, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := &blockedSink{release: make(chan struct{})}
+
+	fanout := sink.NewFanout(bufferLen, slow, fast)
+	fanout.Start(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < results; i++ {
+			fanout.Write(ctx, newResult("BTC-USD", "200", "1", false, false))
+			// let the fast sink keep up
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow sink blocked the fan-out")
+	}
+
+	close(slow.release)
+	if err := fanout.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blocked write plus the buffered ones
+	if slow.written > bufferLen+1 {
+		t.Errorf("slow sink wrote %d results, want at most %d", slow.written, bufferLen+1)
+	}
+	if got, want := fanout.Dropped(), uint64(results-slow.written); got != want {
+		t.Errorf("Dropped() = %d, want %d", got, want)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != results+1 {
+		t.Errorf("fast sink wrote %d lines, want %d", lines, results+1)
+	}
+}
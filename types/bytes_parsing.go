@@ -2,83 +2,307 @@ package types
 
 import (
 	"bytes"
+	"errors"
+	"math"
+	"math/big"
 	"strconv"
+	"time"
+	"unsafe"
 )
 
 //
 // byte parsing methods for TradeMsg json bytes
 //
 
+var (
+	// ErrFieldMissing is returned when the message lacks the key.
+	ErrFieldMissing = errors.New("missing field")
+	// ErrFieldMalformed is returned when the key's value is not of the
+	// expected type.
+	ErrFieldMalformed = errors.New("malformed field")
+)
+
+// FieldError reports the key of a missing or malformed message field.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error() + " " + e.Field
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldKey is a message key quoted i.e. `"price"` to search for it without
+// allocating.
+type FieldKey []byte
+
+// Name returns the key without the quotes.
+func (k FieldKey) Name() string {
+	return string(k[1 : len(k)-1])
+}
+
+// The match message keys of this service
+var (
+	TypeKey      = FieldKey(`"type"`)
+	ProductIDKey = FieldKey(`"product_id"`)
+	PriceKey     = FieldKey(`"price"`)
+	SizeKey      = FieldKey(`"size"`)
+	SequenceKey  = FieldKey(`"sequence"`)
+	TimeKey      = FieldKey(`"time"`)
+)
+
 const (
-	msgTypeSkipSep   = 3
-	msgVolumeSkipSep = 21
-	msgPriceSkipSep  = 25
-	msgProductSkipSep  = 29
-	tokenSep         = '"'
+	tokenSep = '"'
+	keySep   = ':'
+	escape   = '\\'
 )
 
-type ParseToken struct {
-	Token      string
-	SepSkipCnt int
+func ParseType(msg []byte) (string, error) {
+	return ParseString(TypeKey, msg)
+}
+
+func ParseProductID(msg []byte) (string, error) {
+	return ParseString(ProductIDKey, msg)
+}
+
+func ParsePrice(msg []byte) (float64, error) {
+	return ParseF64(PriceKey, msg)
 }
 
-func ParseType(msg []byte) (string, int) {
-	return ParseString(tokenSep, msgTypeSkipSep, msg)
+func ParseVolume(msg []byte) (float64, error) {
+	return ParseF64(SizeKey, msg)
 }
 
-func ParseProductID(msg []byte) (string, int) {
-	return ParseString(tokenSep, msgProductSkipSep, msg)
+// ParseBigPrice parses the decimal price text straight into z.
+func ParseBigPrice(msg []byte, z *big.Float) error {
+	return ParseBigFloat(PriceKey, msg, z)
 }
 
-func ParsePrice(msg []byte) (float64, int) {
-	return ParseF64(tokenSep, msgPriceSkipSep, msg)
+// ParseBigVolume parses the decimal size text straight into z.
+func ParseBigVolume(msg []byte, z *big.Float) error {
+	return ParseBigFloat(SizeKey, msg, z)
+}
+
+// ParseSequence parses the unquoted matches sequence number.
+func ParseSequence(msg []byte) (int64, error) {
+	return ParseInt64(SequenceKey, msg)
+}
+
+// ParseTime parses the RFC3339 trade time.
+func ParseTime(msg []byte) (time.Time, error) {
+	val, err := ParseBytes(TimeKey, msg)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, bytesString(val))
+	if err != nil {
+		return time.Time{}, &FieldError{Field: TimeKey.Name(), Err: ErrFieldMalformed}
+	}
+
+	return t, nil
 }
 
-func ParseVolume(msg []byte) (float64, int) {
-	return ParseF64(tokenSep, msgVolumeSkipSep, msg)
+// ParseString returns a copy of the key's string value. See ParseBytes for
+// reading it without the copy.
+func ParseString(key FieldKey, msg []byte) (string, error) {
+	val, err := ParseBytes(key, msg)
+	if err != nil {
+		return "", err
+	}
+
+	return string(val), nil
 }
 
-func ParseString(tokenSep byte, skipCnt int, msg []byte) (string, int){
-	val, startIdx := parseVal(tokenSep, skipCnt, msg)
-	if startIdx == -1 {
-		return "", -1
+// ParseBytes returns the key's string value sharing the message's bytes.
+func ParseBytes(key FieldKey, msg []byte) ([]byte, error) {
+	val, quoted, err := ParseVal(key, msg)
+	if err != nil {
+		return nil, err
+	}
+	if !quoted {
+		return nil, &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
 	}
 
-	return string(val), startIdx
+	return val, nil
 }
 
-func ParseF64(tokenSep  byte, skipCnt int, msg []byte) (float64, int){
-	val, startIdx := ParseString(tokenSep, skipCnt, msg)
-	if startIdx == -1 {
-		return -1, -1
+// ParseF64 returns the key's number value whether quoted i.e. "price":"4606.8"
+// or not.
+func ParseF64(key FieldKey, msg []byte) (float64, error) {
+	val, _, err := ParseVal(key, msg)
+	if err != nil {
+		return -1, err
+	}
+
+	f64Val, err := strconv.ParseFloat(bytesString(val), 64)
+	if err != nil {
+		return -1, &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
 	}
 
-	f64Val, err := strconv.ParseFloat(val, 64)
+	return f64Val, nil
+}
+
+// ParseBigFloat parses the key's decimal price or size text into z at z's
+// precision and rounding mode, sparing the float64 round trip of ParseF64. A
+// zero precision z is set to 64 bits. An infinite, zero or negative value is
+// malformed.
+func ParseBigFloat(key FieldKey, msg []byte, z *big.Float) error {
+	val, _, err := ParseVal(key, msg)
 	if err != nil {
-		return -1, -1
+		return err
 	}
 
-	return f64Val, startIdx
+	if _, _, err := z.Parse(bytesString(val), 10); err != nil || !ValidAmount(z) {
+		return &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
+	}
+
+	return nil
+}
+
+// ValidAmount returns whether z is a finite positive price or size. The
+// windows subtract the amounts they drop, which panics on Inf - Inf.
+func ValidAmount(z *big.Float) bool {
+	return !z.IsInf() && z.Sign() > 0
 }
 
-func parseVal(tokenSep byte, skipCnt int, msg []byte) ([]byte, int) {
-	if len(msg) == 0 {
-		return nil, -1
+// ParseInt64 returns the key's unquoted integer value i.e. "sequence":22394045199
+func ParseInt64(key FieldKey, msg []byte) (int64, error) {
+	val, quoted, err := ParseVal(key, msg)
+	if err != nil {
+		return -1, err
 	}
 
-	var (
-		accIdx, beginIdx, endIdx int
-	)
-	for i := 0; i < skipCnt; i++ {
-		beginIdx = bytes.IndexByte(msg[accIdx:], tokenSep)
-		if beginIdx == -1 {
-			return nil, -1
+	i64Val, ok := parseInt64(val)
+	if quoted || !ok {
+		return -1, &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
+	}
+
+	return i64Val, nil
+}
+
+// ParseVal returns the raw bytes of the key's value without the quotes of a
+// string value and whether it was quoted. It looks the key up with
+// bytes.Index() rather than decoding the message, so nested objects and arrays
+// are not supported as values.
+func ParseVal(key FieldKey, msg []byte) ([]byte, bool, error) {
+	for offset := 0; offset < len(msg); {
+		keyIdx := bytes.Index(msg[offset:], key)
+		if keyIdx == -1 {
+			break
+		}
+		keyIdx += offset
+		offset = keyIdx + len(key)
+
+		// a key opens the object or follows a comma
+		if prev := prevNonSpace(msg, keyIdx); prev != '{' && prev != ',' && prev != 0 {
+			continue
+		}
+		valIdx := skipSpace(msg, offset)
+		if valIdx == len(msg) || msg[valIdx] != keySep {
+			continue
+		}
+		valIdx = skipSpace(msg, valIdx+1)
+
+		return parseRawVal(key, msg, valIdx)
+	}
+
+	return nil, false, &FieldError{Field: key.Name(), Err: ErrFieldMissing}
+}
+
+func parseRawVal(key FieldKey, msg []byte, valIdx int) ([]byte, bool, error) {
+	if valIdx == len(msg) {
+		return nil, false, &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
+	}
+
+	if msg[valIdx] == tokenSep {
+		begin := valIdx + 1
+		for end := begin; end < len(msg); end++ {
+			switch msg[end] {
+			case escape:
+				end++
+			case tokenSep:
+				return msg[begin:end], true, nil
+			}
 		}
-		accIdx += beginIdx + 1
+
+		return nil, false, &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
+	}
+
+	end := valIdx
+	for end < len(msg) && msg[end] != ',' && msg[end] != '}' && !isSpace(msg[end]) {
+		end++
 	}
-	endIdx = bytes.IndexByte(msg[accIdx+1:], tokenSep)
-	if endIdx == -1 {
-		return nil, -1
+	if end == valIdx {
+		return nil, false, &FieldError{Field: key.Name(), Err: ErrFieldMalformed}
 	}
-	return msg[accIdx:accIdx+endIdx+1], accIdx
-}
\ No newline at end of file
+
+	return msg[valIdx:end], false, nil
+}
+
+// bytesString returns the bytes as a string without copying them. It serves
+// the parsers that do not retain the string past their return i.e. strconv and
+// time.Parse, the bytes must not change meanwhile.
+func bytesString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// parseInt64 parses an optionally signed decimal integer without the string
+// conversion of strconv. It rejects the values beyond the int64 range.
+func parseInt64(val []byte) (int64, bool) {
+	neg := len(val) > 0 && val[0] == '-'
+	if neg {
+		val = val[1:]
+	}
+	if len(val) == 0 {
+		return 0, false
+	}
+
+	// the magnitude of math.MinInt64 is one past math.MaxInt64
+	limit := uint64(math.MaxInt64)
+	if neg {
+		limit++
+	}
+	var n uint64
+	for _, c := range val {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		d := uint64(c - '0')
+		if n > (limit-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
+	}
+	if neg {
+		return -int64(n), true
+	}
+
+	return int64(n), true
+}
+
+func prevNonSpace(msg []byte, idx int) byte {
+	for idx--; idx >= 0; idx-- {
+		if !isSpace(msg[idx]) {
+			return msg[idx]
+		}
+	}
+
+	return 0
+}
+
+func skipSpace(msg []byte, idx int) int {
+	for idx < len(msg) && isSpace(msg[idx]) {
+		idx++
+	}
+
+	return idx
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
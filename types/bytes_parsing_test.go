@@ -1,23 +1,34 @@
 package types
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testMatchMsg = `{"type":"match","trade_id":178622422,"maker_order_id":"253c56b0-f115-4364-9e06-65ffd2412f3b","taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"0.00269988","price":"4606.8","product_id":"ETH-USD","sequence":22394045199,"time":"2021-11-10T21:37:07.988255Z"}`
 
 func TestParseString(t *testing.T) {
-	type ParserFunc func([]byte)(string, int)
+	type ParserFunc func([]byte) (string, error)
 	type args struct {
-		tokenFunc     ParserFunc
-		msg      []byte
+		tokenFunc ParserFunc
+		msg       []byte
 	}
 	tests := []struct {
-		name string
-		args args
-		want string
+		name    string
+		args    args
+		want    string
+		wantErr error
 	}{
 		{
 			name: "Parse Type",
 			args: args{
 				tokenFunc: ParseType,
-				msg:      []byte(`"type":"match"`),
+				msg:       []byte(`"type":"match"`),
 			},
 			want: "match",
 		},
@@ -25,25 +36,77 @@ func TestParseString(t *testing.T) {
 			name: "Parse product id",
 			args: args{
 				tokenFunc: ParseProductID,
-				msg:       []byte(`"type":"match","trade_id":178622422,"maker_order_id":"253c56b0-f115-4364-9e06-65ffd2412f3b","taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"0.00269988","price":"4606.8","product_id":"ETH-USD","sequence":22394045199,"time":"2021-11-10T21:37:07.988255Z"`),
+				msg:       []byte(testMatchMsg),
 			},
 			want: "ETH-USD",
 		},
 		{
 			name: "Parse time",
 			args: args{
-				tokenFunc: func(msg []byte) (string, int) {
-					return ParseString(tokenSep, 35, msg)
+				tokenFunc: func(msg []byte) (string, error) {
+					return ParseString(TimeKey, msg)
 				},
-				msg:      []byte(`"type":"match","trade_id":178622422,"maker_order_id":"253c56b0-f115-4364-9e06-65ffd2412f3b","taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"0.00269988","price":"4606.8","product_id":"ETH-USD","sequence":22394045199,"time":"2021-11-10T21:37:07.988255Z"`),
+				msg: []byte(testMatchMsg),
 			},
 			want: "2021-11-10T21:37:07.988255Z",
 		},
+		{
+			name: "Parse reordered fields with spaces",
+			args: args{
+				tokenFunc: ParseProductID,
+				msg:       []byte(`{ "product_id" : "BTC-USD", "type": "match", "price": "1" }`),
+			},
+			want: "BTC-USD",
+		},
+		{
+			name: "Key within a value is skipped",
+			args: args{
+				tokenFunc: ParseType,
+				msg:       []byte(`{"reason":"\"type\"","type":"error"}`),
+			},
+			want: "error",
+		},
+		{
+			name: "Missing order id does not shift fields",
+			args: args{
+				tokenFunc: ParseProductID,
+				msg:       []byte(`{"type":"match","trade_id":178622422,"taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"0.00269988","price":"4606.8","product_id":"ETH-USD","sequence":22394045199}`),
+			},
+			want: "ETH-USD",
+		},
+		{
+			name: "Missing",
+			args: args{
+				tokenFunc: ParseProductID,
+				msg:       []byte(`{"type":"match"}`),
+			},
+			wantErr: ErrFieldMissing,
+		},
+		{
+			name: "Not a string",
+			args: args{
+				tokenFunc: ParseProductID,
+				msg:       []byte(`{"product_id":5}`),
+			},
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name: "Unterminated",
+			args: args{
+				tokenFunc: ParseProductID,
+				msg:       []byte(`{"product_id":"ETH-`),
+			},
+			wantErr: ErrFieldMalformed,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				if gotVal, _ := tt.args.tokenFunc(tt.args.msg); gotVal != tt.want {
+				gotVal, err := tt.args.tokenFunc(tt.args.msg)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ParseString() err = %v, want %v", err, tt.wantErr)
+				}
+				if gotVal != tt.want {
 					t.Errorf("ParseString() = %v, want %v", gotVal, tt.want)
 				}
 			},
@@ -52,21 +115,22 @@ func TestParseString(t *testing.T) {
 }
 
 func TestParseF64(t *testing.T) {
-	type ParserFunc func([]byte)(float64, int)
+	type ParserFunc func([]byte) (float64, error)
 	type args struct {
-		tokenFunc     ParserFunc
-		msg      []byte
+		tokenFunc ParserFunc
+		msg       []byte
 	}
 	tests := []struct {
-		name  string
-		args  args
-		want  float64
+		name    string
+		args    args
+		want    float64
+		wantErr error
 	}{
 		{
 			name: "Parse volume",
 			args: args{
 				tokenFunc: ParseVolume,
-				msg:      []byte(`"type":"match","trade_id":178622422,"maker_order_id":"253c56b0-f115-4364-9e06-65ffd2412f3b","taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"0.00269988","price":"4606.8","product_id":"ETH-USD","sequence":22394045199,"time":"2021-11-10T21:37:07.988255Z"`),
+				msg:       []byte(testMatchMsg),
 			},
 			want: 0.00269988,
 		},
@@ -74,19 +138,332 @@ func TestParseF64(t *testing.T) {
 			name: "Parse price",
 			args: args{
 				tokenFunc: ParsePrice,
-				msg:      []byte(`"type":"match","trade_id":178622422,"maker_order_id":"253c56b0-f115-4364-9e06-65ffd2412f3b","taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"0.00269988","price":"4606.8","product_id":"ETH-USD","sequence":22394045199,"time":"2021-11-10T21:37:07.988255Z"`),
+				msg:       []byte(testMatchMsg),
+			},
+			want: 4606.8,
+		},
+		{
+			name: "Parse unquoted price",
+			args: args{
+				tokenFunc: ParsePrice,
+				msg:       []byte(`{"price":4606.8}`),
 			},
 			want: 4606.8,
 		},
+		{
+			name: "Malformed price",
+			args: args{
+				tokenFunc: ParsePrice,
+				msg:       []byte(`{"price":"4606.8.1"}`),
+			},
+			want:    -1,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name: "Missing volume",
+			args: args{
+				tokenFunc: ParseVolume,
+				msg:       []byte(`{"price":"4606.8"}`),
+			},
+			want:    -1,
+			wantErr: ErrFieldMissing,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, _ := tt.args.tokenFunc(tt.args.msg)
+				got, err := tt.args.tokenFunc(tt.args.msg)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ParseF64() err = %v, want %v", err, tt.wantErr)
+				}
 				if got != tt.want {
 					t.Errorf("ParseF64() got = %v, want %v", got, tt.want)
 				}
 			},
 		)
 	}
-}
\ No newline at end of file
+}
+
+func TestParseBigFloat(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     FieldKey
+		msg     []byte
+		prec    uint
+		mode    big.RoundingMode
+		want    string
+		wantErr error
+	}{
+		{
+			name: "Volume without precision loss",
+			key:  SizeKey,
+			msg:  []byte(testMatchMsg),
+			prec: 128,
+			want: "0.00269988",
+		},
+		{
+			name: "Price without precision loss",
+			key:  PriceKey,
+			msg:  []byte(testMatchMsg),
+			prec: 128,
+			want: "4606.8",
+		},
+		{
+			name: "Rounding mode at a low precision",
+			key:  PriceKey,
+			msg:  []byte(`{"price":"4606.8"}`),
+			prec: 8,
+			mode: big.ToZero,
+			want: "4576",
+		},
+		{
+			name:    "Malformed",
+			key:     SizeKey,
+			msg:     []byte(`{"size":"0.0026x"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Infinite price",
+			key:     PriceKey,
+			msg:     []byte(`{"price":"Inf"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Negative infinite size",
+			key:     SizeKey,
+			msg:     []byte(`{"size":"-Inf"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Unquoted infinite size",
+			key:     SizeKey,
+			msg:     []byte(`{"size":+Inf}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Negative price",
+			key:     PriceKey,
+			msg:     []byte(`{"price":"-4606.8"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Negative size",
+			key:     SizeKey,
+			msg:     []byte(`{"size":"-0.00269988"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Zero price",
+			key:     PriceKey,
+			msg:     []byte(`{"price":"0"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Zero size",
+			key:     SizeKey,
+			msg:     []byte(`{"size":"0.00000000"}`),
+			prec:    128,
+			wantErr: ErrFieldMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				z := new(big.Float).SetPrec(tt.prec).SetMode(tt.mode)
+				err := ParseBigFloat(tt.key, tt.msg, z)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseBigFloat() err = %v, want %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if got := z.Text('g', 36); got != tt.want {
+					t.Errorf("ParseBigFloat() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestParseBigFloat_Float64RoundTripLoss(t *testing.T) {
+	exact, _ := new(big.Rat).SetString("0.00269988")
+
+	f64, _ := ParseVolume([]byte(testMatchMsg))
+	roundTrip, _ := new(big.Float).SetPrec(128).SetFloat64(f64).Rat(nil)
+
+	parsed := new(big.Float).SetPrec(128)
+	if err := ParseBigVolume([]byte(testMatchMsg), parsed); err != nil {
+		t.Fatal(err)
+	}
+	direct, _ := parsed.Rat(nil)
+
+	errOf := func(r *big.Rat) float64 {
+		diff, _ := new(big.Rat).Abs(new(big.Rat).Sub(r, exact)).Float64()
+		return diff
+	}
+	if errOf(direct) >= errOf(roundTrip) {
+		t.Errorf("decimal parsing error %g, want below the float64 round trip error %g", errOf(direct), errOf(roundTrip))
+	}
+	if errOf(direct) > 1e-38 {
+		t.Errorf("decimal parsing error %g, want within 128 bits", errOf(direct))
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    int64
+		wantErr error
+	}{
+		{
+			name: "Parse sequence",
+			msg:  []byte(testMatchMsg),
+			want: 22394045199,
+		},
+		{
+			name:    "Missing sequence",
+			msg:     []byte(`"type":"match"`),
+			want:    -1,
+			wantErr: ErrFieldMissing,
+		},
+		{
+			name:    "Quoted sequence",
+			msg:     []byte(`{"sequence":"22394045199"}`),
+			want:    -1,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name: "Largest sequence",
+			msg:  []byte(`{"sequence":9223372036854775807}`),
+			want: math.MaxInt64,
+		},
+		{
+			name:    "Overflowing sequence",
+			msg:     []byte(`{"sequence":9223372036854775808}`),
+			want:    -1,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Overflowing 20 digits sequence",
+			msg:     []byte(`{"sequence":18446744073709551626}`),
+			want:    -1,
+			wantErr: ErrFieldMalformed,
+		},
+		{
+			name:    "Fractional sequence",
+			msg:     []byte(`{"sequence":2239.4}`),
+			want:    -1,
+			wantErr: ErrFieldMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseSequence(tt.msg)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ParseSequence() err = %v, want %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("ParseSequence() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	got, err := ParseTime([]byte(testMatchMsg))
+	if err != nil {
+		t.Fatalf("ParseTime() err = %v", err)
+	}
+	if want := time.Date(2021, 11, 10, 21, 37, 7, 988255000, time.UTC); !got.Equal(want) {
+		t.Errorf("ParseTime() = %v, want %v", got, want)
+	}
+
+	var fieldErr *FieldError
+	if _, err := ParseTime([]byte(`{"time":"yesterday"}`)); !errors.As(err, &fieldErr) || fieldErr.Field != "time" {
+		t.Errorf("ParseTime() err = %v, want a malformed time field error", err)
+	}
+}
+
+func TestParse_Allocs(t *testing.T) {
+	msg := []byte(testMatchMsg)
+
+	tests := []struct {
+		name  string
+		parse func()
+	}{
+		{"ParseVal", func() { _, _, _ = ParseVal(PriceKey, msg) }},
+		{"ParseBytes", func() { _, _ = ParseBytes(ProductIDKey, msg) }},
+		{"ParseF64", func() { _, _ = ParsePrice(msg) }},
+		{"ParseSequence", func() { _, _ = ParseSequence(msg) }},
+		{"ParseTime", func() { _, _ = ParseTime(msg) }},
+	}
+	for _, tt := range tests {
+		if allocs := testing.AllocsPerRun(100, tt.parse); allocs != 0 {
+			t.Errorf("%s() allocs = %v, want 0", tt.name, allocs)
+		}
+	}
+
+	// the scanner adds none to big.Float's own parsing allocations
+	z := new(big.Float).SetPrec(128)
+	want := testing.AllocsPerRun(100, func() { _, _, _ = z.Parse("4606.8", 10) })
+	if got := testing.AllocsPerRun(100, func() { _ = ParseBigPrice(msg, z) }); got != want {
+		t.Errorf("ParseBigPrice() allocs = %v, want big.Float.Parse()'s %v", got, want)
+	}
+}
+
+func BenchmarkParseTrade(b *testing.B) {
+	msg := []byte(testMatchMsg)
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_, _ = ParseBytes(TypeKey, msg)
+		_, _ = ParseBytes(ProductIDKey, msg)
+		_, _ = ParsePrice(msg)
+		_, _ = ParseVolume(msg)
+	}
+}
+
+// BenchmarkParseTrade_SeparatorCount parses the same fields by counting the
+// double quotes preceding them as the key lookup's predecessor did.
+func BenchmarkParseTrade_SeparatorCount(b *testing.B) {
+	const (
+		msgTypeSkipSep    = 3
+		msgVolumeSkipSep  = 21
+		msgPriceSkipSep   = 25
+		msgProductSkipSep = 29
+	)
+	parseVal := func(skipCnt int, msg []byte) []byte {
+		var accIdx int
+		for i := 0; i < skipCnt; i++ {
+			beginIdx := bytes.IndexByte(msg[accIdx:], tokenSep)
+			if beginIdx == -1 {
+				return nil
+			}
+			accIdx += beginIdx + 1
+		}
+		endIdx := bytes.IndexByte(msg[accIdx+1:], tokenSep)
+		if endIdx == -1 {
+			return nil
+		}
+		return msg[accIdx : accIdx+endIdx+1]
+	}
+	msg := []byte(testMatchMsg)
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		_ = parseVal(msgTypeSkipSep, msg)
+		_ = parseVal(msgProductSkipSep, msg)
+		_, _ = strconv.ParseFloat(string(parseVal(msgPriceSkipSep, msg)), 64)
+		_, _ = strconv.ParseFloat(string(parseVal(msgVolumeSkipSep, msg)), 64)
+	}
+}
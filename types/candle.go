@@ -0,0 +1,46 @@
+package types
+
+import (
+	"math/big"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Candle is a product's OHLCV candle of the trades within its interval.
+type Candle struct {
+	ProductID string
+	// Interval is the candle's duration i.e. 1m
+	Interval time.Duration
+	// Start is the candle's wall-clock boundary. The candle spans
+	// [Start, Start+Interval).
+	Start time.Time
+	// Open, High, Low and Close are the first, highest, lowest and last trade
+	// prices. A candle without trades is flat at the previous close.
+	Open  *big.Float
+	High  *big.Float
+	Low   *big.Float
+	Close *big.Float
+	// Volume is the candle's traded size
+	Volume *big.Float
+	// Vwap is the candle's VWAP, zero without trades
+	Vwap *big.Float
+	// Trades counts the candle's trades
+	Trades uint64
+}
+
+type CandlesQ chan *Candle
+
+func (c *Candle) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("productID", c.ProductID)
+	enc.AddDuration("interval", c.Interval)
+	enc.AddTime("start", c.Start)
+	enc.AddString("open", c.Open.String())
+	enc.AddString("high", c.High.String())
+	enc.AddString("low", c.Low.String())
+	enc.AddString("close", c.Close.String())
+	enc.AddString("volume", c.Volume.String())
+	enc.AddString("vwap", c.Vwap.String())
+	enc.AddUint64("trades", c.Trades)
+	return nil
+}
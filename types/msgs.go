@@ -1,9 +1,11 @@
 package types
 
 import (
-	"go.uber.org/zap/zapcore"
 	"math/big"
 	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
 )
 
 // TradesQ is the queue of received trade values to be processed by the workers
@@ -44,18 +46,89 @@ type TradeValue struct {
 	ProductID string
 	Price     *big.Float
 	Size      *big.Float
+	// Sequence is the product's matches channel sequence number
+	Sequence int64
+	// Time is the trade's execution time
+	Time time.Time
+	// Gap is true for the first trade past a sequence gap or regression. The
+	// worker applies the gap policy before producing it, so that the policy
+	// takes effect in the product's trades order.
+	Gap bool
+	// Venue is the trade's exchange when streaming several venues at once,
+	// empty otherwise
+	Venue string
 }
 
 type VWAPResult struct {
 	ProductID string
-	Vwap      *big.Float
+	// Vwap is the result's value of its Metric
+	Vwap *big.Float
+	// Metric tells apart the calculators' results sharing the results queue
+	Metric Metric
+	// Window labels the result's window i.e. "200" trades or "5m0s"
+	Window string
+	// Suspect is true while the moving window spans a sequence gap
+	Suspect bool
+	// SessionClose is true for the final VWAP of an anchored window's session
+	// queued before its reset
+	SessionClose bool
+	// Venues are the volume contributions of a consolidated instrument's
+	// venues ordered by venue, nil for the other products
+	Venues []VenueShare
+	// StdDev is the volume-weighted standard deviation of the window's
+	// prices around the VWAP, nil without bands
+	StdDev *big.Float
+	// Bands are the VWAP ±kσ bands of the product's multipliers k ordered as
+	// configured, nil without bands and for the other metrics
+	Bands []Band
+}
+
+// Band is the VWAP ±kσ band of a multiplier k.
+type Band struct {
+	Multiplier float64
+	// Upper is the VWAP + kσ
+	Upper *big.Float
+	// Lower is the VWAP - kσ
+	Lower *big.Float
+}
+
+// VenueShare is a venue's volume contribution to a consolidated VWAP.
+type VenueShare struct {
+	Venue string
+	// Volume is the venue's weighted volume within the window
+	Volume *big.Float
+	// Share is the venue's fraction of the window's volume
+	Share float64
+}
+
+// Metric is the average price computed off a product's window.
+type Metric uint8
+
+const (
+	// VWAPMetric is the volume-weighted average price.
+	VWAPMetric Metric = iota
+	// TWAPMetric is the time-weighted average price.
+	TWAPMetric
+)
+
+func (m Metric) String() string {
+	switch m {
+	case VWAPMetric:
+		return "vwap"
+	case TWAPMetric:
+		return "twap"
+	default:
+		return "unknown"
+	}
 }
 
 type ResultsQ chan *VWAPResult
 
+// BigFloatMemPool recycles big.Float values. New values have a zero precision
+// so that they take on the precision of the operands they are set to.
 var BigFloatMemPool = sync.Pool{
 	New: func() interface{} {
-		return big.NewFloat(0)
+		return new(big.Float)
 	},
 }
 
@@ -72,12 +145,21 @@ var VWAPResultMemPool = sync.Pool{
 func (t *TradeValue) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("price", t.Price.String())
 	enc.AddString("volume", t.Size.String())
+	enc.AddInt64("sequence", t.Sequence)
+	enc.AddTime("time", t.Time)
 	return nil
 }
 
 func (v *VWAPResult) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("productID", v.ProductID)
+	enc.AddString("window", v.Window)
+	enc.AddString("metric", v.Metric.String())
 	enc.AddString("vwap", v.Vwap.String())
+	enc.AddBool("suspect", v.Suspect)
+	enc.AddBool("sessionClose", v.SessionClose)
+	if v.StdDev != nil {
+		enc.AddString("stddev", v.StdDev.String())
+	}
 	return nil
 }
 
@@ -0,0 +1,100 @@
+package types
+
+import (
+	"fmt"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SequenceEventKind classifies a discontinuity in a product's matches
+// sequence numbers.
+type SequenceEventKind uint8
+
+const (
+	// SequenceGap is a sequence number skipping ahead i.e. lost messages.
+	SequenceGap SequenceEventKind = iota + 1
+	// SequenceDuplicate is a repeated sequence number.
+	SequenceDuplicate
+	// SequenceRegression is a sequence number behind the last one.
+	SequenceRegression
+)
+
+func (k SequenceEventKind) String() string {
+	switch k {
+	case SequenceGap:
+		return "gap"
+	case SequenceDuplicate:
+		return "duplicate"
+	case SequenceRegression:
+		return "regression"
+	default:
+		return "unknown"
+	}
+}
+
+// SequenceEvent reports a product's sequence discontinuity detected while
+// ingesting the matches channel.
+type SequenceEvent struct {
+	Kind      SequenceEventKind
+	ProductID string
+	// Last is the previously observed sequence number of the product
+	Last int64
+	// Sequence is the sequence number of the message at hand
+	Sequence int64
+}
+
+// Missed returns the number of messages skipped by a gap.
+func (e *SequenceEvent) Missed() int64 {
+	if e.Kind != SequenceGap {
+		return 0
+	}
+
+	return e.Sequence - e.Last - 1
+}
+
+func (e *SequenceEvent) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("kind", e.Kind.String())
+	enc.AddString("productID", e.ProductID)
+	enc.AddInt64("last", e.Last)
+	enc.AddInt64("sequence", e.Sequence)
+	enc.AddInt64("missed", e.Missed())
+	return nil
+}
+
+// GapPolicy is the action taken on a product's VWAP upon a sequence gap or
+// regression.
+type GapPolicy uint8
+
+const (
+	// GapPolicyIgnore reports the event and carries on.
+	GapPolicyIgnore GapPolicy = iota
+	// GapPolicySuspect flags the product's VWAP results as suspect until the
+	// moving window no longer spans the discontinuity.
+	GapPolicySuspect
+	// GapPolicyReset empties the product's moving window.
+	GapPolicyReset
+)
+
+func (p GapPolicy) String() string {
+	switch p {
+	case GapPolicyIgnore:
+		return "ignore"
+	case GapPolicySuspect:
+		return "suspect"
+	case GapPolicyReset:
+		return "reset"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseGapPolicy returns the policy matching its name.
+func ParseGapPolicy(name string) (GapPolicy, error) {
+	for _, p := range []GapPolicy{GapPolicyIgnore, GapPolicySuspect, GapPolicyReset} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+
+	return GapPolicyIgnore, fmt.Errorf("unknown gap policy %q", name)
+}
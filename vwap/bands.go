@@ -0,0 +1,79 @@
+package vwap
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/blewater/zh/types"
+)
+
+// Bander adds the VWAP ±kσ bands of the windows' volume-weighted price
+// variance to the VWAP results.
+type Bander interface {
+	// SetBands selects the multipliers k of the product's VWAP results' bands
+	// i.e. 1 and 2. The results carry no bands until set.
+	SetBands(productID string, multipliers []float64) error
+}
+
+// SetBands selects the σ multipliers of the product's VWAP results' bands,
+// none clearing them.
+func (v *ProductsVwap) SetBands(productID string, multipliers []float64) error {
+	pw, err := v.windows(productID)
+	if err != nil {
+		return err
+	}
+	for _, k := range multipliers {
+		if !(k > 0) || math.IsInf(k, 1) {
+			return fmt.Errorf("%s: the band multiplier %v must be positive", productID, k)
+		}
+	}
+
+	// the windows' results read the bands under their locks
+	for _, window := range pw.queues {
+		window.Lock()
+	}
+	pw.bands = append([]float64(nil), multipliers...)
+	for _, window := range pw.queues {
+		window.Unlock()
+	}
+
+	return nil
+}
+
+// newBands returns the standard deviation of the window's prices weighted by
+// their volumes around the vwap, and its ±kσ bands of the multipliers. The
+// variance is Σ(v·p²)/Σv - vwap² off the last data point's sums. It is zero
+// for an empty window and when rounding takes it below zero.
+func newBands(last *vwapCache, vwap *big.Float, multipliers []float64) (*big.Float, []types.Band) {
+	stdDev := new(big.Float)
+	if last != nil && last.TVol.Sign() != 0 {
+		sq := types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+		stdDev.Quo(last.TPV2, last.TVol)
+		stdDev.Sub(stdDev, sq.Mul(vwap, vwap))
+		types.BigFloatMemPool.Put(sq)
+
+		if stdDev.Sign() > 0 {
+			stdDev.Sqrt(stdDev)
+		} else {
+			// a zero precision +0 rather than the negative rounding's -0
+			stdDev = new(big.Float)
+		}
+	}
+
+	bands := make([]types.Band, len(multipliers))
+	k := types.BigFloatMemPool.Get().(*big.Float)
+	for i, multiplier := range multipliers {
+		width := new(big.Float).Mul(stdDev, k.SetPrec(0).SetFloat64(multiplier))
+		bands[i] = types.Band{
+			Multiplier: multiplier,
+			Upper:      new(big.Float).Add(vwap, width),
+			Lower:      width.Sub(vwap, width),
+		}
+	}
+	types.BigFloatMemPool.Put(k)
+
+	return stdDev, bands
+}
+
+var _ Bander = (*ProductsVwap)(nil)
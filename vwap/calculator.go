@@ -0,0 +1,68 @@
+package vwap
+
+import (
+	"fmt"
+
+	"github.com/blewater/zh/types"
+)
+
+// Calculator is a metric computed off a product's windows.
+type Calculator uint8
+
+const (
+	// VWAPCalculator is the volume-weighted average price.
+	VWAPCalculator Calculator = iota
+	// TWAPCalculator is the time-weighted average price: each trade price is
+	// weighted by how long it stood until the next trade.
+	TWAPCalculator
+)
+
+func (c Calculator) String() string {
+	switch c {
+	case VWAPCalculator:
+		return "vwap"
+	case TWAPCalculator:
+		return "twap"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCalculator returns the calculator matching its name.
+func ParseCalculator(name string) (Calculator, error) {
+	for _, c := range []Calculator{VWAPCalculator, TWAPCalculator} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+
+	return VWAPCalculator, fmt.Errorf("unknown calculator %q", name)
+}
+
+// Metric returns the metric of the calculator's results.
+func (c Calculator) Metric() types.Metric {
+	if c == TWAPCalculator {
+		return types.TWAPMetric
+	}
+
+	return types.VWAPMetric
+}
+
+// CalculatorOf returns the calculator of the result's metric.
+func CalculatorOf(metric types.Metric) Calculator {
+	if metric == types.TWAPMetric {
+		return TWAPCalculator
+	}
+
+	return VWAPCalculator
+}
+
+// MultiCalculator computes other metrics besides the VWAP off the same
+// windows and trades.
+type MultiCalculator interface {
+	// SetCalculators selects the metrics of the product's results. The
+	// products' results are VWAP ones until set.
+	SetCalculators(productID string, calculators []Calculator) error
+}
+
+var _ MultiCalculator = (*ProductsVwap)(nil)
@@ -0,0 +1,259 @@
+package vwap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+// candlesQLen is the closed candles buffered to their consumer.
+const candlesQLen = 1024
+
+// Candler aggregates the products' trades into OHLCV candles closing on the
+// wall-clock boundaries of their intervals.
+type Candler interface {
+	// Candles sets up each product's candles of the intervals i.e. 1m and
+	// 1h. It is called before producing any trade.
+	Candles(intervals []time.Duration) error
+	// GetCandlesQ returns the queue of closed candles.
+	GetCandlesQ() <-chan *types.Candle
+}
+
+// candle is a product's open candle of an interval.
+type candle struct {
+	interval time.Duration
+	// start is the open candle's boundary, zero before the product's first
+	// trade
+	start  time.Time
+	open   *big.Float
+	high   *big.Float
+	low    *big.Float
+	close  *big.Float
+	volume *big.Float
+	pv     *big.Float
+	trades uint64
+}
+
+// productCandles are a product's open candles of each interval.
+type productCandles struct {
+	sync.Mutex
+	candles []*candle
+}
+
+func newProductCandles(intervals []time.Duration) *productCandles {
+	pc := &productCandles{
+		candles: make([]*candle, len(intervals)),
+	}
+	for i, interval := range intervals {
+		pc.candles[i] = &candle{
+			interval: interval,
+			open:     new(big.Float),
+			high:     new(big.Float),
+			low:      new(big.Float),
+			close:    new(big.Float),
+			volume:   new(big.Float),
+			pv:       new(big.Float),
+		}
+	}
+
+	return pc
+}
+
+// Candles sets up each product's candles of the intervals, including the
+// products added later at runtime. The candles close on the trade times and,
+// while Run runs, on the wall-clock boundaries of their intervals i.e. every
+// minute on the minute. Candles is called before producing any trade.
+func (v *ProductsVwap) Candles(intervals []time.Duration) error {
+	if len(intervals) == 0 {
+		return fmt.Errorf("no candle interval")
+	}
+	for i, interval := range intervals {
+		if interval <= 0 {
+			return fmt.Errorf("the candle interval %s must be positive", interval)
+		}
+		for _, other := range intervals[:i] {
+			if other == interval {
+				return fmt.Errorf("duplicate candle interval %s", interval)
+			}
+		}
+	}
+
+	v.candleIntervals = append([]time.Duration(nil), intervals...)
+	v.candlesQ = make(types.CandlesQ, candlesQLen)
+	v.vwapCache.Range(func(_, value interface{}) bool {
+		value.(*productWindows).candles = newProductCandles(v.candleIntervals)
+		return true
+	})
+
+	return nil
+}
+
+// GetCandlesQ returns the queue of closed candles, nil without candles.
+func (v *ProductsVwap) GetCandlesQ() <-chan *types.Candle {
+	return v.candlesQ
+}
+
+// produceCandles adds the trade to each of the product's open candles and
+// queues the candles it closes until ctx is cancelled.
+func (v *ProductsVwap) produceCandles(ctx context.Context, logger *zap.Logger, productID string, pc *productCandles, trade *types.TradeValue) {
+	var buf [4]*types.Candle
+
+	pc.Lock()
+	closed := buf[:0]
+	for _, c := range pc.candles {
+		closed = c.add(productID, trade, closed)
+	}
+	pc.Unlock()
+
+	for _, closedCandle := range closed {
+		logger.Debug("Candle closed", zap.Object(productID, closedCandle))
+		select {
+		case v.candlesQ <- closedCandle:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// add adds the trade to the open candle first closing it when the trade is
+// timed at or past the candle's end. A trade timed before the open candle's
+// start i.e. arriving past the timer's close adds to the open candle.
+func (c *candle) add(productID string, trade *types.TradeValue, closed []*types.Candle) []*types.Candle {
+	switch {
+	case c.start.IsZero():
+		c.start = trade.Time.Truncate(c.interval)
+	case !trade.Time.Before(c.start.Add(c.interval)):
+		closed = append(closed, c.candle(productID))
+		c.reset(trade.Time.Truncate(c.interval))
+	}
+
+	if c.trades == 0 || trade.Price.Cmp(c.high) > 0 {
+		setExact(c.high, trade.Price)
+	}
+	if c.trades == 0 || trade.Price.Cmp(c.low) < 0 {
+		setExact(c.low, trade.Price)
+	}
+	if c.trades == 0 {
+		setExact(c.open, trade.Price)
+	}
+	setExact(c.close, trade.Price)
+
+	pv := types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	c.pv.Add(c.pv, pv.Mul(trade.Price, trade.Size))
+	types.BigFloatMemPool.Put(pv)
+	c.volume.Add(c.volume, trade.Size)
+	c.trades++
+
+	return closed
+}
+
+// closeAt closes the open candle when its end is at or before now and opens
+// the next one flat at its close. It appends nothing before the product's
+// first trade or within the candle.
+func (c *candle) closeAt(productID string, now time.Time, closed []*types.Candle) []*types.Candle {
+	if c.start.IsZero() || now.Before(c.start.Add(c.interval)) {
+		return closed
+	}
+
+	closed = append(closed, c.candle(productID))
+	c.reset(now.Truncate(c.interval))
+
+	return closed
+}
+
+// reset opens the candle at start flat at the previous close.
+func (c *candle) reset(start time.Time) {
+	c.start = start
+	setExact(c.open, c.close)
+	setExact(c.high, c.close)
+	setExact(c.low, c.close)
+	c.volume.SetPrec(0)
+	c.pv.SetPrec(0)
+	c.trades = 0
+}
+
+// candle returns a copy of the open candle.
+func (c *candle) candle(productID string) *types.Candle {
+	closed := &types.Candle{
+		ProductID: productID,
+		Interval:  c.interval,
+		Start:     c.start,
+		Open:      new(big.Float).Set(c.open),
+		High:      new(big.Float).Set(c.high),
+		Low:       new(big.Float).Set(c.low),
+		Close:     new(big.Float).Set(c.close),
+		Volume:    new(big.Float).Set(c.volume),
+		Vwap:      new(big.Float),
+		Trades:    c.trades,
+	}
+	if c.volume.Sign() != 0 {
+		closed.Vwap.Quo(c.pv, c.volume)
+	}
+
+	return closed
+}
+
+// setExact sets z to x at x's precision.
+func setExact(z, x *big.Float) {
+	z.SetPrec(0).Set(x)
+}
+
+// CloseCandles closes the products' open candles whose end is at or before now
+// queuing them, so that the candles close on time without trades. A quiet
+// product's closed candle is flat at its previous close.
+func (v *ProductsVwap) CloseCandles(ctx context.Context, now time.Time) {
+	logger := log.FromContext(ctx)
+
+	var buf [4]*types.Candle
+	v.vwapCache.Range(func(key, value interface{}) bool {
+		productID, pc := key.(string), value.(*productWindows).candles
+		if pc == nil {
+			return true
+		}
+
+		pc.Lock()
+		closed := buf[:0]
+		for _, c := range pc.candles {
+			closed = c.closeAt(productID, now, closed)
+		}
+		pc.Unlock()
+
+		for _, closedCandle := range closed {
+			logger.Debug("Candle closed", zap.Object(productID, closedCandle))
+			select {
+			case v.candlesQ <- closedCandle:
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// runCandles closes the candles of the interval at each of its wall-clock
+// boundaries.
+func (v *ProductsVwap) runCandles(ctx context.Context, interval time.Duration) {
+	for {
+		next := time.Now().Truncate(interval).Add(interval)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			// the boundary rather than the timer's firing time closes the
+			// candles
+			v.CloseCandles(ctx, next)
+		}
+	}
+}
+
+var _ Candler = (*ProductsVwap)(nil)
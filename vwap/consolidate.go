@@ -0,0 +1,132 @@
+package vwap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+// Consolidator computes a canonical instrument's VWAP across the venue
+// products trading it i.e. Coinbase's BTC-USD and Binance's BTCUSDT as
+// BTC-USD.
+type Consolidator interface {
+	// Consolidate adds the weighted venue products' trades to the
+	// instrument's windows.
+	Consolidate(instrument string, weights map[string]*big.Float) error
+}
+
+// consolidation is a consolidated instrument a venue product's trades add to.
+type consolidation struct {
+	instrument string
+	pw         *productWindows
+	// weight scales the venue product's trade sizes
+	weight *big.Float
+}
+
+// Consolidate adds the trades of the venue products keyed in weights to the
+// instrument's windows, so that its VWAP spans the venues and its results
+// carry each venue's volume contribution. A venue product's weight scales its
+// trade sizes within the instrument's windows; a zero weight excludes the
+// venue. The instrument and the venue products are among the engine's
+// products each with its own windows updated by the same trades. Consolidate
+// is called once per instrument before producing any trade.
+func (v *ProductsVwap) Consolidate(instrument string, weights map[string]*big.Float) error {
+	pw, err := v.windows(instrument)
+	if err != nil {
+		return err
+	}
+	if pw.consolidated {
+		return fmt.Errorf("the instrument %s is already consolidated", instrument)
+	}
+	if _, ok := v.consolidations[instrument]; ok {
+		return fmt.Errorf("the venue product %s cannot be a consolidated instrument", instrument)
+	}
+
+	for productID, weight := range weights {
+		if productID == instrument {
+			return fmt.Errorf("the instrument %s cannot consolidate itself", instrument)
+		}
+		venue, err := v.windows(productID)
+		if err != nil {
+			return err
+		}
+		if venue.consolidated {
+			return fmt.Errorf("the instrument %s cannot be a venue product", productID)
+		}
+		if weight.Sign() < 0 {
+			return fmt.Errorf("negative %s weight %s", productID, weight)
+		}
+		if weight.IsInf() {
+			return fmt.Errorf("infinite %s weight", productID)
+		}
+	}
+
+	if v.consolidations == nil {
+		v.consolidations = make(map[string][]consolidation)
+	}
+	pw.consolidated = true
+	for productID, weight := range weights {
+		if weight.Sign() == 0 {
+			continue
+		}
+		v.consolidations[productID] = append(v.consolidations[productID], consolidation{
+			instrument: instrument,
+			pw:         pw,
+			weight:     weight,
+		})
+	}
+
+	return nil
+}
+
+// produceConsolidated adds the venue product's trade to the consolidated
+// instrument's windows with its size weighted. The trade's venue defaults to
+// its venue product.
+func (v *ProductsVwap) produceConsolidated(ctx context.Context, logger *zap.Logger, c consolidation, trade *types.TradeValue, now time.Time) error {
+	size := types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	defer types.BigFloatMemPool.Put(size)
+
+	consolidated := types.TradeValue{
+		ProductID: c.instrument,
+		Price:     trade.Price,
+		Size:      size.Mul(trade.Size, c.weight),
+		Sequence:  trade.Sequence,
+		Time:      trade.Time,
+		Venue:     trade.Venue,
+	}
+	if consolidated.Venue == "" {
+		consolidated.Venue = trade.ProductID
+	}
+
+	return v.produceWindows(ctx, logger, c.pw, &consolidated, now)
+}
+
+// venueShares returns the venues' volume contributions to tvol ordered by
+// venue.
+func venueShares(venues map[string]*venueVolume, tvol *big.Float) []types.VenueShare {
+	if len(venues) == 0 {
+		return nil
+	}
+
+	shares := make([]types.VenueShare, 0, len(venues))
+	for venue, vv := range venues {
+		share := types.VenueShare{
+			Venue:  venue,
+			Volume: new(big.Float).Set(vv.vol),
+		}
+		if tvol.Sign() != 0 {
+			share.Share, _ = new(big.Float).Quo(vv.vol, tvol).Float64()
+		}
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Venue < shares[j].Venue
+	})
+
+	return shares
+}
@@ -0,0 +1,127 @@
+package vwap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blewater/zh/types"
+)
+
+// Engine computes the products' moving window VWAP results off the ingested
+// trades.
+type Engine interface {
+	// ProduceTrade adds the trade to the product's window and queues the
+	// product's fresh VWAP result. The trade's price and size are recycled.
+	ProduceTrade(ctx context.Context, trade *types.TradeValue) error
+	// MarkSuspect flags the product's results until the window rolls past the
+	// data points pushed so far.
+	MarkSuspect(productID string) error
+	// Reset empties the product's window.
+	Reset(productID string) error
+	// AddProduct adds the product's windows at runtime.
+	AddProduct(productID string, windows []WindowSpec) error
+	// RemoveProduct drops the product's windows at runtime.
+	RemoveProduct(productID string) error
+	// GetResultsQ returns the queue of produced VWAP results.
+	GetResultsQ() <-chan *types.VWAPResult
+	// Run runs the engine's timers until ctx is cancelled.
+	Run(ctx context.Context)
+	Snapshotter
+}
+
+// EngineKind selects the arithmetic of the VWAP engine.
+type EngineKind uint8
+
+const (
+	// BigFloatEngine computes with big.Float values. See ProductsVwap.
+	BigFloatEngine EngineKind = iota
+	// FixedEngine computes with scaled integers. See FixedProductsVwap.
+	FixedEngine
+)
+
+func (k EngineKind) String() string {
+	switch k {
+	case BigFloatEngine:
+		return "bigfloat"
+	case FixedEngine:
+		return "fixed"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseEngineKind returns the engine kind matching its name.
+func ParseEngineKind(name string) (EngineKind, error) {
+	for _, k := range []EngineKind{BigFloatEngine, FixedEngine} {
+		if k.String() == name {
+			return k, nil
+		}
+	}
+
+	return BigFloatEngine, fmt.Errorf("unknown VWAP engine %q", name)
+}
+
+// NewEngine returns the kind of engine for the products computing the VWAP of
+// each of the windows off the same trades. The scale applies to the
+// fixed-point engine only which supports count windows only.
+func NewEngine(kind EngineKind, productIDs []string, windows []WindowSpec, scale FixedScale) (Engine, error) {
+	products := make(map[string][]WindowSpec, len(productIDs))
+	for _, p := range productIDs {
+		products[p] = windows
+	}
+
+	return NewPerProductEngine(kind, products, nil, scale)
+}
+
+// NewPerProductEngine returns the kind of engine computing the VWAP of each
+// product's own windows i.e. more trades for the busier products. The
+// fixed-point engine scales the products keyed in scales, including those
+// added at runtime, by their own scale and the others by scale.
+func NewPerProductEngine(kind EngineKind, products map[string][]WindowSpec, scales map[string]FixedScale, scale FixedScale) (Engine, error) {
+	for p, windows := range products {
+		if err := validateWindows(p, windows); err != nil {
+			return nil, err
+		}
+	}
+
+	if kind == FixedEngine {
+		productScales := make(map[string]FixedScale, len(products))
+		windowSizes := make(map[string][]uint16, len(products))
+		for p, windows := range products {
+			productScales[p] = scale
+			if productScale, ok := scales[p]; ok {
+				productScales[p] = productScale
+			}
+			for _, window := range windows {
+				if window.Kind != CountWindow {
+					return nil, fmt.Errorf("the %s engine supports count windows only", kind)
+				}
+				windowSizes[p] = append(windowSizes[p], window.Size)
+			}
+		}
+		fixed := NewFixedPerProduct(productScales, windowSizes)
+		fixed.scales, fixed.scale = scales, scale
+		return fixed, nil
+	}
+
+	return NewPerProduct(products), nil
+}
+
+// validateWindows checks the product's windows.
+func validateWindows(productID string, windows []WindowSpec) error {
+	if len(windows) == 0 {
+		return fmt.Errorf("no VWAP window for %s", productID)
+	}
+	for _, window := range windows {
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", productID, err)
+		}
+	}
+
+	return nil
+}
+
+var (
+	_ Engine = (*ProductsVwap)(nil)
+	_ Engine = (*FixedProductsVwap)(nil)
+)
@@ -0,0 +1,464 @@
+package vwap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+// maxFixedDecimals keeps 10^decimals within an int64.
+const maxFixedDecimals = 18
+
+// fixedScratchPrec is the precision of the big.Float to integer conversions.
+const fixedScratchPrec = 128
+
+var bigHalf = big.NewFloat(0.5)
+
+// FixedScale is the number of decimal digits a product's prices and sizes
+// are scaled by to integers i.e. 8 price decimals store 4606.8 as 460680000000.
+// Excess decimals are rounded half away from zero.
+type FixedScale struct {
+	PriceDecimals uint8
+	SizeDecimals  uint8
+}
+
+// fixedPoint is a window data point of scaled integers.
+type fixedPoint struct {
+	PV  uint128
+	Vol uint64
+}
+
+// fixedWindow is a product's moving window of scaled integers and its running
+// sums of the window's data points. It holds count windows only.
+type fixedWindow struct {
+	sync.Mutex
+	points  []fixedPoint
+	head    uint16
+	len     uint16
+	size    uint16
+	suspect uint16
+
+	TPV  uint128
+	TVol uint64
+
+	// trades counts the trades ever pushed
+	trades uint64
+	// updated is the time of the window's last push
+	updated time.Time
+
+	// conversion scratch values reused under the lock
+	priceMul  big.Float
+	sizeMul   big.Float
+	priceUnit big.Float
+	scaled    big.Float
+	rounded   big.Float
+	whole     big.Float
+	frac      big.Float
+	sum       big.Float
+	quo       big.Float
+}
+
+func newFixedWindow(size uint16, scale FixedScale) *fixedWindow {
+	w := &fixedWindow{
+		points: make([]fixedPoint, size),
+		size:   size,
+	}
+	w.priceMul.SetInt64(pow10(scale.PriceDecimals))
+	w.sizeMul.SetInt64(pow10(scale.SizeDecimals))
+	w.priceUnit.SetInt64(pow10(scale.PriceDecimals))
+	for _, f := range []*big.Float{&w.scaled, &w.rounded, &w.whole, &w.frac, &w.sum, &w.quo} {
+		f.SetPrec(fixedScratchPrec)
+	}
+
+	return w
+}
+
+func pow10(decimals uint8) int64 {
+	p := int64(1)
+	for i := uint8(0); i < decimals; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// toFixed scales x to an integer rounded half away from zero.
+func (w *fixedWindow) toFixed(x, mul *big.Float) (uint64, error) {
+	if x.Sign() < 0 {
+		return 0, fmt.Errorf("negative value %s", x.String())
+	}
+
+	// distinct operands spare the allocations of aliased big.Float results
+	w.scaled.Mul(x, mul)
+	w.rounded.Add(&w.scaled, bigHalf)
+	if w.rounded.IsInf() || w.rounded.MantExp(nil) > 64 {
+		return 0, fmt.Errorf("scaled value %s overflows 64 bits", x.String())
+	}
+	u, _ := w.rounded.Uint64()
+
+	return u, nil
+}
+
+// sums returns the running sums of the window with the data point pushed
+// without pushing it or the sums' overflow error.
+func (w *fixedWindow) sums(p fixedPoint) (uint128, uint64, error) {
+	tpv, tvol := w.TPV, w.TVol
+	if w.len == w.size {
+		dropped := w.points[w.head]
+		tpv = tpv.sub(dropped.PV)
+		tvol -= dropped.Vol
+	}
+
+	tpv, overflow := tpv.add(p.PV)
+	if overflow || tvol+p.Vol < tvol {
+		return tpv, tvol, fmt.Errorf("fixed-point window sums overflow")
+	}
+
+	return tpv, tvol + p.Vol, nil
+}
+
+// push adds the data point to the running sums dropping the oldest one when
+// the window is full.
+func (w *fixedWindow) push(p fixedPoint) error {
+	tpv, tvol, err := w.sums(p)
+	if err != nil {
+		return err
+	}
+
+	pos := (w.head + w.len) % w.size
+	dropping := w.len == w.size
+	if dropping {
+		pos = w.head
+	}
+
+	w.TPV, w.TVol = tpv, tvol
+	w.points[pos] = p
+	if dropping {
+		w.head = (w.head + 1) % w.size
+		if w.suspect > 0 {
+			w.suspect--
+		}
+	} else {
+		w.len++
+	}
+
+	return nil
+}
+
+// vwap returns TPV / TVol as a new big.Float.
+func (w *fixedWindow) vwap() (*big.Float, error) {
+	if w.TVol == 0 {
+		return new(big.Float), nil
+	}
+
+	// TPV is scaled by both the price and size scales, TVol by the size one,
+	// so the quotient is scaled by the price one.
+	q, r, ok := w.TPV.div64(w.TVol)
+	if !ok {
+		return nil, fmt.Errorf("fixed-point VWAP overflows 64 bits")
+	}
+	// the remainder's 64 binary fractional digits as r < TVol
+	frac, _ := bits.Div64(r, 0, w.TVol)
+
+	w.whole.SetUint64(q)
+	w.frac.SetUint64(frac)
+	w.frac.SetMantExp(&w.frac, -64)
+	w.sum.Add(&w.whole, &w.frac)
+	w.quo.Quo(&w.sum, &w.priceUnit)
+
+	return new(big.Float).Set(&w.quo), nil
+}
+
+func (w *fixedWindow) clear() {
+	w.head, w.len, w.suspect = 0, 0, 0
+	w.TPV, w.TVol = uint128{}, 0
+}
+
+// FixedProductsVwap is the fixed-point alternative to ProductsVwap. Prices and
+// sizes are scaled per product to 64-bit integers and the PV sums are kept in
+// 128 bits, so producing a result does not allocate big.Float values beyond
+// the result itself.
+type FixedProductsVwap struct {
+	windows  sync.Map
+	resultsQ types.ResultsQ
+	// scales are those of the products added at runtime, matching their IDs
+	// case insensitively as the config file's keys, scale that of the others
+	scales map[string]FixedScale
+	scale  FixedScale
+}
+
+// fixedProductWindows are a product's count windows sharing its trades.
+type fixedProductWindows struct {
+	// labels are the windows' results labels
+	labels  []string
+	windows []*fixedWindow
+}
+
+// NewFixed returns the fixed-point engine of each of the window sizes for the
+// products keyed in scales.
+func NewFixed(scales map[string]FixedScale, windowSizes ...uint16) *FixedProductsVwap {
+	products := make(map[string][]uint16, len(scales))
+	for p := range scales {
+		products[p] = windowSizes
+	}
+
+	return NewFixedPerProduct(scales, products)
+}
+
+// NewFixedPerProduct returns the fixed-point engine of each product's own
+// window sizes for the products keyed in scales.
+func NewFixedPerProduct(scales map[string]FixedScale, windowSizes map[string][]uint16) *FixedProductsVwap {
+	prodVwap := &FixedProductsVwap{}
+
+	resultsCapacity := 0
+	for p, scale := range scales {
+		productCapacity := 0
+		for _, size := range windowSizes[p] {
+			productCapacity += int(size)
+		}
+		if productCapacity > resultsCapacity {
+			resultsCapacity = productCapacity
+		}
+		prodVwap.windows.Store(p, newFixedProductWindows(windowSizes[p], scale))
+	}
+	prodVwap.resultsQ = make(types.ResultsQ, resultsCapacity)
+
+	return prodVwap
+}
+
+func newFixedProductWindows(windowSizes []uint16, scale FixedScale) *fixedProductWindows {
+	if scale.PriceDecimals > maxFixedDecimals {
+		scale.PriceDecimals = maxFixedDecimals
+	}
+	if scale.SizeDecimals > maxFixedDecimals {
+		scale.SizeDecimals = maxFixedDecimals
+	}
+
+	pw := &fixedProductWindows{
+		labels:  make([]string, len(windowSizes)),
+		windows: make([]*fixedWindow, len(windowSizes)),
+	}
+	for i, size := range windowSizes {
+		pw.labels[i] = CountWindowSpec(size).String()
+		pw.windows[i] = newFixedWindow(size, scale)
+	}
+
+	return pw
+}
+
+// ProduceVwap is the fixed-point counterpart of ProductsVwap.ProduceVwap.
+func (v *FixedProductsVwap) ProduceVwap(ctx context.Context, productID string, price, volume *big.Float) error {
+	return v.ProduceTrade(ctx, &types.TradeValue{
+		ProductID: productID,
+		Price:     price,
+		Size:      volume,
+		Time:      time.Now(),
+	})
+}
+
+// ProduceTrade is the fixed-point counterpart of ProductsVwap.ProduceTrade.
+func (v *FixedProductsVwap) ProduceTrade(ctx context.Context, trade *types.TradeValue) error {
+	logger := log.FromContext(ctx)
+	// nolint:errcheck
+	defer logger.Sync()
+
+	productID, price, volume := trade.ProductID, trade.Price, trade.Size
+	defer recyclePriceVol(price, volume)
+
+	pw, err := v.productWindows(productID)
+	if err != nil {
+		return err
+	}
+	if len(pw.windows) == 0 {
+		return fmt.Errorf("no VWAP window for %s", productID)
+	}
+
+	// the product's windows share the scale, so the first one's scratch values
+	// convert the trade for all
+	first := pw.windows[0]
+	first.Lock()
+	p, err := first.toFixed(price, &first.priceMul)
+	if err != nil {
+		first.Unlock()
+		return fmt.Errorf("price of %s: %w", productID, err)
+	}
+	s, err := first.toFixed(volume, &first.sizeMul)
+	first.Unlock()
+	if err != nil {
+		return fmt.Errorf("volume of %s: %w", productID, err)
+	}
+	point := fixedPoint{PV: mul64(p, s), Vol: s}
+	now := time.Now()
+
+	// on the stack for the usual few windows
+	var buf [8]*types.VWAPResult
+	results, err := pw.produce(productID, point, now, buf[:0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", productID, err)
+	}
+
+	for _, result := range results {
+		// checked first as the result escapes to the heap within the loop
+		if ce := logger.Check(zap.DebugLevel, "New result produced"); ce != nil {
+			ce.Write(zap.Object(productID, result))
+		}
+
+		v.resultsQ <- result
+	}
+
+	return nil
+}
+
+// produce pushes the data point to all of the product's windows or, when any
+// window's sums would overflow, to none of them, so that the windows agree. It
+// appends the windows' results to results.
+func (pw *fixedProductWindows) produce(productID string, point fixedPoint, now time.Time, results []*types.VWAPResult) ([]*types.VWAPResult, error) {
+	//---------------- Start a product's VWAP computation using shared memory containers
+	for _, window := range pw.windows {
+		window.Lock()
+	}
+	defer func() {
+		for _, window := range pw.windows {
+			window.Unlock()
+		}
+	}()
+
+	for _, window := range pw.windows {
+		if _, _, err := window.sums(point); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, window := range pw.windows {
+		// checked above
+		_ = window.push(point)
+		window.trades++
+		window.updated = now
+
+		vwap, err := window.vwap()
+		if err != nil {
+			for _, result := range results {
+				types.VWAPResultMemPool.Put(result)
+			}
+			return nil, err
+		}
+
+		result := pooledResult(productID, pw.labels[i], window.suspect > 0)
+		result.Vwap = vwap
+
+		results = append(results, result)
+	}
+	//---------------- End of product's VWAP computation using shared memory containers
+
+	return results, nil
+}
+
+// Run returns when ctx is cancelled as count windows need no timers.
+func (v *FixedProductsVwap) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (v *FixedProductsVwap) MarkSuspect(productID string) error {
+	pw, err := v.productWindows(productID)
+	if err != nil {
+		return err
+	}
+
+	for _, window := range pw.windows {
+		window.Lock()
+		window.suspect = window.len
+		window.Unlock()
+	}
+
+	return nil
+}
+
+func (v *FixedProductsVwap) Reset(productID string) error {
+	pw, err := v.productWindows(productID)
+	if err != nil {
+		return err
+	}
+
+	for _, window := range pw.windows {
+		window.Lock()
+		window.clear()
+		window.Unlock()
+	}
+
+	return nil
+}
+
+// AddProduct is the fixed-point counterpart of ProductsVwap.AddProduct. The
+// product takes on the engine's scale and its windows are count windows.
+func (v *FixedProductsVwap) AddProduct(productID string, windows []WindowSpec) error {
+	if err := validateWindows(productID, windows); err != nil {
+		return err
+	}
+	windowSizes := make([]uint16, len(windows))
+	for i, window := range windows {
+		if window.Kind != CountWindow {
+			return fmt.Errorf("the %s engine supports count windows only", FixedEngine)
+		}
+		windowSizes[i] = window.Size
+	}
+
+	scale := v.scaleOf(productID)
+	if _, loaded := v.windows.LoadOrStore(productID, newFixedProductWindows(windowSizes, scale)); loaded {
+		return fmt.Errorf("product ID %s already in the VWAP map of product ids", productID)
+	}
+
+	return nil
+}
+
+// scaleOf returns the scale of the product added at runtime.
+func (v *FixedProductsVwap) scaleOf(productID string) FixedScale {
+	if scale, ok := v.scales[productID]; ok {
+		return scale
+	}
+	for p, scale := range v.scales {
+		if strings.EqualFold(p, productID) {
+			return scale
+		}
+	}
+
+	return v.scale
+}
+
+// RemoveProduct is the fixed-point counterpart of ProductsVwap.RemoveProduct.
+func (v *FixedProductsVwap) RemoveProduct(productID string) error {
+	if _, loaded := v.windows.LoadAndDelete(productID); !loaded {
+		return fmt.Errorf(
+			"product ID %s not in the VWAP map of product ids", productID,
+		)
+	}
+
+	return nil
+}
+
+func (v *FixedProductsVwap) productWindows(productID string) (*fixedProductWindows, error) {
+	i, ok := v.windows.Load(productID)
+	if !ok {
+		return nil, fmt.Errorf(
+			"product ID %s not in the VWAP map of product ids", productID,
+		)
+	}
+	pw, ok := i.(*fixedProductWindows)
+	if !ok {
+		return nil, fmt.Errorf(
+			"failed to access the VWAP windows for %s", productID,
+		)
+	}
+
+	return pw, nil
+}
+
+func (v *FixedProductsVwap) GetResultsQ() <-chan *types.VWAPResult {
+	return v.resultsQ
+}
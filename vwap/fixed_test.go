@@ -0,0 +1,335 @@
+package vwap_test
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+// fixedTolerance is the relative difference tolerated between the fixed-point
+// and the big.Float engines' results for trades of at most 8 decimals.
+const fixedTolerance = 1e-12
+
+type decimalTrade struct {
+	productID string
+	price     string
+	size      string
+}
+
+// randomWalkTrades returns trades of 8 decimals prices and sizes whose prices
+// walk randomly around their starting price.
+func randomWalkTrades(seed int64, count int, startPrices map[string]float64) []decimalTrade {
+	rnd := rand.New(rand.NewSource(seed))
+
+	products := make([]string, 0, len(startPrices))
+	prices := make(map[string]float64, len(startPrices))
+	for p, price := range startPrices {
+		products = append(products, p)
+		prices[p] = price
+	}
+
+	trades := make([]decimalTrade, count)
+	for i := range trades {
+		p := products[rnd.Intn(len(products))]
+		prices[p] *= 1 + (rnd.Float64()-0.5)/100
+		trades[i] = decimalTrade{
+			productID: p,
+			price:     fmt.Sprintf("%.8f", prices[p]),
+			size:      fmt.Sprintf("%.8f", rnd.ExpFloat64()),
+		}
+	}
+
+	return trades
+}
+
+func parseDecimal(tb testing.TB, decimal string) *big.Float {
+	f, _, err := new(big.Float).SetPrec(128).Parse(decimal, 10)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return f
+}
+
+func TestFixedProductsVwap_AgreesWithBigFloat(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	startPrices := map[string]float64{"BTC-USD": 60749.99, "ETH-USD": 4302.99, "ETH-BTC": 0.07083}
+	products := []string{"BTC-USD", "ETH-USD", "ETH-BTC"}
+
+	for _, windowSizes := range [][]uint16{{1}, {2}, {200}, {50, 200, 1000}} {
+		windows := make([]vwap.WindowSpec, len(windowSizes))
+		for i, size := range windowSizes {
+			windows[i] = vwap.CountWindowSpec(size)
+		}
+		bigEngine, err := vwap.NewEngine(vwap.BigFloatEngine, products, windows, vwap.FixedScale{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixedEngine, err := vwap.NewEngine(vwap.FixedEngine, products, windows, vwap.FixedScale{PriceDecimals: 8, SizeDecimals: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, trade := range randomWalkTrades(int64(windowSizes[0]), 2000, startPrices) {
+			for _, engine := range []vwap.Engine{bigEngine, fixedEngine} {
+				if err := engine.ProduceTrade(ctx, &types.TradeValue{
+					ProductID: trade.productID,
+					Price:     parseDecimal(t, trade.price),
+					Size:      parseDecimal(t, trade.size),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// a result per window in the windows order
+			for _, window := range windows {
+				want, got := <-bigEngine.GetResultsQ(), <-fixedEngine.GetResultsQ()
+				if got.ProductID != want.ProductID {
+					t.Fatalf("window %s trade %d product = %s, want %s", window, i, got.ProductID, want.ProductID)
+				}
+				if got.Window != window.String() || want.Window != window.String() {
+					t.Fatalf("trade %d windows = %s, %s, want %s", i, got.Window, want.Window, window)
+				}
+
+				diff := new(big.Float).Sub(got.Vwap, want.Vwap)
+				relDiff, _ := diff.Quo(diff.Abs(diff), want.Vwap).Float64()
+				if relDiff > fixedTolerance {
+					t.Fatalf(
+						"window %s trade %d %s VWAP = %s, want %s within %g",
+						window, i, trade.productID, got.Vwap.Text('g', 20), want.Vwap.Text('g', 20), fixedTolerance,
+					)
+				}
+			}
+		}
+	}
+}
+
+func TestNewEngine_FixedCountWindowsOnly(t *testing.T) {
+	if _, err := vwap.NewEngine(vwap.FixedEngine, []string{"BTC-USD"}, []vwap.WindowSpec{vwap.TimeWindowSpec(time.Minute)}, vwap.FixedScale{}); err == nil {
+		t.Errorf("NewEngine() expected an error for a fixed-point time window")
+	}
+	if _, err := vwap.NewEngine(vwap.BigFloatEngine, []string{"BTC-USD"}, []vwap.WindowSpec{vwap.CountWindowSpec(0)}, vwap.FixedScale{}); err == nil {
+		t.Errorf("NewEngine() expected an error for an empty window")
+	}
+}
+
+func TestEngine_AddRemoveProduct(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+
+	for _, kind := range []vwap.EngineKind{vwap.BigFloatEngine, vwap.FixedEngine} {
+		t.Run(kind.String(), func(t *testing.T) {
+			engine, err := vwap.NewEngine(kind, []string{"BTC-USD"}, []vwap.WindowSpec{vwap.CountWindowSpec(2)}, vwap.FixedScale{PriceDecimals: 8, SizeDecimals: 8})
+			if err != nil {
+				t.Fatal(err)
+			}
+			produce := func(productID string, price float64) error {
+				return engine.ProduceTrade(ctx, &types.TradeValue{
+					ProductID: productID,
+					Price:     big.NewFloat(price),
+					Size:      big.NewFloat(1),
+					Time:      time.Now(),
+				})
+			}
+
+			if err := produce("ETH-USD", 1); err == nil {
+				t.Fatal("producing an unknown product expected an error")
+			}
+			if err := engine.AddProduct("ETH-USD", []vwap.WindowSpec{vwap.CountWindowSpec(3)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.AddProduct("ETH-USD", []vwap.WindowSpec{vwap.CountWindowSpec(3)}); err == nil {
+				t.Error("adding a product twice expected an error")
+			}
+			if err := engine.AddProduct("ETH-BTC", nil); err == nil {
+				t.Error("adding a product without windows expected an error")
+			}
+
+			for _, price := range []float64{2, 4} {
+				if err := produce("ETH-USD", price); err != nil {
+					t.Fatal(err)
+				}
+			}
+			<-engine.GetResultsQ()
+			res := <-engine.GetResultsQ()
+			if res.ProductID != "ETH-USD" || res.Window != "3" || res.Vwap.Text('f', 0) != "3" {
+				t.Errorf("result = %s %s %s, want ETH-USD 3 3", res.ProductID, res.Window, res.Vwap.Text('f', 0))
+			}
+			if _, err := engine.Snapshot("ETH-USD"); err != nil {
+				t.Error(err)
+			}
+
+			if err := engine.RemoveProduct("ETH-USD"); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.RemoveProduct("ETH-USD"); err == nil {
+				t.Error("removing a product twice expected an error")
+			}
+			if err := produce("ETH-USD", 1); err == nil {
+				t.Error("producing a removed product expected an error")
+			}
+			if snapshots := engine.Snapshots(); len(snapshots) != 1 || snapshots[0].ProductID != "BTC-USD" {
+				t.Errorf("Snapshots() = %v, want BTC-USD only", snapshots)
+			}
+		})
+	}
+}
+
+func TestFixedProductsVwap_Errors(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	fixedEngine := vwap.NewFixed(map[string]vwap.FixedScale{"BTC-USD": {PriceDecimals: 8, SizeDecimals: 8}}, 2)
+
+	tests := []struct {
+		name      string
+		productID string
+		// the last trade is expected to fail
+		trades [][2]string
+	}{
+		{name: "Unknown product", productID: "ETH-USD", trades: [][2]string{{"1", "1"}}},
+		{name: "Negative price", productID: "BTC-USD", trades: [][2]string{{"-1", "1"}}},
+		{name: "Scaled price overflow", productID: "BTC-USD", trades: [][2]string{{"1e12", "1"}}},
+		{name: "Volume sum overflow", productID: "BTC-USD", trades: [][2]string{{"1", "1e11"}, {"1", "1e11"}}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				for i, trade := range tt.trades {
+					err := fixedEngine.ProduceVwap(ctx, tt.productID, parseDecimal(t, trade[0]), parseDecimal(t, trade[1]))
+					if last := i == len(tt.trades)-1; last != (err != nil) {
+						t.Errorf("ProduceVwap() trade %d err = %v", i, err)
+					}
+					if err == nil {
+						<-fixedEngine.GetResultsQ()
+					}
+				}
+				if err := fixedEngine.Reset("BTC-USD"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		)
+	}
+}
+
+func TestNewPerProductEngine_FixedScales(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	windows := []vwap.WindowSpec{vwap.CountWindowSpec(1)}
+	scales := map[string]vwap.FixedScale{
+		"BTC-USD": {PriceDecimals: 2, SizeDecimals: 8},
+		"ETH-USD": {PriceDecimals: 1, SizeDecimals: 8},
+	}
+	engine, err := vwap.NewPerProductEngine(
+		vwap.FixedEngine, map[string][]vwap.WindowSpec{"BTC-USD": windows, "ETH-BTC": windows},
+		scales, vwap.FixedScale{PriceDecimals: 8, SizeDecimals: 8},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the configured product added at runtime takes on its own scale
+	if err := engine.AddProduct("ETH-USD", windows); err != nil {
+		t.Fatal(err)
+	}
+
+	for productID, want := range map[string]string{"BTC-USD": "0.12", "ETH-USD": "0.1", "ETH-BTC": "0.12345679"} {
+		trade := &types.TradeValue{ProductID: productID, Price: parseDecimal(t, "0.123456789"), Size: parseDecimal(t, "1")}
+		if err := engine.ProduceTrade(ctx, trade); err != nil {
+			t.Fatal(err)
+		}
+		if got := (<-engine.GetResultsQ()).Vwap.Text('f', -1); got != want {
+			t.Errorf("%s VWAP = %s, want %s", productID, got, want)
+		}
+	}
+}
+
+func TestFixedProductsVwap_OverflowLeavesWindows(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	// the 1 trade window drops the first trade's volume while the 3 trades
+	// window overflows
+	fixedEngine := vwap.NewFixed(map[string]vwap.FixedScale{"BTC-USD": {PriceDecimals: 8, SizeDecimals: 8}}, 1, 3)
+
+	if err := fixedEngine.ProduceVwap(ctx, "BTC-USD", parseDecimal(t, "1"), parseDecimal(t, "1e11")); err != nil {
+		t.Fatal(err)
+	}
+	<-fixedEngine.GetResultsQ()
+	<-fixedEngine.GetResultsQ()
+
+	if err := fixedEngine.ProduceVwap(ctx, "BTC-USD", parseDecimal(t, "2"), parseDecimal(t, "1e11")); err == nil {
+		t.Fatal("ProduceVwap() expected the volume sum overflow")
+	}
+	if queued := len(fixedEngine.GetResultsQ()); queued != 0 {
+		t.Errorf("%d results queued of the overflowing trade, want none", queued)
+	}
+	snapshot, err := fixedEngine.Snapshot("BTC-USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, window := range snapshot.Windows {
+		if window.Trades != 1 || window.Vwap.Text('f', 0) != "1" {
+			t.Errorf("window %s trades, vwap = %d, %s, want 1, 1", window.Window, window.Trades, window.Vwap.Text('f', 0))
+		}
+	}
+}
+
+func TestFixedProductsVwap_RecycledResult(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	fixedEngine := vwap.NewFixed(map[string]vwap.FixedScale{"BTC-USD": {PriceDecimals: 8, SizeDecimals: 8}}, 2)
+
+	// a banded consolidated result of the big.Float engine recycled
+	types.VWAPResultMemPool.Put(&types.VWAPResult{
+		Metric:       types.TWAPMetric,
+		SessionClose: true,
+		Venues:       []types.VenueShare{{Venue: "coinbase"}},
+		StdDev:       big.NewFloat(1),
+		Bands:        []types.Band{{Multiplier: 2}},
+	})
+
+	if err := fixedEngine.ProduceVwap(ctx, "BTC-USD", parseDecimal(t, "2"), parseDecimal(t, "1")); err != nil {
+		t.Fatal(err)
+	}
+	res := <-fixedEngine.GetResultsQ()
+	if res.Metric != types.VWAPMetric || res.SessionClose || res.Venues != nil || res.StdDev != nil || res.Bands != nil {
+		t.Errorf("result = %+v, want a plain VWAP result", res)
+	}
+}
+
+func benchmarkEngine(b *testing.B, kind vwap.EngineKind) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	startPrices := map[string]float64{"BTC-USD": 60749.99, "ETH-USD": 4302.99, "ETH-BTC": 0.07083}
+	engine, err := vwap.NewEngine(kind, []string{"BTC-USD", "ETH-USD", "ETH-BTC"}, []vwap.WindowSpec{vwap.CountWindowSpec(200)}, vwap.FixedScale{PriceDecimals: 8, SizeDecimals: 8})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	trades := randomWalkTrades(1, 1000, startPrices)
+	prices := make([]*big.Float, len(trades))
+	sizes := make([]*big.Float, len(trades))
+	for i, trade := range trades {
+		prices[i], sizes[i] = parseDecimal(b, trade.price), parseDecimal(b, trade.size)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := n % len(trades)
+		// the engines recycle the price and volume values
+		price := types.BigFloatMemPool.Get().(*big.Float).Set(prices[i])
+		size := types.BigFloatMemPool.Get().(*big.Float).Set(sizes[i])
+		if err := engine.ProduceTrade(ctx, &types.TradeValue{ProductID: trades[i].productID, Price: price, Size: size}); err != nil {
+			b.Fatal(err)
+		}
+		types.VWAPResultMemPool.Put(<-engine.GetResultsQ())
+	}
+}
+
+func BenchmarkProduceVwap_BigFloat(b *testing.B) {
+	benchmarkEngine(b, vwap.BigFloatEngine)
+}
+
+func BenchmarkProduceVwap_Fixed(b *testing.B) {
+	benchmarkEngine(b, vwap.FixedEngine)
+}
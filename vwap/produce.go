@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 
 	"github.com/blewater/zh/log"
 	"github.com/blewater/zh/types"
@@ -18,6 +19,24 @@ type vwapCache struct {
 	TVol *big.Float
 	PV   *big.Float
 	Vol  *big.Float
+	// TPV2 sums the window's volume-weighted squared prices up to the data
+	// point beside TPV and TVol, and PV2 is the data point's v·p²
+	TPV2 *big.Float
+	PV2  *big.Float
+	// Price is the trade price
+	Price *big.Float
+	// TPT sums the window's prices weighted by the time each stood until the
+	// next trade up to the data point's trade and TDur sums those times
+	TPT  *big.Float
+	TDur time.Duration
+	// PT is the price weighted by the time it stood until the next data
+	// point's trade and Dur is that time, both zero for the last data point
+	PT  *big.Float
+	Dur time.Duration
+	// Time is the trade time of the data point
+	Time time.Time
+	// Venue is the venue of a consolidated instrument's data point
+	Venue string
 }
 
 // Memory pool of vwapCache objects
@@ -32,63 +51,211 @@ var vwapCacheMemPool = sync.Pool{
 
 // ProductsVwap is the container for calculating the queued results.
 type ProductsVwap struct {
-	windowSize uint16
-	vwapCache  sync.Map
-	resultsQ   types.ResultsQ
+	// specs are the distinct windows across the products run by the timers
+	specs     []WindowSpec
+	vwapCache sync.Map
+	resultsQ  types.ResultsQ
+	// consolidations are the consolidated instruments each venue product's
+	// trades add to. Set up by Consolidate before producing any trade.
+	consolidations map[string][]consolidation
+	// candleIntervals are the intervals of each product's candles closed into
+	// candlesQ. Set up by Candles before producing any trade.
+	candleIntervals []time.Duration
+	candlesQ        types.CandlesQ
+}
+
+// productWindows are a product's windows sharing its ingested trades.
+type productWindows struct {
+	specs []WindowSpec
+	// labels are the specs' results labels
+	labels []string
+	queues []*WindowQueue
+	// consolidated is true for an instrument consolidating venue products
+	consolidated bool
+	// calculators are the metrics of the windows' results read under each
+	// window's lock
+	calculators []Calculator
+	// bands are the σ multipliers of the VWAP results' bands read under each
+	// window's lock, none until set
+	bands []float64
+	// candles are the product's open candles, nil without candles
+	candles *productCandles
+}
+
+func newProductWindows(windows []WindowSpec) *productWindows {
+	pw := &productWindows{
+		specs:  windows,
+		labels: make([]string, len(windows)),
+		queues: make([]*WindowQueue, len(windows)),
+		// the VWAP until set otherwise
+		calculators: []Calculator{VWAPCalculator},
+	}
+	for i, window := range windows {
+		pw.labels[i] = window.String()
+		// Allocate capacity upfront
+		pw.queues[i] = NewWindowQueue(window.capacity())
+	}
+
+	return pw
 }
 
 var bigZero = big.NewFloat(0)
 
+// New returns the products' VWAP of their last windowSize trades.
 func New(productIDs []string, windowSize uint16) *ProductsVwap {
+	return NewWindowed(productIDs, CountWindowSpec(windowSize))
+}
+
+// NewWindowed returns the products' VWAP of each of the windows i.e. the last
+// 50, 200 and 1000 trades computed off the same trades.
+func NewWindowed(productIDs []string, windows ...WindowSpec) *ProductsVwap {
+	products := make(map[string][]WindowSpec, len(productIDs))
+	for _, p := range productIDs {
+		products[p] = windows
+	}
+
+	return NewPerProduct(products)
+}
+
+// NewPerProduct returns the VWAP of each product's own windows i.e. the last
+// 1000 trades of BTC-USD and the last 50 of ETH-BTC.
+func NewPerProduct(products map[string][]WindowSpec) *ProductsVwap {
 	prodVwap := &ProductsVwap{
-		vwapCache:  sync.Map{},
-		resultsQ:   make(types.ResultsQ, windowSize),
-		windowSize: windowSize,
+		vwapCache: sync.Map{},
 	}
-	for _, p := range productIDs {
-		// Allocate capacity upfront
-		prodVwap.vwapCache.Store(p, NewWindowQueue(windowSize))
+
+	resultsCapacity := 0
+	distinct := make(map[string]bool)
+	for p, windows := range products {
+		prodVwap.vwapCache.Store(p, newProductWindows(windows))
+
+		for _, window := range windows {
+			if int(window.resultsCapacity()) > resultsCapacity {
+				resultsCapacity = int(window.resultsCapacity())
+			}
+			if label := window.String(); !distinct[label] {
+				distinct[label] = true
+				prodVwap.specs = append(prodVwap.specs, window)
+			}
+		}
 	}
+	prodVwap.resultsQ = make(types.ResultsQ, resultsCapacity*len(prodVwap.specs))
 
 	return prodVwap
 }
 
 // ProduceVwap is the service VWAP computing func employing big.Float data types.
-// See CalcMovWinWithF64() for the exact same algorithm in simpler terms.
+// See CalcMovWinWithF64() for the exact same algorithm in simpler terms. The
+// trade is timed at its arrival.
 func (v *ProductsVwap) ProduceVwap(ctx context.Context, productID string, price, volume *big.Float) error {
+	return v.ProduceTrade(ctx, &types.TradeValue{
+		ProductID: productID,
+		Price:     price,
+		Size:      volume,
+		Time:      time.Now(),
+	})
+}
+
+// ProduceTrade adds the trade to each of the product's windows and queues the
+// product's fresh VWAP result of each window. A time window first evicts the
+// data points older than its horizon as of the trade's time. An anchored
+// window first closes its session when the trade is timed at or past the
+// session's boundary.
+func (v *ProductsVwap) ProduceTrade(ctx context.Context, trade *types.TradeValue) error {
 	logger := log.FromContext(ctx)
 	// nolint:errcheck
 	defer logger.Sync()
 
-	newDataPoints := memPoolGet()
+	// the windows share the trade
+	defer recyclePriceVol(trade.Price, trade.Size)
+
+	pw, err := v.windows(trade.ProductID)
+	if err != nil {
+		return err
+	}
 
-	newDataPoints.PV.Mul(price, volume)
-	newDataPoints.Vol.Set(volume)
+	now := time.Now()
+	if err := v.produceWindows(ctx, logger, pw, trade, now); err != nil {
+		return err
+	}
 
-	recyclePriceVol(price, volume)
+	// the same trade updates the consolidated instruments' windows
+	for _, c := range v.consolidations[trade.ProductID] {
+		if err := v.produceConsolidated(ctx, logger, c, trade, now); err != nil {
+			return err
+		}
+	}
 
-	newDataPoints.TPV.Set(newDataPoints.PV)
-	newDataPoints.TVol.Set(newDataPoints.Vol)
+	return nil
+}
 
-	i, ok := v.vwapCache.Load(productID)
-	if !ok {
-		return fmt.Errorf(
-			"product ID %s not in the VWAP map of product ids", productID,
-		)
+// produceWindows adds the trade to each of the product's windows and queues
+// their fresh results of each of the product's calculators.
+func (v *ProductsVwap) produceWindows(ctx context.Context, logger *zap.Logger, pw *productWindows, trade *types.TradeValue, now time.Time) error {
+	var buf [4]*types.VWAPResult
+	for i := range pw.queues {
+		results, err := v.produceWindow(pw, i, trade, now, buf[:0])
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if result.SessionClose {
+				logger.Debug("Session closed", zap.Object(trade.ProductID, result))
+			} else if ce := logger.Check(zap.DebugLevel, "New result produced"); ce != nil {
+				// checked first as the result escapes to the heap within the loop
+				ce.Write(zap.Object(trade.ProductID, result))
+			}
+
+			v.resultsQ <- result
+		}
 	}
-	window, ok := i.(*WindowQueue)
-	if !ok {
-		return fmt.Errorf(
-			"failed to access the VWAP window slice for %s", productID,
-		)
+
+	if pw.candles != nil {
+		v.produceCandles(ctx, logger, trade.ProductID, pw.candles, trade)
 	}
 
+	return nil
+}
+
+// produceWindow adds the trade to the product's i-th window as of now and
+// appends to results the closed session's results if any followed by the
+// window's fresh ones.
+func (v *ProductsVwap) produceWindow(pw *productWindows, i int, trade *types.TradeValue, now time.Time, results []*types.VWAPResult) ([]*types.VWAPResult, error) {
+	spec, window, productID := pw.specs[i], pw.queues[i], trade.ProductID
+
+	newDataPoints := memPoolGet()
+
+	newDataPoints.Price.Set(trade.Price)
+	newDataPoints.PV.Mul(trade.Price, trade.Size)
+	newDataPoints.Vol.Set(trade.Size)
+	newDataPoints.PV2.Mul(newDataPoints.PV, trade.Price)
+	newDataPoints.Time = trade.Time
+	if pw.consolidated {
+		newDataPoints.Venue = trade.Venue
+	}
+
+	newDataPoints.TPV.Set(newDataPoints.PV)
+	newDataPoints.TVol.Set(newDataPoints.Vol)
+	newDataPoints.TPV2.Set(newDataPoints.PV2)
+
 	//---------------- Start a product's VWAP computation using shared memory containers
 	window.Lock()
+	switch spec.Kind {
+	case TimeWindow:
+		evictExpired(window, trade.Time.Add(-spec.Duration))
+	case AnchoredWindow:
+		results = closeSession(productID, pw, i, trade.Time, results)
+		if window.sessionEnd.IsZero() {
+			window.sessionEnd = spec.Anchor.Next(trade.Time)
+		}
+	}
+
 	if window.len > 0 {
 		prevDataPoints, ok := window.PeekLast()
 		if !ok {
-			return fmt.Errorf(
+			window.Unlock()
+			return results, fmt.Errorf(
 				"could not access cached data set %d, %s", window.len,
 				productID,
 			)
@@ -97,43 +264,490 @@ func (v *ProductsVwap) ProduceVwap(ctx context.Context, productID string, price,
 		// Add previous sums
 		newDataPoints.TPV.Add(newDataPoints.PV, prevDataPoints.TPV)
 		newDataPoints.TVol.Add(newDataPoints.Vol, prevDataPoints.TVol)
+		newDataPoints.TPV2.Add(newDataPoints.PV2, prevDataPoints.TPV2)
+		// the previous price stood until this trade
+		stand(prevDataPoints, trade.Time)
+		newDataPoints.TPT.Add(prevDataPoints.TPT, prevDataPoints.PT)
+		newDataPoints.TDur = prevDataPoints.TDur + prevDataPoints.Dur
 	}
 
-	// drop window data point to make room for the new
+	// drop window data point to make room for the new unless a time window's
+	// queue may grow
 	var droppedDataPoints *vwapCache
-	if window.len == v.windowSize {
+	switch {
+	case spec.Kind == AnchoredWindow && window.len > 0:
+		// the new data point carries over the session's sums. A gap taints the
+		// rest of the session, so the dropped point does not lessen suspect.
+		suspect := window.suspect
+		droppedDataPoints, _ = window.Pop()
+		window.suspect = suspect
+	case window.len == window.size && (spec.Kind == CountWindow || !window.Grow()):
+		var ok bool
 		droppedDataPoints, ok = window.Pop()
 		if !ok {
-			return fmt.Errorf(
+			window.Unlock()
+			return results, fmt.Errorf(
 				"popping cached VMAP dataPoint failed for %s", productID,
 			)
 		}
 
-		newDataPoints.TPV.Sub(newDataPoints.TPV, droppedDataPoints.PV)
-		newDataPoints.TVol.Sub(newDataPoints.TVol, droppedDataPoints.Vol)
+		subDataPoints(newDataPoints, droppedDataPoints)
+		window.subVenue(droppedDataPoints)
 	}
 
 	window.Push(newDataPoints)
+	window.addVenue(newDataPoints)
+	window.trades++
+	window.sequence = trade.Sequence
+	window.updated = now
+	window.received = now
+
+	// the quotients are taken within the lock as the eviction timer updates
+	// the last data point's sums
+	results = windowResults(productID, pw, i, newDataPoints, results)
 	window.Unlock()
 	//---------------- End of product's VWAP computation using shared memory containers
 
 	recycleToPool(droppedDataPoints)
 
+	return results, nil
+}
+
+// stand sets the data point's price weighted by the time it stood until the
+// next trade at t. A trade timed before the data point's does not rewind the
+// time.
+func stand(dataPoints *vwapCache, t time.Time) {
+	dataPoints.Dur = t.Sub(dataPoints.Time)
+	if dataPoints.Dur < 0 {
+		dataPoints.Dur = 0
+	}
+
+	dur := types.BigFloatMemPool.Get().(*big.Float).SetPrec(0).SetInt64(int64(dataPoints.Dur))
+	dataPoints.PT.Mul(dataPoints.Price, dur)
+	types.BigFloatMemPool.Put(dur)
+}
+
+// subDataPoints subtracts the dropped data point from the last data point's
+// window sums.
+func subDataPoints(last, droppedDataPoints *vwapCache) {
+	last.TPV.Sub(last.TPV, droppedDataPoints.PV)
+	last.TVol.Sub(last.TVol, droppedDataPoints.Vol)
+	last.TPV2.Sub(last.TPV2, droppedDataPoints.PV2)
+	last.TPT.Sub(last.TPT, droppedDataPoints.PT)
+	last.TDur -= droppedDataPoints.Dur
+}
+
+// pooledResult returns a recycled VWAP result of the labelled window cleared
+// of its previous use.
+func pooledResult(productID, window string, suspect bool) *types.VWAPResult {
 	result := types.VWAPResultMemPool.Get().(*types.VWAPResult)
 
 	result.ProductID = productID
-	result.Vwap = big.NewFloat(0)
-	if newDataPoints.TVol.Cmp(bigZero) != 0 {
-		result.Vwap.Quo(newDataPoints.TPV, newDataPoints.TVol)
+	result.Metric = types.VWAPMetric
+	result.Window = window
+	result.Suspect = suspect
+	result.SessionClose = false
+	result.Venues = nil
+	result.StdDev = nil
+	result.Bands = nil
+	result.Vwap = nil
+
+	return result
+}
+
+// newResult returns the VWAP result of the labelled window's sums.
+func newResult(productID, window string, tpv, tvol *big.Float, suspect bool) *types.VWAPResult {
+	result := pooledResult(productID, window, suspect)
+	// a zero precision quotient takes on the precision of the sums
+	result.Vwap = new(big.Float)
+	if tvol.Cmp(bigZero) != 0 {
+		result.Vwap.Quo(tpv, tvol)
+	}
+
+	return result
+}
+
+// newTWAPResult returns the TWAP result of the labelled window's last data
+// point: the prices weighted by the time each stood until the next trade. It
+// is the last price while the window's trades share their time and zero for
+// an empty window.
+func newTWAPResult(productID, window string, last *vwapCache, suspect bool) *types.VWAPResult {
+	result := newResult(productID, window, bigZero, bigZero, suspect)
+	result.Metric = types.TWAPMetric
+
+	switch {
+	case last == nil:
+	case last.TDur > 0:
+		tdur := types.BigFloatMemPool.Get().(*big.Float).SetPrec(0).SetInt64(int64(last.TDur))
+		result.Vwap.Quo(last.TPT, tdur)
+		types.BigFloatMemPool.Put(tdur)
+	default:
+		result.Vwap.Set(last.Price)
+	}
+
+	return result
+}
+
+// windowResults appends the results of the product's i-th window for each of
+// the product's calculators off the window's last data point or nil when
+// empty. The VWAP results carry the venues' volume contributions of a
+// consolidated instrument and the product's σ bands. The window must be
+// locked.
+func windowResults(productID string, pw *productWindows, i int, last *vwapCache, results []*types.VWAPResult) []*types.VWAPResult {
+	window := pw.queues[i]
+	for _, calculator := range pw.calculators {
+		if calculator == TWAPCalculator {
+			results = append(results, newTWAPResult(productID, pw.labels[i], last, window.suspect > 0))
+			continue
+		}
+
+		tpv, tvol := bigZero, bigZero
+		if last != nil {
+			tpv, tvol = last.TPV, last.TVol
+		}
+		result := newResult(productID, pw.labels[i], tpv, tvol, window.suspect > 0)
+		if pw.consolidated {
+			result.Venues = venueShares(window.venues, tvol)
+		}
+		if len(pw.bands) > 0 {
+			result.StdDev, result.Bands = newBands(last, result.Vwap, pw.bands)
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// lastDataPoints returns the window's last data point or nil when empty.
+func lastDataPoints(window *WindowQueue) *vwapCache {
+	if last, ok := window.PeekLast(); ok && window.len > 0 {
+		return last
+	}
+
+	return nil
+}
+
+// evictExpired drops the window's data points older than cutoff subtracting
+// them from the last data point's window sums. It returns whether any data
+// point was dropped.
+func evictExpired(window *WindowQueue, cutoff time.Time) bool {
+	evicted := false
+	for {
+		first, ok := window.PeekFirst()
+		if !ok || !first.Time.Before(cutoff) {
+			return evicted
+		}
+
+		droppedDataPoints, _ := window.Pop()
+		if last := lastDataPoints(window); last != nil {
+			subDataPoints(last, droppedDataPoints)
+		}
+		window.subVenue(droppedDataPoints)
+		recycleToPool(droppedDataPoints)
+		evicted = true
+	}
+}
+
+// EvictExpired drops the data points older than the time windows' horizon as
+// of now and queues fresh results for each product's window that changed, so
+// that a quiet product's VWAP decays. An emptied window's results are zero.
+//
+// The trades evict each other by their exchange times, which may lag or lead
+// the local clock. The horizon stays on the same time base: the last trade's
+// time advanced by the wall time elapsed since it was received.
+func (v *ProductsVwap) EvictExpired(ctx context.Context, now time.Time) {
+	v.forEachWindow(ctx, TimeWindow, func(productID string, pw *productWindows, i int, results []*types.VWAPResult) []*types.VWAPResult {
+		window := pw.queues[i]
+		last := lastDataPoints(window)
+		if last == nil {
+			return results
+		}
+		elapsed := now.Sub(window.received)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		if !evictExpired(window, last.Time.Add(elapsed-pw.specs[i].Duration)) {
+			return results
+		}
+		window.updated = now
+
+		return windowResults(productID, pw, i, lastDataPoints(window), results)
+	}, "Expired data points evicted")
+}
+
+// closeSession resets the product's i-th anchored window once at or past its
+// session boundary and appends to results the session close results carrying
+// the session's final values. It appends none within the session or before
+// the window's first trade.
+func closeSession(productID string, pw *productWindows, i int, at time.Time, results []*types.VWAPResult) []*types.VWAPResult {
+	window := pw.queues[i]
+	if window.sessionEnd.IsZero() || at.Before(window.sessionEnd) {
+		return results
+	}
+
+	closed := len(results)
+	results = windowResults(productID, pw, i, lastDataPoints(window), results)
+	for _, result := range results[closed:] {
+		result.SessionClose = true
+	}
+
+	for _, droppedDataPoints := range window.Clear() {
+		recycleToPool(droppedDataPoints)
+	}
+	window.sessionEnd = pw.specs[i].Anchor.Next(at)
+	window.updated = at
+
+	return results
+}
+
+// CloseSessions resets the anchored windows whose session boundary is at or
+// before now queuing first each one's session close results, so that a quiet
+// product's session closes on time.
+func (v *ProductsVwap) CloseSessions(ctx context.Context, now time.Time) {
+	v.forEachWindow(ctx, AnchoredWindow, func(productID string, pw *productWindows, i int, results []*types.VWAPResult) []*types.VWAPResult {
+		return closeSession(productID, pw, i, now, results)
+	}, "Session closed")
+}
+
+// forEachWindow applies fn under the lock of each product's window of kind
+// and queues the results it appends.
+func (v *ProductsVwap) forEachWindow(ctx context.Context, kind WindowKind, fn func(productID string, pw *productWindows, i int, results []*types.VWAPResult) []*types.VWAPResult, msg string) {
+	logger := log.FromContext(ctx)
+
+	var buf [4]*types.VWAPResult
+	v.vwapCache.Range(func(key, value interface{}) bool {
+		productID, pw := key.(string), value.(*productWindows)
+
+		for i, window := range pw.queues {
+			if pw.specs[i].Kind != kind {
+				continue
+			}
+
+			window.Lock()
+			results := fn(productID, pw, i, buf[:0])
+			window.Unlock()
+
+			for _, result := range results {
+				logger.Debug(msg, zap.Object(productID, result))
+				select {
+				case v.resultsQ <- result:
+				case <-ctx.Done():
+					return false
+				}
+			}
+		}
+
+		return true
+	})
+}
+
+// Run evicts the time windows' expired data points on a timer, and closes the
+// anchored windows' sessions and the candles at their boundaries, until ctx
+// is cancelled.
+func (v *ProductsVwap) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	var evictionInterval time.Duration
+	for _, spec := range v.specs {
+		switch spec.Kind {
+		case TimeWindow:
+			if evictionInterval == 0 || spec.evictionInterval() < evictionInterval {
+				evictionInterval = spec.evictionInterval()
+			}
+		case AnchoredWindow:
+			wg.Add(1)
+			go func(anchor Schedule) {
+				defer wg.Done()
+				v.runSessions(ctx, anchor)
+			}(spec.Anchor)
+		}
+	}
+	for _, interval := range v.candleIntervals {
+		wg.Add(1)
+		go func(interval time.Duration) {
+			defer wg.Done()
+			v.runCandles(ctx, interval)
+		}(interval)
 	}
 
-	logger.Debug("New result produced", zap.Object(productID, result))
+	if evictionInterval > 0 {
+		v.runEvictions(ctx, evictionInterval)
+	} else {
+		<-ctx.Done()
+	}
+	wg.Wait()
+}
 
-	v.resultsQ <- result
+// runEvictions evicts the time windows' expired data points every interval.
+func (v *ProductsVwap) runEvictions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			v.EvictExpired(ctx, now)
+		}
+	}
+}
+
+// runSessions closes the anchored windows' sessions at each boundary of the
+// anchor schedule.
+func (v *ProductsVwap) runSessions(ctx context.Context, anchor Schedule) {
+	for {
+		next := anchor.Next(time.Now())
+		if next.IsZero() {
+			<-ctx.Done()
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			// the boundary rather than the timer's firing time closes the
+			// sessions
+			v.CloseSessions(ctx, next)
+		}
+	}
+}
+
+// MarkSuspect flags the product's upcoming VWAP results as suspect until each
+// moving window no longer spans the data points pushed so far. An anchored
+// window's results remain suspect until the session closes.
+func (v *ProductsVwap) MarkSuspect(productID string) error {
+	pw, err := v.windows(productID)
+	if err != nil {
+		return err
+	}
+
+	for i, window := range pw.queues {
+		window.Lock()
+		window.suspect = window.len
+		if pw.specs[i].Kind == AnchoredWindow {
+			window.suspect = 1
+		}
+		window.Unlock()
+	}
 
 	return nil
 }
 
+// SetCalculators selects the metrics of the product's results i.e. both its
+// VWAP and TWAP ones off the same windows.
+func (v *ProductsVwap) SetCalculators(productID string, calculators []Calculator) error {
+	pw, err := v.windows(productID)
+	if err != nil {
+		return err
+	}
+	for _, calculator := range calculators {
+		if calculator > TWAPCalculator {
+			return fmt.Errorf("%s: unknown calculator %d", productID, calculator)
+		}
+	}
+
+	// the windows' results read the calculators under their locks
+	for _, window := range pw.queues {
+		window.Lock()
+	}
+	pw.calculators = append([]Calculator(nil), calculators...)
+	for _, window := range pw.queues {
+		window.Unlock()
+	}
+
+	return nil
+}
+
+// Reset empties the product's windows so that the next VWAP results start
+// over from the next data point.
+func (v *ProductsVwap) Reset(productID string) error {
+	pw, err := v.windows(productID)
+	if err != nil {
+		return err
+	}
+
+	for _, window := range pw.queues {
+		window.Lock()
+		dropped := window.Clear()
+		window.Unlock()
+
+		for _, droppedDataPoints := range dropped {
+			recycleToPool(droppedDataPoints)
+		}
+	}
+
+	return nil
+}
+
+// AddProduct adds the product's windows at runtime, so that its trades are
+// produced from then on. The engine's timers serve the time and anchored
+// windows of the kinds configured at construction.
+func (v *ProductsVwap) AddProduct(productID string, windows []WindowSpec) error {
+	if err := validateWindows(productID, windows); err != nil {
+		return err
+	}
+
+	pw := newProductWindows(windows)
+	if len(v.candleIntervals) > 0 {
+		pw.candles = newProductCandles(v.candleIntervals)
+	}
+	if _, loaded := v.vwapCache.LoadOrStore(productID, pw); loaded {
+		return fmt.Errorf("product ID %s already in the VWAP map of product ids", productID)
+	}
+
+	return nil
+}
+
+// RemoveProduct drops the product's windows recycling their data points. The
+// product's trades still in flight then fail as not in the VWAP map. A
+// consolidated instrument or its venue products are not removed.
+func (v *ProductsVwap) RemoveProduct(productID string) error {
+	pw, err := v.windows(productID)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.consolidations[productID]; ok || pw.consolidated {
+		return fmt.Errorf("the consolidated product %s cannot be removed", productID)
+	}
+	if _, loaded := v.vwapCache.LoadAndDelete(productID); !loaded {
+		return fmt.Errorf(
+			"product ID %s not in the VWAP map of product ids", productID,
+		)
+	}
+
+	for _, window := range pw.queues {
+		window.Lock()
+		dropped := window.Clear()
+		window.Unlock()
+
+		for _, droppedDataPoints := range dropped {
+			recycleToPool(droppedDataPoints)
+		}
+	}
+
+	return nil
+}
+
+func (v *ProductsVwap) windows(productID string) (*productWindows, error) {
+	i, ok := v.vwapCache.Load(productID)
+	if !ok {
+		return nil, fmt.Errorf(
+			"product ID %s not in the VWAP map of product ids", productID,
+		)
+	}
+	pw, ok := i.(*productWindows)
+	if !ok {
+		return nil, fmt.Errorf(
+			"failed to access the VWAP window slice for %s", productID,
+		)
+	}
+
+	return pw, nil
+}
+
 func recyclePriceVol(price, volume *big.Float) {
 	types.BigFloatMemPool.Put(price)
 	types.BigFloatMemPool.Put(volume)
@@ -142,10 +756,19 @@ func recyclePriceVol(price, volume *big.Float) {
 func memPoolGet() *vwapCache {
 	newDataPoints := vwapCacheMemPool.Get().(*vwapCache)
 
-	newDataPoints.TPV = types.BigFloatMemPool.Get().(*big.Float)
-	newDataPoints.TVol = types.BigFloatMemPool.Get().(*big.Float)
-	newDataPoints.PV = types.BigFloatMemPool.Get().(*big.Float)
-	newDataPoints.Vol = types.BigFloatMemPool.Get().(*big.Float)
+	// zero the precision of the recycled values so that they take on the
+	// precision of the ingested prices and volumes
+	newDataPoints.TPV = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.TVol = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.PV = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.Vol = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.TPV2 = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.PV2 = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.Price = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.TPT = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.PT = types.BigFloatMemPool.Get().(*big.Float).SetPrec(0)
+	newDataPoints.TDur, newDataPoints.Dur = 0, 0
+	newDataPoints.Venue = ""
 	return newDataPoints
 }
 
@@ -155,6 +778,11 @@ func recycleToPool(droppedDataPoints *vwapCache) {
 		types.BigFloatMemPool.Put(droppedDataPoints.TVol)
 		types.BigFloatMemPool.Put(droppedDataPoints.PV)
 		types.BigFloatMemPool.Put(droppedDataPoints.Vol)
+		types.BigFloatMemPool.Put(droppedDataPoints.TPV2)
+		types.BigFloatMemPool.Put(droppedDataPoints.PV2)
+		types.BigFloatMemPool.Put(droppedDataPoints.Price)
+		types.BigFloatMemPool.Put(droppedDataPoints.TPT)
+		types.BigFloatMemPool.Put(droppedDataPoints.PT)
 		vwapCacheMemPool.Put(droppedDataPoints)
 	}
 }
@@ -2,8 +2,11 @@ package vwap_test
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/blewater/zh/log"
 	"github.com/blewater/zh/types"
@@ -588,6 +591,652 @@ func (suite *VWAPTestSuite) TestVMAPResults() {
 	}
 }
 
+func (suite *VWAPTestSuite) TestSequenceGapPolicies() {
+	productsVWAP := vwap.New([]string{"Prod"}, 2)
+	produce := func(price, volume float64) *types.VWAPResult {
+		suite.Require().NoError(productsVWAP.ProduceVwap(
+			suite.ctx, "Prod", big.NewFloat(price), big.NewFloat(volume),
+		))
+		return <-productsVWAP.GetResultsQ()
+	}
+
+	suite.Require().False(produce(1, 1).Suspect)
+
+	// suspect until the window of two rolls past the gap
+	suite.Require().NoError(productsVWAP.MarkSuspect("Prod"))
+	suite.Require().True(produce(3, 1).Suspect)
+	suite.Require().False(produce(3, 1).Suspect)
+
+	// reset starts the window over
+	suite.Require().NoError(productsVWAP.Reset("Prod"))
+	res := produce(5, 1)
+	suite.Require().Equal("5", res.Vwap.String())
+	suite.Require().False(res.Suspect)
+	suite.Require().Equal("6", produce(7, 1).Vwap.String())
+
+	suite.Require().Error(productsVWAP.Reset("Unknown"))
+	suite.Require().Error(productsVWAP.MarkSuspect("Unknown"))
+}
+
+func (suite *VWAPTestSuite) TestDecimalPrecision() {
+	productsVWAP := vwap.New([]string{"ETH-USD"}, 2)
+	parse := func(decimal string) *big.Float {
+		f, _, err := new(big.Float).SetPrec(128).Parse(decimal, 10)
+		suite.Require().NoError(err)
+		return f
+	}
+
+	suite.Require().NoError(productsVWAP.ProduceVwap(
+		suite.ctx, "ETH-USD", parse("4606.8"), parse("0.00269988"),
+	))
+	res := <-productsVWAP.GetResultsQ()
+	suite.Require().Equal(uint(128), res.Vwap.Prec())
+	suite.Require().Equal("4606.8", res.Vwap.Text('g', 30))
+
+	// (4606.8*0.00269988 + 4606.9*0.00130012) / 0.004
+	suite.Require().NoError(productsVWAP.ProduceVwap(
+		suite.ctx, "ETH-USD", parse("4606.9"), parse("0.00130012"),
+	))
+	res = <-productsVWAP.GetResultsQ()
+	suite.Require().Equal("4606.83250300000000000000000000", res.Vwap.Text('f', 26))
+}
+
+func (suite *VWAPTestSuite) TestTimeWindow() {
+	productsVWAP := vwap.NewWindowed([]string{"Prod"}, vwap.TimeWindowSpec(5*time.Minute))
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(price, volume float64, at time.Duration) string {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: "Prod",
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(volume),
+			Time:      t0.Add(at),
+		}))
+		return (<-productsVWAP.GetResultsQ()).Vwap.String()
+	}
+
+	suite.Require().Equal("2", produce(2, 1, 0))
+	suite.Require().Equal("3", produce(4, 1, time.Minute))
+	suite.Require().Equal("4", produce(6, 1, 2*time.Minute))
+	// the first trade expired as of the new trade
+	suite.Require().Equal("5", produce(5, 1, 5*time.Minute+time.Second))
+
+	// a quiet product's VWAP decays on the timer as of the last trade's time
+	// plus the wall time since its arrival
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(time.Minute))
+	suite.Require().Equal("5.5", (<-productsVWAP.GetResultsQ()).Vwap.String())
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(2*time.Minute))
+	suite.Require().Equal("5", (<-productsVWAP.GetResultsQ()).Vwap.String())
+
+	// nothing expired, nothing emitted
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(2*time.Minute+time.Second))
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(6*time.Minute))
+	suite.Require().Equal("0", (<-productsVWAP.GetResultsQ()).Vwap.String())
+
+	// starts over once emptied
+	suite.Require().Equal("7", produce(7, 2, 11*time.Minute))
+}
+
+func (suite *VWAPTestSuite) TestTimeWindowEvictionClock() {
+	productsVWAP := vwap.NewWindowed([]string{"Prod"}, vwap.TimeWindowSpec(5*time.Minute))
+	// the exchange's trade times lag the local clock by an hour
+	suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+		ProductID: "Prod",
+		Price:     big.NewFloat(2),
+		Size:      big.NewFloat(1),
+		Time:      time.Now().Add(-time.Hour),
+	}))
+	suite.Require().Equal("2", (<-productsVWAP.GetResultsQ()).Vwap.String())
+
+	// the timer does not evict the fresh trade by the local clock
+	productsVWAP.EvictExpired(suite.ctx, time.Now())
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+
+	// but once its horizon elapsed since its arrival
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(5*time.Minute+time.Second))
+	suite.Require().Equal("0", (<-productsVWAP.GetResultsQ()).Vwap.String())
+}
+
+func (suite *VWAPTestSuite) TestTimeWindowGrows() {
+	productsVWAP := vwap.NewWindowed([]string{"Prod"}, vwap.TimeWindowSpec(time.Hour))
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+
+	// beyond the initial queue capacity all trades remain within the horizon
+	var last *types.VWAPResult
+	for i := 1; i <= 1000; i++ {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: "Prod",
+			Price:     big.NewFloat(float64(i)),
+			Size:      big.NewFloat(1),
+			Time:      t0.Add(time.Duration(i) * time.Second),
+		}))
+		last = <-productsVWAP.GetResultsQ()
+	}
+	suite.Require().Equal("500.5", last.Vwap.String())
+}
+
+func (suite *VWAPTestSuite) TestAnchoredWindow() {
+	midnight, err := vwap.ParseSchedule("midnight")
+	suite.Require().NoError(err)
+	productsVWAP := vwap.NewWindowed([]string{"Prod"}, vwap.AnchoredWindowSpec(midnight))
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(price, volume float64, at time.Duration) {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: "Prod",
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(volume),
+			Time:      t0.Add(at),
+		}))
+	}
+	next := func() *types.VWAPResult {
+		return <-productsVWAP.GetResultsQ()
+	}
+
+	// accumulates all the session's trades
+	for i := 1; i <= 300; i++ {
+		produce(float64(i), 1, time.Duration(i)*time.Second)
+		suite.Require().False(next().SessionClose)
+	}
+	produce(1000, 100, time.Hour)
+	suite.Require().Equal("362.875", next().Vwap.String())
+
+	// a gap taints the rest of the session
+	suite.Require().NoError(productsVWAP.MarkSuspect("Prod"))
+	produce(1000, 100, 2*time.Hour)
+	suite.Require().True(next().Suspect)
+	produce(1000, 100, 2*time.Hour+time.Minute)
+	suite.Require().True(next().Suspect)
+
+	// the first trade past midnight closes the session first
+	produce(7, 1, 3*time.Hour)
+	closed := next()
+	suite.Require().True(closed.SessionClose)
+	suite.Require().True(closed.Suspect)
+	suite.Require().Equal("575.25", closed.Vwap.String())
+	opened := next()
+	suite.Require().False(opened.SessionClose)
+	suite.Require().False(opened.Suspect)
+	suite.Require().Equal("7", opened.Vwap.String())
+
+	// a quiet product's session closes on the timer
+	productsVWAP.CloseSessions(suite.ctx, time.Date(2021, 11, 11, 23, 59, 59, 0, time.UTC))
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+	productsVWAP.CloseSessions(suite.ctx, time.Date(2021, 11, 12, 0, 0, 0, 0, time.UTC))
+	closed = next()
+	suite.Require().True(closed.SessionClose)
+	suite.Require().Equal("7", closed.Vwap.String())
+
+	// an empty session closes at zero
+	productsVWAP.CloseSessions(suite.ctx, time.Date(2021, 11, 13, 0, 0, 0, 0, time.UTC))
+	closed = next()
+	suite.Require().True(closed.SessionClose)
+	suite.Require().Equal("0", closed.Vwap.String())
+}
+
+func (suite *VWAPTestSuite) TestTWAP() {
+	midnight, err := vwap.ParseSchedule("midnight")
+	suite.Require().NoError(err)
+	productsVWAP := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"Count":    {vwap.CountWindowSpec(3)},
+		"Time":     {vwap.TimeWindowSpec(5 * time.Second)},
+		"Anchored": {vwap.AnchoredWindowSpec(midnight)},
+	})
+	suite.Require().NoError(productsVWAP.SetCalculators("Count", []vwap.Calculator{vwap.VWAPCalculator, vwap.TWAPCalculator}))
+	suite.Require().NoError(productsVWAP.SetCalculators("Time", []vwap.Calculator{vwap.TWAPCalculator}))
+	suite.Require().NoError(productsVWAP.SetCalculators("Anchored", []vwap.Calculator{vwap.TWAPCalculator}))
+	suite.Require().Error(productsVWAP.SetCalculators("Unknown", []vwap.Calculator{vwap.TWAPCalculator}))
+	suite.Require().Error(productsVWAP.SetCalculators("Count", []vwap.Calculator{vwap.Calculator(9)}))
+
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(productID string, price, volume float64, at time.Duration) {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: productID,
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(volume),
+			Time:      t0.Add(at),
+		}))
+	}
+	next := func(metric types.Metric) string {
+		res := <-productsVWAP.GetResultsQ()
+		suite.Require().Equal(metric, res.Metric)
+		return res.Vwap.Text('f', 4)
+	}
+
+	// each price is weighted by how long it stood until the next trade
+	produce("Count", 10, 1, 0)
+	suite.Require().Equal("10.0000", next(types.VWAPMetric))
+	suite.Require().Equal("10.0000", next(types.TWAPMetric))
+	produce("Count", 20, 3, time.Second)
+	suite.Require().Equal("17.5000", next(types.VWAPMetric))
+	suite.Require().Equal("10.0000", next(types.TWAPMetric))
+	produce("Count", 30, 1, 3*time.Second)
+	suite.Require().Equal("20.0000", next(types.VWAPMetric))
+	// (10*1s + 20*2s) / 3s
+	suite.Require().Equal("16.6667", next(types.TWAPMetric))
+	// the first price's time rolls out of the window with its trade
+	produce("Count", 40, 1, 4*time.Second)
+	suite.Require().Equal("26.0000", next(types.VWAPMetric))
+	suite.Require().Equal("23.3333", next(types.TWAPMetric))
+	// a late trade does not rewind the time
+	produce("Count", 50, 1, 2*time.Second)
+	suite.Require().Equal("40.0000", next(types.VWAPMetric))
+	suite.Require().Equal("30.0000", next(types.TWAPMetric))
+
+	produce("Time", 2, 1, 0)
+	suite.Require().Equal("2.0000", next(types.TWAPMetric))
+	produce("Time", 4, 1, 2*time.Second)
+	suite.Require().Equal("2.0000", next(types.TWAPMetric))
+	produce("Time", 6, 1, 3*time.Second)
+	suite.Require().Equal("2.6667", next(types.TWAPMetric))
+	// the evicted prices' times are subtracted
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(3*time.Second))
+	suite.Require().Equal("4.0000", next(types.TWAPMetric))
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(4500*time.Millisecond))
+	suite.Require().Equal("6.0000", next(types.TWAPMetric))
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(6*time.Second))
+	suite.Require().Equal("0.0000", next(types.TWAPMetric))
+
+	produce("Anchored", 10, 5, 0)
+	suite.Require().Equal("10.0000", next(types.TWAPMetric))
+	produce("Anchored", 20, 1, time.Hour)
+	suite.Require().Equal("10.0000", next(types.TWAPMetric))
+	produce("Anchored", 30, 1, 2*time.Hour)
+	suite.Require().Equal("15.0000", next(types.TWAPMetric))
+	// the session's TWAP closes at midnight
+	produce("Anchored", 7, 1, 3*time.Hour)
+	closed := <-productsVWAP.GetResultsQ()
+	suite.Require().True(closed.SessionClose)
+	suite.Require().Equal(types.TWAPMetric, closed.Metric)
+	suite.Require().Equal("15", closed.Vwap.String())
+	suite.Require().Equal("7.0000", next(types.TWAPMetric))
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+}
+
+func (suite *VWAPTestSuite) TestMultipleWindows() {
+	productsVWAP := vwap.NewWindowed(
+		[]string{"Prod"},
+		vwap.CountWindowSpec(1), vwap.CountWindowSpec(3), vwap.TimeWindowSpec(time.Minute),
+	)
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(price float64, at time.Duration) []string {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: "Prod",
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(1),
+			Time:      t0.Add(at),
+		}))
+
+		// a result per window in the windows order
+		var results []string
+		for _, window := range []string{"1", "3", "1m0s"} {
+			result := <-productsVWAP.GetResultsQ()
+			suite.Require().Equal(window, result.Window)
+			results = append(results, result.Vwap.String())
+		}
+		return results
+	}
+
+	suite.Require().Equal([]string{"2", "2", "2"}, produce(2, 0))
+	suite.Require().Equal([]string{"4", "3", "3"}, produce(4, 10*time.Second))
+	suite.Require().Equal([]string{"6", "4", "4"}, produce(6, 20*time.Second))
+	suite.Require().Equal([]string{"8", "6", "6"}, produce(8, 61*time.Second))
+
+	// the gap policies apply to all the product's windows
+	suite.Require().NoError(productsVWAP.MarkSuspect("Prod"))
+	suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+		ProductID: "Prod",
+		Price:     big.NewFloat(1),
+		Size:      big.NewFloat(1),
+		Time:      t0.Add(62 * time.Second),
+	}))
+	suite.Require().False((<-productsVWAP.GetResultsQ()).Suspect)
+	suite.Require().True((<-productsVWAP.GetResultsQ()).Suspect)
+	suite.Require().True((<-productsVWAP.GetResultsQ()).Suspect)
+
+	suite.Require().NoError(productsVWAP.Reset("Prod"))
+	suite.Require().Equal([]string{"5", "5", "5"}, produce(5, 2*time.Minute))
+}
+
+func (suite *VWAPTestSuite) TestPerProductWindows() {
+	productsVWAP := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"BTC-USD": {vwap.CountWindowSpec(3)},
+		"ETH-BTC": {vwap.CountWindowSpec(1), vwap.CountWindowSpec(2)},
+	})
+	produce := func(productID string, price float64) {
+		suite.Require().NoError(productsVWAP.ProduceVwap(suite.ctx, productID, big.NewFloat(price), big.NewFloat(1)))
+	}
+	next := func() []string {
+		result := <-productsVWAP.GetResultsQ()
+		return []string{result.ProductID, result.Window, result.Vwap.String()}
+	}
+
+	for _, price := range []float64{1, 2, 3} {
+		produce("BTC-USD", price)
+		next()
+	}
+	produce("BTC-USD", 4)
+	suite.Require().Equal([]string{"BTC-USD", "3", "3"}, next())
+
+	produce("ETH-BTC", 2)
+	suite.Require().Equal([]string{"ETH-BTC", "1", "2"}, next())
+	suite.Require().Equal([]string{"ETH-BTC", "2", "2"}, next())
+	produce("ETH-BTC", 4)
+	suite.Require().Equal([]string{"ETH-BTC", "1", "4"}, next())
+	suite.Require().Equal([]string{"ETH-BTC", "2", "3"}, next())
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+}
+
+func (suite *VWAPTestSuite) TestConsolidated() {
+	productsVWAP := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"coinbase:BTC-USD": {vwap.CountWindowSpec(1)},
+		"binance:BTC-USDT": {vwap.CountWindowSpec(1)},
+		"kraken:XBT-USD":   {vwap.CountWindowSpec(1)},
+		"BTC-USD":          {vwap.CountWindowSpec(3), vwap.TimeWindowSpec(time.Minute)},
+	})
+	suite.Require().NoError(productsVWAP.Consolidate("BTC-USD", map[string]*big.Float{
+		"coinbase:BTC-USD": big.NewFloat(1),
+		"binance:BTC-USDT": big.NewFloat(0.5),
+		// excluded
+		"kraken:XBT-USD": big.NewFloat(0),
+	}))
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(productID, venue string, price, volume float64, at time.Duration) {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: productID,
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(volume),
+			Time:      t0.Add(at),
+			Venue:     venue,
+		}))
+	}
+	// next returns the result's product, window, VWAP and venue volumes
+	next := func() []string {
+		result := <-productsVWAP.GetResultsQ()
+		got := []string{result.ProductID, result.Window, result.Vwap.String()}
+		for _, share := range result.Venues {
+			got = append(got, share.Venue+"="+share.Volume.String())
+		}
+		return got
+	}
+
+	// the venue's own window precedes the consolidated windows
+	produce("coinbase:BTC-USD", "coinbase", 100, 1, 0)
+	suite.Require().Equal([]string{"coinbase:BTC-USD", "1", "100"}, next())
+	suite.Require().Equal([]string{"BTC-USD", "3", "100", "coinbase=1"}, next())
+	suite.Require().Equal([]string{"BTC-USD", "1m0s", "100", "coinbase=1"}, next())
+
+	// the weight halves binance's volume
+	produce("binance:BTC-USDT", "binance", 200, 2, 10*time.Second)
+	suite.Require().Equal([]string{"binance:BTC-USDT", "1", "200"}, next())
+	suite.Require().Equal([]string{"BTC-USD", "3", "150", "binance=1", "coinbase=1"}, next())
+	suite.Require().Equal([]string{"BTC-USD", "1m0s", "150", "binance=1", "coinbase=1"}, next())
+
+	// the excluded venue's VWAP is computed on its own
+	produce("kraken:XBT-USD", "kraken", 1000, 1, 20*time.Second)
+	suite.Require().Equal([]string{"kraken:XBT-USD", "1", "1000"}, next())
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+
+	produce("coinbase:BTC-USD", "coinbase", 100, 1, 30*time.Second)
+	next()
+	next()
+	next()
+	// the count window dropped coinbase's first trade
+	produce("coinbase:BTC-USD", "coinbase", 100, 1, 40*time.Second)
+	next()
+	count := <-productsVWAP.GetResultsQ()
+	suite.Require().Equal("133.3333333", count.Vwap.Text('f', 7))
+	suite.Require().Len(count.Venues, 2)
+	suite.Require().Equal("binance", count.Venues[0].Venue)
+	suite.Require().InDelta(1.0/3, count.Venues[0].Share, 1e-9)
+	suite.Require().Equal("coinbase", count.Venues[1].Venue)
+	suite.Require().InDelta(2.0/3, count.Venues[1].Share, 1e-9)
+	suite.Require().Equal([]string{"BTC-USD", "1m0s", "125", "binance=1", "coinbase=3"}, next())
+
+	// the evicted data points leave the venues' volumes
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(25*time.Second))
+	suite.Require().Equal([]string{"binance=1", "coinbase=2"}, next()[3:])
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(35*time.Second))
+	suite.Require().Equal([]string{"BTC-USD", "1m0s", "100", "coinbase=2"}, next())
+
+	// a reset empties the venues' volumes
+	suite.Require().NoError(productsVWAP.Reset("BTC-USD"))
+	produce("binance:BTC-USDT", "binance", 300, 2, 80*time.Second)
+	next()
+	suite.Require().Equal([]string{"BTC-USD", "3", "300", "binance=1"}, next())
+	suite.Require().Equal([]string{"BTC-USD", "1m0s", "300", "binance=1"}, next())
+}
+
+func (suite *VWAPTestSuite) TestCandles() {
+	productsVWAP := vwap.NewWindowed([]string{"Prod", "Quiet"}, vwap.CountWindowSpec(2))
+	suite.Require().Error(productsVWAP.Candles(nil))
+	suite.Require().Error(productsVWAP.Candles([]time.Duration{0}))
+	suite.Require().Error(productsVWAP.Candles([]time.Duration{time.Minute, time.Minute}))
+	suite.Require().NoError(productsVWAP.Candles([]time.Duration{time.Minute, 5 * time.Minute}))
+
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(price, volume float64, at time.Duration) {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: "Prod",
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(volume),
+			Time:      t0.Add(at),
+		}))
+		<-productsVWAP.GetResultsQ()
+	}
+	next := func(interval time.Duration, start time.Duration) *types.Candle {
+		c := <-productsVWAP.GetCandlesQ()
+		suite.Require().Equal("Prod", c.ProductID)
+		suite.Require().Equal(interval, c.Interval)
+		suite.Require().Equal(t0.Add(start), c.Start)
+		return c
+	}
+	ohlcv := func(c *types.Candle) []string {
+		return []string{
+			c.Open.String(), c.High.String(), c.Low.String(), c.Close.String(),
+			c.Volume.String(), c.Vwap.String(),
+		}
+	}
+
+	produce(10, 1, 10*time.Second)
+	produce(30, 2, 20*time.Second)
+	produce(5, 1, 30*time.Second)
+	produce(20, 4, 50*time.Second)
+	suite.Require().Len(productsVWAP.GetCandlesQ(), 0)
+
+	// the first trade past the minute closes its candle
+	produce(25, 1, 70*time.Second)
+	c := next(time.Minute, 0)
+	suite.Require().Equal([]string{"10", "30", "5", "20", "8", "19.375"}, ohlcv(c))
+	suite.Require().Equal(uint64(4), c.Trades)
+
+	// a late trade adds to the open candle
+	produce(1, 1, 50*time.Second)
+	suite.Require().Len(productsVWAP.GetCandlesQ(), 0)
+
+	// the quiet minutes' candles close on the wall-clock flat at the close
+	productsVWAP.CloseCandles(suite.ctx, t0.Add(119*time.Second))
+	suite.Require().Len(productsVWAP.GetCandlesQ(), 0)
+	productsVWAP.CloseCandles(suite.ctx, t0.Add(2*time.Minute))
+	c = next(time.Minute, time.Minute)
+	suite.Require().Equal([]string{"25", "25", "1", "1", "2", "13"}, ohlcv(c))
+	suite.Require().Equal(uint64(2), c.Trades)
+	productsVWAP.CloseCandles(suite.ctx, t0.Add(3*time.Minute))
+	c = next(time.Minute, 2*time.Minute)
+	suite.Require().Equal([]string{"1", "1", "1", "1", "0", "0"}, ohlcv(c))
+	suite.Require().Zero(c.Trades)
+
+	// the 5 minutes candle spans them all
+	productsVWAP.CloseCandles(suite.ctx, t0.Add(5*time.Minute))
+	c = next(time.Minute, 3*time.Minute)
+	suite.Require().Zero(c.Trades)
+	c = next(5*time.Minute, 0)
+	suite.Require().Equal([]string{"10", "30", "1", "1", "10", "18.1"}, ohlcv(c))
+	suite.Require().Equal(uint64(6), c.Trades)
+
+	// a product added later aggregates its candles too
+	suite.Require().NoError(productsVWAP.AddProduct("Later", []vwap.WindowSpec{vwap.CountWindowSpec(2)}))
+	suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+		ProductID: "Later",
+		Price:     big.NewFloat(3),
+		Size:      big.NewFloat(1),
+		Time:      t0,
+	}))
+	<-productsVWAP.GetResultsQ()
+	productsVWAP.CloseCandles(suite.ctx, t0.Add(6*time.Minute))
+	closed := map[string]int{}
+	for len(productsVWAP.GetCandlesQ()) > 0 {
+		closed[(<-productsVWAP.GetCandlesQ()).ProductID]++
+	}
+	// the quiet product without trades has no candles
+	suite.Require().Equal(map[string]int{"Prod": 1, "Later": 2}, closed)
+}
+
+func (suite *VWAPTestSuite) TestCandles_FullQueueCancelled() {
+	productsVWAP := vwap.NewWindowed([]string{"Prod"}, vwap.CountWindowSpec(2))
+	suite.Require().NoError(productsVWAP.Candles([]time.Duration{time.Minute}))
+
+	ctx, cancel := context.WithCancel(suite.ctx)
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(at time.Duration) error {
+		err := productsVWAP.ProduceTrade(ctx, &types.TradeValue{
+			ProductID: "Prod",
+			Price:     big.NewFloat(1),
+			Size:      big.NewFloat(1),
+			Time:      t0.Add(at),
+		})
+		<-productsVWAP.GetResultsQ()
+		return err
+	}
+
+	// each minute's trade closes the previous minute's candle until the queue
+	// is full
+	for i := 0; i <= cap(productsVWAP.GetCandlesQ()); i++ {
+		suite.Require().NoError(produce(time.Duration(i) * time.Minute))
+	}
+	suite.Require().Len(productsVWAP.GetCandlesQ(), cap(productsVWAP.GetCandlesQ()))
+
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- produce(time.Duration(cap(productsVWAP.GetCandlesQ())+1) * time.Minute)
+	}()
+	select {
+	case err := <-done:
+		suite.Require().NoError(err)
+	case <-time.After(5 * time.Second):
+		suite.FailNow("ProduceTrade blocked on the full candles queue past the cancellation")
+	}
+}
+
+func (suite *VWAPTestSuite) TestBands() {
+	productsVWAP := vwap.NewPerProduct(map[string][]vwap.WindowSpec{
+		"Count": {vwap.CountWindowSpec(2)},
+		"Time":  {vwap.TimeWindowSpec(5 * time.Second)},
+		"Plain": {vwap.CountWindowSpec(2)},
+	})
+	suite.Require().NoError(productsVWAP.SetBands("Count", []float64{1, 2}))
+	suite.Require().NoError(productsVWAP.SetBands("Time", []float64{1.5}))
+	suite.Require().NoError(productsVWAP.SetCalculators("Time", []vwap.Calculator{vwap.VWAPCalculator, vwap.TWAPCalculator}))
+	suite.Require().Error(productsVWAP.SetBands("Unknown", []float64{1}))
+	suite.Require().Error(productsVWAP.SetBands("Count", []float64{0}))
+	suite.Require().Error(productsVWAP.SetBands("Count", []float64{-1}))
+	suite.Require().Error(productsVWAP.SetBands("Count", []float64{math.Inf(1)}))
+
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	produce := func(productID string, price, volume float64, at time.Duration) {
+		suite.Require().NoError(productsVWAP.ProduceTrade(suite.ctx, &types.TradeValue{
+			ProductID: productID,
+			Price:     big.NewFloat(price),
+			Size:      big.NewFloat(volume),
+			Time:      t0.Add(at),
+		}))
+	}
+	// next returns the VWAP, its standard deviation and its bands
+	next := func() []string {
+		res := <-productsVWAP.GetResultsQ()
+		suite.Require().Equal(types.VWAPMetric, res.Metric)
+		got := []string{res.Vwap.String(), res.StdDev.String()}
+		for _, band := range res.Bands {
+			got = append(got, fmt.Sprintf("%v=%s/%s", band.Multiplier, band.Lower.String(), band.Upper.String()))
+		}
+		return got
+	}
+
+	// a single price does not deviate
+	produce("Count", 10, 1, 0)
+	suite.Require().Equal([]string{"10", "0", "1=10/10", "2=10/10"}, next())
+	produce("Count", 20, 1, time.Second)
+	suite.Require().Equal([]string{"15", "5", "1=10/20", "2=5/25"}, next())
+	// the dropped price's v·p² is subtracted
+	produce("Count", 40, 1, 2*time.Second)
+	suite.Require().Equal([]string{"30", "10", "1=20/40", "2=10/50"}, next())
+	// the volumes weigh the deviations
+	produce("Count", 20, 3, 3*time.Second)
+	suite.Require().Equal([]string{"25", "8.660254038", "1=16.33974596/33.66025404", "2=7.679491924/42.32050808"}, next())
+
+	produce("Time", 10, 1, 0)
+	suite.Require().Equal([]string{"10", "0", "1.5=10/10"}, next())
+	suite.Require().Nil((<-productsVWAP.GetResultsQ()).Bands)
+	produce("Time", 20, 1, time.Second)
+	suite.Require().Equal([]string{"15", "5", "1.5=7.5/22.5"}, next())
+	suite.Require().Nil((<-productsVWAP.GetResultsQ()).Bands)
+	// the evicted price's v·p² is subtracted
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(4500*time.Millisecond))
+	suite.Require().Equal([]string{"20", "0", "1.5=20/20"}, next())
+	<-productsVWAP.GetResultsQ()
+	productsVWAP.EvictExpired(suite.ctx, time.Now().Add(6*time.Second))
+	suite.Require().Equal([]string{"0", "0", "1.5=0/0"}, next())
+	<-productsVWAP.GetResultsQ()
+
+	// no bands until set
+	produce("Plain", 10, 1, 0)
+	res := <-productsVWAP.GetResultsQ()
+	suite.Require().Nil(res.StdDev)
+	suite.Require().Nil(res.Bands)
+	suite.Require().Len(productsVWAP.GetResultsQ(), 0)
+}
+
+func (suite *VWAPTestSuite) TestConsolidateErrors() {
+	newEngine := func() *vwap.ProductsVwap {
+		return vwap.NewWindowed([]string{"BTC-USD", "coinbase:BTC-USD"}, vwap.CountWindowSpec(2))
+	}
+
+	for _, tc := range []struct {
+		name       string
+		instrument string
+		weights    map[string]*big.Float
+	}{
+		{"unknown instrument", "ETH-USD", map[string]*big.Float{"coinbase:BTC-USD": big.NewFloat(1)}},
+		{"unknown venue product", "BTC-USD", map[string]*big.Float{"binance:BTC-USDT": big.NewFloat(1)}},
+		{"itself", "BTC-USD", map[string]*big.Float{"BTC-USD": big.NewFloat(1)}},
+		{"negative weight", "BTC-USD", map[string]*big.Float{"coinbase:BTC-USD": big.NewFloat(-1)}},
+		{"infinite weight", "BTC-USD", map[string]*big.Float{"coinbase:BTC-USD": big.NewFloat(math.Inf(1))}},
+	} {
+		suite.Run(tc.name, func() {
+			suite.Require().Error(newEngine().Consolidate(tc.instrument, tc.weights))
+		})
+	}
+
+	// a consolidated product stays
+	productsVWAP := newEngine()
+	suite.Require().NoError(productsVWAP.Consolidate("BTC-USD", map[string]*big.Float{"coinbase:BTC-USD": big.NewFloat(1)}))
+	suite.Require().Error(productsVWAP.RemoveProduct("BTC-USD"))
+	suite.Require().Error(productsVWAP.RemoveProduct("coinbase:BTC-USD"))
+
+	// an instrument is not a venue product of another
+	suite.Require().EqualError(
+		productsVWAP.Consolidate("coinbase:BTC-USD", map[string]*big.Float{"BTC-USD": big.NewFloat(1)}),
+		"the venue product coinbase:BTC-USD cannot be a consolidated instrument",
+	)
+
+	// nor consolidated twice doubling its venues' volumes
+	suite.Require().EqualError(
+		productsVWAP.Consolidate("BTC-USD", map[string]*big.Float{"coinbase:BTC-USD": big.NewFloat(1)}),
+		"the instrument BTC-USD is already consolidated",
+	)
+}
+
 func TestVWAPTestSuite(t *testing.T) {
 	suite.Run(t, new(VWAPTestSuite))
 }
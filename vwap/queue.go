@@ -1,6 +1,11 @@
 package vwap
 
-import "sync"
+import (
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
 
 // WindowQueue is a fixed size and allocated upfront queue of cached
 // data points specialized in the service of the VWAP algorithm needs.
@@ -11,6 +16,23 @@ type WindowQueue struct {
 	writeHead uint16
 	len       uint16
 	size      uint16
+	// suspect is the number of data points preceding a sequence gap still in
+	// the window
+	suspect uint16
+	// sessionEnd is the anchored window's upcoming reset boundary, zero until
+	// its first trade
+	sessionEnd time.Time
+	// trades counts the trades ever pushed
+	trades uint64
+	// sequence is the last pushed trade's sequence number
+	sequence int64
+	// updated is the time of the window's last change
+	updated time.Time
+	// received is the time of the last pushed trade's arrival
+	received time.Time
+	// venues are the running volumes of a consolidated instrument's venues
+	// within the window, nil for the other products
+	venues map[string]*venueVolume
 }
 
 func NewWindowQueue(size uint16) *WindowQueue {
@@ -29,6 +51,14 @@ func (q *WindowQueue) Peek(pos uint16) (*vwapCache, bool) {
 	return q.content[pos], true
 }
 
+func (q *WindowQueue) PeekFirst() (*vwapCache, bool) {
+	if q.len == 0 {
+		return nil, false
+	}
+
+	return q.Peek(q.readHead)
+}
+
 func (q *WindowQueue) PeekLast() (*vwapCache, bool) {
 	return q.Peek(q.Last())
 }
@@ -53,6 +83,9 @@ func (q *WindowQueue) Pop() (*vwapCache, bool) {
 	q.content[q.readHead] = nil
 	q.readHead = (q.readHead + 1) % q.size
 	q.len--
+	if q.suspect > 0 {
+		q.suspect--
+	}
 
 	return result, true
 }
@@ -68,3 +101,84 @@ func (q *WindowQueue) Push(v *vwapCache) bool {
 
 	return true
 }
+
+// Grow doubles the queue capacity up to math.MaxUint16 data points. It returns
+// false when the queue cannot grow any further.
+func (q *WindowQueue) Grow() bool {
+	if q.size == math.MaxUint16 {
+		return false
+	}
+
+	size := uint32(q.size) * 2
+	if size > math.MaxUint16 {
+		size = math.MaxUint16
+	}
+
+	content := make([]*vwapCache, size)
+	for i := uint16(0); i < q.len; i++ {
+		content[i] = q.content[(q.readHead+i)%q.size]
+	}
+	q.content = content
+	q.size = uint16(size)
+	q.readHead = 0
+	q.writeHead = q.len % q.size
+
+	return true
+}
+
+// Clear empties the queue returning the dropped data points.
+func (q *WindowQueue) Clear() []*vwapCache {
+	dropped := make([]*vwapCache, 0, q.len)
+	for q.len > 0 {
+		dataPoints, _ := q.Pop()
+		dropped = append(dropped, dataPoints)
+	}
+	q.readHead, q.writeHead, q.suspect = 0, 0, 0
+	for venue := range q.venues {
+		delete(q.venues, venue)
+	}
+
+	return dropped
+}
+
+// venueVolume is a venue's running volume within a window.
+type venueVolume struct {
+	vol *big.Float
+	// points counts the venue's data points within the window
+	points int
+}
+
+// addVenue adds the venue tagged data point's volume to its venue's.
+func (q *WindowQueue) addVenue(dataPoints *vwapCache) {
+	if dataPoints.Venue == "" {
+		return
+	}
+	if q.venues == nil {
+		q.venues = make(map[string]*venueVolume)
+	}
+
+	vv, ok := q.venues[dataPoints.Venue]
+	if !ok {
+		vv = &venueVolume{vol: new(big.Float)}
+		q.venues[dataPoints.Venue] = vv
+	}
+	vv.vol.Add(vv.vol, dataPoints.Vol)
+	vv.points++
+}
+
+// subVenue subtracts the dropped venue tagged data point's volume from its
+// venue's. A venue without data points left in the window is dropped, so
+// that the rounding residue of the subtractions does not linger.
+func (q *WindowQueue) subVenue(dataPoints *vwapCache) {
+	vv, ok := q.venues[dataPoints.Venue]
+	if !ok {
+		return
+	}
+
+	vv.points--
+	if vv.points <= 0 {
+		delete(q.venues, dataPoints.Venue)
+		return
+	}
+	vv.vol.Sub(vv.vol, dataPoints.Vol)
+}
@@ -1,6 +1,7 @@
 package vwap
 
 import (
+	"math"
 	"math/big"
 	"reflect"
 	"sync"
@@ -468,3 +469,33 @@ func TestWindowQueue_PeekLast(t *testing.T) {
 		)
 	}
 }
+
+func TestWindowQueue_Grow(t *testing.T) {
+	q := NewWindowQueue(3)
+	for i := 0; i < 5; i++ {
+		q.Push(&vwapCache{TPV: big.NewFloat(float64(i))})
+		if i >= 1 {
+			q.Pop()
+		}
+	}
+	// wrapped around: holds 4, 5 with the read head past the start
+	q.Push(&vwapCache{TPV: big.NewFloat(5)})
+	if !q.Grow() {
+		t.Fatalf("Grow() = false, want true")
+	}
+	if q.size != 6 || q.len != 2 {
+		t.Fatalf("Grow() size, len = %d, %d, want 6, 2", q.size, q.len)
+	}
+	q.Push(&vwapCache{TPV: big.NewFloat(6)})
+	for _, want := range []string{"4", "5", "6"} {
+		got, ok := q.Pop()
+		if !ok || got.TPV.String() != want {
+			t.Errorf("Pop() = %v, want %s", got, want)
+		}
+	}
+
+	full := &WindowQueue{size: math.MaxUint16}
+	if full.Grow() {
+		t.Errorf("Grow() = true at the max size")
+	}
+}
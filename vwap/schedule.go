@@ -0,0 +1,224 @@
+package vwap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells the session boundaries of an anchored window.
+type Schedule interface {
+	// Next returns the first boundary strictly after t.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// tzPrefix precedes the IANA time zone of a schedule i.e.
+// "TZ=America/New_York 17:00".
+const tzPrefix = "TZ="
+
+// ParseSchedule parses one of
+//   - "midnight": UTC midnight
+//   - "15:04" or "15:04:05": a daily time of day
+//   - "0 17 * * 1-5": a 5 fields cron expression of minute, hour, day of month,
+//     month and day of week supporting *, lists, ranges and steps
+//
+// optionally prefixed by a time zone i.e. "TZ=America/New_York 17:00". The
+// time zone defaults to UTC.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	loc := time.UTC
+	if strings.HasPrefix(spec, tzPrefix) {
+		fields := strings.SplitN(spec, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("schedule %q lacks an anchor after the time zone", spec)
+		}
+		var err error
+		if loc, err = time.LoadLocation(strings.TrimPrefix(fields[0], tzPrefix)); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+		spec = strings.TrimSpace(fields[1])
+	}
+
+	if spec == "midnight" {
+		return &DailySchedule{Location: loc}, nil
+	}
+
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, spec); err == nil {
+			return &DailySchedule{
+				TimeOfDay: time.Duration(t.Hour())*time.Hour +
+					time.Duration(t.Minute())*time.Minute +
+					time.Duration(t.Second())*time.Second,
+				Location: loc,
+			}, nil
+		}
+	}
+
+	return parseCron(spec, loc)
+}
+
+// DailySchedule is a boundary at the same time of each day.
+type DailySchedule struct {
+	// TimeOfDay is the offset from the day's midnight
+	TimeOfDay time.Duration
+	Location  *time.Location
+}
+
+func (s *DailySchedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location)
+	y, m, d := t.Date()
+	h, min, sec := splitTimeOfDay(s.TimeOfDay)
+
+	next := time.Date(y, m, d, h, min, sec, 0, s.Location)
+	if !next.After(t) {
+		// the wall clock time of the following day across daylight saving
+		// changes
+		next = time.Date(y, m, d+1, h, min, sec, 0, s.Location)
+	}
+
+	return next
+}
+
+func splitTimeOfDay(d time.Duration) (int, int, int) {
+	return int(d / time.Hour), int(d % time.Hour / time.Minute), int(d % time.Minute / time.Second)
+}
+
+func (s *DailySchedule) String() string {
+	h, min, sec := splitTimeOfDay(s.TimeOfDay)
+	return fmt.Sprintf("%s%s %02d:%02d:%02d", tzPrefix, s.Location, h, min, sec)
+}
+
+// cronSearchYears bounds the search of the next boundary of a cron expression
+// that may never match i.e. February 30th.
+const cronSearchYears = 5
+
+// CronSchedule is a boundary at every minute matching its fields.
+type CronSchedule struct {
+	spec     string
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// the day of month and week match either when both are restricted
+	anyDay   bool
+	Location *time.Location
+}
+
+func parseCron(spec string, loc *time.Location) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q is neither midnight, a time of day nor a 5 fields cron expression", spec)
+	}
+
+	s := &CronSchedule{spec: spec, Location: loc}
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&s.minutes, 0, 59},
+		{&s.hours, 0, 23},
+		{&s.days, 1, 31},
+		{&s.months, 1, 12},
+		{&s.weekdays, 0, 7},
+	}
+	for i, b := range bounds {
+		set, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q field %d: %w", spec, i+1, err)
+		}
+		*b.set = set
+	}
+	// Sunday is either 0 or 7
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.anyDay = fields[2] != "*" && fields[4] != "*"
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never matches within %d years", spec, cronSearchYears)
+	}
+
+	return s, nil
+}
+
+// parseCronField returns the bit set of the values matching a comma separated
+// list of *, n, a-b with an optional /step.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch i := strings.IndexByte(part, '-'); {
+		case part == "*":
+		case i != -1:
+			var err1, err2 error
+			low, err1 = strconv.Atoi(part[:i])
+			high, err2 = strconv.Atoi(part[i+1:])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			if low, err = strconv.Atoi(part); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.days&(1<<uint(t.Day())) != 0
+	dow := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			// the zone's next hour, whose offset may not be in whole hours
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.Location)
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *CronSchedule) String() string {
+	return fmt.Sprintf("%s%s %s", tzPrefix, s.Location, s.spec)
+}
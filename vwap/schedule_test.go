@@ -0,0 +1,145 @@
+package vwap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/vwap"
+)
+
+func TestParseSchedule_Next(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	kathmandu, err := time.LoadLocation("Asia/Kathmandu")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		t    time.Time
+		want time.Time
+	}{
+		{
+			name: "UTC midnight",
+			spec: "midnight",
+			t:    time.Date(2021, 11, 10, 21, 37, 7, 0, time.UTC),
+			want: time.Date(2021, 11, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Strictly after a boundary",
+			spec: "midnight",
+			t:    time.Date(2021, 11, 11, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Time of day later today",
+			spec: "17:30",
+			t:    time.Date(2021, 11, 10, 9, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 10, 17, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "Time of day with seconds tomorrow",
+			spec: "08:00:30",
+			t:    time.Date(2021, 11, 10, 9, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 11, 8, 0, 30, 0, time.UTC),
+		},
+		{
+			name: "Time of day in a time zone",
+			spec: "TZ=America/New_York 17:00",
+			t:    time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 10, 17, 0, 0, 0, newYork),
+		},
+		{
+			name: "Time of day across daylight saving's end",
+			spec: "TZ=America/New_York 17:00",
+			t:    time.Date(2021, 11, 6, 22, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 7, 17, 0, 0, 0, newYork),
+		},
+		{
+			name: "Cron every 15 minutes",
+			spec: "*/15 * * * *",
+			t:    time.Date(2021, 11, 10, 21, 37, 7, 0, time.UTC),
+			want: time.Date(2021, 11, 10, 21, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "Cron weekdays close skips the weekend",
+			spec: "TZ=America/New_York 0 17 * * 1-5",
+			t:    time.Date(2021, 11, 12, 23, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 15, 17, 0, 0, 0, newYork),
+		},
+		{
+			name: "Cron Sunday as 7",
+			spec: "0 0 * * 7",
+			t:    time.Date(2021, 11, 10, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Cron day of month or week",
+			spec: "0 0 1 * 0",
+			t:    time.Date(2021, 11, 22, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 28, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Cron lists and months",
+			spec: "30 9,16 1 1,7 *",
+			t:    time.Date(2021, 11, 10, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2022, 1, 1, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "Cron in a half-hour offset time zone",
+			spec: "TZ=Asia/Kolkata 0 17 * * *",
+			t:    time.Date(2021, 11, 10, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 10, 17, 0, 0, 0, kolkata),
+		},
+		{
+			name: "Cron weekdays in a 45 minutes offset time zone",
+			spec: "TZ=Asia/Kathmandu 0 9 * * 1-5",
+			t:    time.Date(2021, 11, 12, 6, 0, 0, 0, time.UTC),
+			want: time.Date(2021, 11, 15, 9, 0, 0, 0, kathmandu),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := vwap.ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error = %v", tt.spec, err)
+			}
+			if got := schedule.Next(tt.t); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"noon",
+		"25:00",
+		"TZ=Nowhere/Land 17:00",
+		"TZ=UTC",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		// never matching
+		"0 0 30 2 *",
+	} {
+		if _, err := vwap.ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) error = nil, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package vwap
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// WindowSnapshot is a point in time copy of a product's window.
+type WindowSnapshot struct {
+	// Window labels the window i.e. "200" trades or "5m0s"
+	Window string
+	// Vwap is the window's latest VWAP, zero when empty
+	Vwap *big.Float
+	// Fill is the number of data points in the window. An anchored window
+	// holds the running sums of its session in one data point.
+	Fill int
+	// Size is the count window's size, zero for the other windows
+	Size int
+	// Trades counts the trades ever added to the window
+	Trades uint64
+	// Suspect is true while the window spans a sequence gap
+	Suspect bool
+	// Updated is the time of the window's last change, zero before its first
+	// trade
+	Updated time.Time
+}
+
+// ProductSnapshot is a point in time copy of a product's windows.
+type ProductSnapshot struct {
+	ProductID string
+	Windows   []WindowSnapshot
+}
+
+// Snapshotter copies the products' windows for polling i.e. by the HTTP API.
+type Snapshotter interface {
+	// Snapshot returns the product's snapshot.
+	Snapshot(productID string) (ProductSnapshot, error)
+	// Snapshots returns the snapshot of each product ordered by product ID.
+	Snapshots() []ProductSnapshot
+}
+
+// Snapshot returns the product's snapshot. Each window is copied under its lock.
+func (v *ProductsVwap) Snapshot(productID string) (ProductSnapshot, error) {
+	pw, err := v.windows(productID)
+	if err != nil {
+		return ProductSnapshot{}, err
+	}
+
+	return pw.snapshot(productID), nil
+}
+
+// Snapshots returns the snapshot of each product ordered by product ID.
+func (v *ProductsVwap) Snapshots() []ProductSnapshot {
+	var snapshots []ProductSnapshot
+	v.vwapCache.Range(func(key, value interface{}) bool {
+		snapshots = append(snapshots, value.(*productWindows).snapshot(key.(string)))
+		return true
+	})
+	sortSnapshots(snapshots)
+
+	return snapshots
+}
+
+func (pw *productWindows) snapshot(productID string) ProductSnapshot {
+	snapshot := ProductSnapshot{
+		ProductID: productID,
+		Windows:   make([]WindowSnapshot, len(pw.queues)),
+	}
+	for i, window := range pw.queues {
+		ws := WindowSnapshot{
+			Window: pw.labels[i],
+			Vwap:   new(big.Float),
+		}
+		if pw.specs[i].Kind == CountWindow {
+			ws.Size = int(pw.specs[i].Size)
+		}
+
+		window.Lock()
+		if last, ok := window.PeekLast(); ok && window.len > 0 && last.TVol.Sign() != 0 {
+			ws.Vwap.Quo(last.TPV, last.TVol)
+		}
+		ws.Fill = int(window.len)
+		ws.Trades = window.trades
+		ws.Suspect = window.suspect > 0
+		ws.Updated = window.updated
+		window.Unlock()
+
+		snapshot.Windows[i] = ws
+	}
+
+	return snapshot
+}
+
+// Snapshot is the fixed-point counterpart of ProductsVwap.Snapshot.
+func (v *FixedProductsVwap) Snapshot(productID string) (ProductSnapshot, error) {
+	pw, err := v.productWindows(productID)
+	if err != nil {
+		return ProductSnapshot{}, err
+	}
+
+	return pw.snapshot(productID)
+}
+
+// Snapshots is the fixed-point counterpart of ProductsVwap.Snapshots. The
+// products whose VWAP overflows are skipped.
+func (v *FixedProductsVwap) Snapshots() []ProductSnapshot {
+	var snapshots []ProductSnapshot
+	v.windows.Range(func(key, value interface{}) bool {
+		if snapshot, err := value.(*fixedProductWindows).snapshot(key.(string)); err == nil {
+			snapshots = append(snapshots, snapshot)
+		}
+		return true
+	})
+	sortSnapshots(snapshots)
+
+	return snapshots
+}
+
+func (pw *fixedProductWindows) snapshot(productID string) (ProductSnapshot, error) {
+	snapshot := ProductSnapshot{
+		ProductID: productID,
+		Windows:   make([]WindowSnapshot, len(pw.windows)),
+	}
+	for i, window := range pw.windows {
+		window.Lock()
+		vwap, err := window.vwap()
+		ws := WindowSnapshot{
+			Window:  pw.labels[i],
+			Vwap:    vwap,
+			Fill:    int(window.len),
+			Size:    int(window.size),
+			Trades:  window.trades,
+			Suspect: window.suspect > 0,
+			Updated: window.updated,
+		}
+		window.Unlock()
+		if err != nil {
+			return ProductSnapshot{}, fmt.Errorf("%s: %w", productID, err)
+		}
+
+		snapshot.Windows[i] = ws
+	}
+
+	return snapshot, nil
+}
+
+func sortSnapshots(snapshots []ProductSnapshot) {
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].ProductID < snapshots[j].ProductID
+	})
+}
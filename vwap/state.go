@@ -0,0 +1,384 @@
+package vwap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// StateVersion is the encoding version of the saved windows. States of other
+// versions are not restored.
+const StateVersion = 1
+
+// Persister saves the products' windows and restores them on a warm restart,
+// so that the VWAP results do not start over from empty windows.
+type Persister interface {
+	// State copies the products' windows as of now.
+	State(now time.Time) *State
+	// Restore refills the products' windows off the state. It is called
+	// before producing any trade.
+	Restore(state *State) error
+}
+
+// State is the versioned copy of the products' windows saved across restarts.
+type State struct {
+	Version int `json:"version"`
+	// Taken is the time of the copy
+	Taken    time.Time      `json:"taken"`
+	Products []ProductState `json:"products"`
+}
+
+// ProductState is a product's windows within a State.
+type ProductState struct {
+	ProductID string `json:"product_id"`
+	// Sequence is the product's last trade sequence number, zero for a
+	// consolidated instrument
+	Sequence int64         `json:"sequence"`
+	Windows  []WindowState `json:"windows"`
+}
+
+// WindowState is a window's data points oldest first within a State.
+type WindowState struct {
+	// Window labels the window i.e. "200" trades or "5m0s"
+	Window  string `json:"window"`
+	Trades  uint64 `json:"trades"`
+	Suspect uint16 `json:"suspect"`
+	// SessionEnd is the anchored window's upcoming reset boundary
+	SessionEnd time.Time `json:"session_end"`
+	Updated    time.Time `json:"updated"`
+	// Received is the arrival time of the window's last trade
+	Received time.Time    `json:"received"`
+	Points   []PointState `json:"points"`
+}
+
+// PointState is a window data point within a State. Its values are the
+// shortest decimal texts that parse back to the exact values at Prec mantissa
+// bits.
+type PointState struct {
+	Prec  uint   `json:"prec"`
+	TPV   string `json:"tpv"`
+	TVol  string `json:"tvol"`
+	PV    string `json:"pv"`
+	Vol   string `json:"vol"`
+	TPV2  string `json:"tpv2"`
+	PV2   string `json:"pv2"`
+	Price string `json:"price"`
+	TPT   string `json:"tpt"`
+	PT    string `json:"pt"`
+	// TDur and Dur are the nanoseconds of the TWAP sums
+	TDur  time.Duration `json:"tdur"`
+	Dur   time.Duration `json:"dur"`
+	Time  time.Time     `json:"time"`
+	Venue string        `json:"venue,omitempty"`
+}
+
+// Stale returns whether the state was taken longer than maxAge before now.
+func (s *State) Stale(now time.Time, maxAge time.Duration) bool {
+	return now.Sub(s.Taken) > maxAge
+}
+
+// Sequences returns the last trade sequence number of each product.
+func (s *State) Sequences() map[string]int64 {
+	sequences := make(map[string]int64, len(s.Products))
+	for _, product := range s.Products {
+		if product.Sequence != 0 {
+			sequences[product.ProductID] = product.Sequence
+		}
+	}
+
+	return sequences
+}
+
+// State copies the products' windows as of now ordered by product ID. Each
+// window is copied under its lock.
+func (v *ProductsVwap) State(now time.Time) *State {
+	state := &State{
+		Version: StateVersion,
+		Taken:   now,
+	}
+	v.vwapCache.Range(func(key, value interface{}) bool {
+		state.Products = append(state.Products, value.(*productWindows).state(key.(string)))
+		return true
+	})
+	sort.Slice(state.Products, func(i, j int) bool {
+		return state.Products[i].ProductID < state.Products[j].ProductID
+	})
+
+	return state
+}
+
+func (pw *productWindows) state(productID string) ProductState {
+	product := ProductState{
+		ProductID: productID,
+		Windows:   make([]WindowState, len(pw.queues)),
+	}
+	for i, window := range pw.queues {
+		ws := WindowState{
+			Window: pw.labels[i],
+		}
+
+		window.Lock()
+		ws.Trades = window.trades
+		ws.Suspect = window.suspect
+		ws.SessionEnd = window.sessionEnd
+		ws.Updated = window.updated
+		ws.Received = window.received
+		if window.sequence > product.Sequence && !pw.consolidated {
+			product.Sequence = window.sequence
+		}
+		ws.Points = make([]PointState, window.len)
+		for j := uint16(0); j < window.len; j++ {
+			ws.Points[j] = newPointState(window.content[(window.readHead+j)%window.size])
+		}
+		window.Unlock()
+
+		product.Windows[i] = ws
+	}
+
+	return product
+}
+
+func newPointState(dataPoints *vwapCache) PointState {
+	prec := dataPoints.TPV.Prec()
+	for _, x := range []*big.Float{dataPoints.TVol, dataPoints.PV, dataPoints.Vol, dataPoints.TPV2, dataPoints.PV2, dataPoints.Price, dataPoints.TPT, dataPoints.PT} {
+		if x.Prec() > prec {
+			prec = x.Prec()
+		}
+	}
+
+	return PointState{
+		Prec:  prec,
+		TPV:   decimalText(dataPoints.TPV, prec),
+		TVol:  decimalText(dataPoints.TVol, prec),
+		PV:    decimalText(dataPoints.PV, prec),
+		Vol:   decimalText(dataPoints.Vol, prec),
+		TPV2:  decimalText(dataPoints.TPV2, prec),
+		PV2:   decimalText(dataPoints.PV2, prec),
+		Price: decimalText(dataPoints.Price, prec),
+		TPT:   decimalText(dataPoints.TPT, prec),
+		PT:    decimalText(dataPoints.PT, prec),
+		TDur:  dataPoints.TDur,
+		Dur:   dataPoints.Dur,
+		Time:  dataPoints.Time,
+		Venue: dataPoints.Venue,
+	}
+}
+
+// decimalText returns the shortest decimal text of x that parses back to x at
+// prec mantissa bits.
+func decimalText(x *big.Float, prec uint) string {
+	if x.Prec() != prec {
+		// widening the mantissa is exact
+		x = new(big.Float).SetPrec(prec).Set(x)
+	}
+
+	return x.Text('f', -1)
+}
+
+// Restore refills the products' windows off the state replacing their data
+// points. The state's products and windows no longer configured are skipped;
+// so are the configured ones missing from the state. Nothing is restored when
+// any of the state's values is invalid.
+func (v *ProductsVwap) Restore(state *State) error {
+	if state.Version != StateVersion {
+		return fmt.Errorf("unsupported windows state version %d, want %d", state.Version, StateVersion)
+	}
+
+	type restored struct {
+		window   *WindowQueue
+		ws       *WindowState
+		sequence int64
+		points   []*vwapCache
+	}
+	var windows []restored
+	recycle := func() {
+		for _, r := range windows {
+			for _, dataPoints := range r.points {
+				recycleToPool(dataPoints)
+			}
+		}
+	}
+
+	for p := range state.Products {
+		product := &state.Products[p]
+		pw, err := v.windows(product.ProductID)
+		if err != nil {
+			continue
+		}
+
+		for w := range product.Windows {
+			ws := &product.Windows[w]
+			i := pw.index(ws.Window)
+			if i < 0 {
+				continue
+			}
+			if len(ws.Points) > int(pw.specs[i].maxPoints()) {
+				recycle()
+				return fmt.Errorf("%s window %s: %d data points exceed its capacity", product.ProductID, ws.Window, len(ws.Points))
+			}
+
+			r := restored{
+				window:   pw.queues[i],
+				ws:       ws,
+				sequence: product.Sequence,
+				points:   make([]*vwapCache, 0, len(ws.Points)),
+			}
+			for _, ps := range ws.Points {
+				dataPoints, err := ps.dataPoints()
+				if err != nil {
+					windows = append(windows, r)
+					recycle()
+					return fmt.Errorf("%s window %s: %w", product.ProductID, ws.Window, err)
+				}
+				if !pw.consolidated {
+					dataPoints.Venue = ""
+				}
+				r.points = append(r.points, dataPoints)
+			}
+			windows = append(windows, r)
+		}
+	}
+
+	for _, r := range windows {
+		r.window.restore(r.ws, r.sequence, r.points)
+	}
+
+	return nil
+}
+
+// index returns the index of the labelled window or -1.
+func (pw *productWindows) index(label string) int {
+	for i, l := range pw.labels {
+		if l == label {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// maxPoints returns the most data points the window holds.
+func (s WindowSpec) maxPoints() uint16 {
+	if s.Kind == TimeWindow {
+		return math.MaxUint16
+	}
+
+	return s.capacity()
+}
+
+// restore replaces the window's data points and counters with the state's.
+func (q *WindowQueue) restore(ws *WindowState, sequence int64, points []*vwapCache) {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, droppedDataPoints := range q.Clear() {
+		recycleToPool(droppedDataPoints)
+	}
+	for _, dataPoints := range points {
+		for !q.Push(dataPoints) {
+			// within maxPoints
+			q.Grow()
+		}
+		q.addVenue(dataPoints)
+	}
+
+	q.trades = ws.Trades
+	q.sequence = sequence
+	q.suspect = ws.Suspect
+	if q.suspect > q.len {
+		q.suspect = q.len
+	}
+	q.sessionEnd = ws.SessionEnd
+	q.updated = ws.Updated
+	// the states saved without it last changed on a trade's arrival or later
+	q.received = ws.Received
+	if q.received.IsZero() {
+		q.received = ws.Updated
+	}
+}
+
+// dataPoints returns the window data point of the state's decimal texts.
+func (ps PointState) dataPoints() (*vwapCache, error) {
+	if ps.Prec == 0 || ps.Prec > big.MaxPrec {
+		return nil, fmt.Errorf("invalid data point precision %d", ps.Prec)
+	}
+	if ps.TDur < 0 || ps.Dur < 0 {
+		return nil, fmt.Errorf("negative data point durations %s, %s", ps.TDur, ps.Dur)
+	}
+
+	dataPoints := memPoolGet()
+	for _, v := range []struct {
+		x    *big.Float
+		text string
+	}{
+		{dataPoints.TPV, ps.TPV},
+		{dataPoints.TVol, ps.TVol},
+		{dataPoints.PV, ps.PV},
+		{dataPoints.Vol, ps.Vol},
+		{dataPoints.TPV2, ps.TPV2},
+		{dataPoints.PV2, ps.PV2},
+		{dataPoints.Price, ps.Price},
+		{dataPoints.TPT, ps.TPT},
+		{dataPoints.PT, ps.PT},
+	} {
+		if _, ok := v.x.SetPrec(ps.Prec).SetMode(big.ToNearestEven).SetString(v.text); !ok {
+			recycleToPool(dataPoints)
+			return nil, fmt.Errorf("invalid data point decimal %q", v.text)
+		}
+	}
+	dataPoints.TDur, dataPoints.Dur = ps.TDur, ps.Dur
+	dataPoints.Time = ps.Time
+	dataPoints.Venue = ps.Venue
+
+	return dataPoints, nil
+}
+
+// WriteState saves the state to the file replacing it atomically, so that a
+// crash mid-write leaves the previous state intact.
+func WriteState(path string, state *State) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(state); err != nil {
+		f.Close()
+		return fmt.Errorf("encoding the windows state: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// ReadState reads the state saved to the file.
+func ReadState(path string) (*State, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var state State
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decoding the windows state %s: %w", path, err)
+	}
+	if state.Version != StateVersion {
+		return nil, fmt.Errorf("unsupported windows state version %d, want %d", state.Version, StateVersion)
+	}
+
+	return &state, nil
+}
+
+var _ Persister = (*ProductsVwap)(nil)
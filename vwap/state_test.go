@@ -0,0 +1,206 @@
+package vwap_test
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+func TestProductsVwap_StateRestore(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	midnight, err := vwap.ParseSchedule("midnight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := []vwap.WindowSpec{
+		vwap.CountWindowSpec(2),
+		vwap.TimeWindowSpec(time.Hour),
+		vwap.AnchoredWindowSpec(midnight),
+	}
+	calculators := []vwap.Calculator{vwap.VWAPCalculator, vwap.TWAPCalculator}
+	newEngine := func() *vwap.ProductsVwap {
+		engine := vwap.NewWindowed([]string{"ETH-USD", "BTC-USD"}, windows...)
+		if err := engine.SetCalculators("ETH-USD", calculators); err != nil {
+			t.Fatal(err)
+		}
+		if err := engine.SetBands("ETH-USD", []float64{2}); err != nil {
+			t.Fatal(err)
+		}
+		return engine
+	}
+	t0 := time.Date(2021, 11, 10, 21, 0, 0, 0, time.UTC)
+	parse := func(decimal string) *big.Float {
+		f, _, err := new(big.Float).SetPrec(128).Parse(decimal, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	// produce returns the VWAP and TWAP results of the trade's windows
+	// followed by the VWAPs' standard deviations
+	produce := func(engine *vwap.ProductsVwap, price, size string, sequence int64) []string {
+		t.Helper()
+		if err := engine.ProduceTrade(ctx, &types.TradeValue{
+			ProductID: "ETH-USD",
+			Price:     parse(price),
+			Size:      parse(size),
+			Sequence:  sequence,
+			Time:      t0.Add(time.Duration(sequence) * time.Second),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		vwaps := make([]string, len(windows)*len(calculators))
+		var stdDevs []string
+		for i := range vwaps {
+			res := <-engine.GetResultsQ()
+			vwaps[i] = res.Vwap.Text('g', 40)
+			if res.StdDev != nil {
+				stdDevs = append(stdDevs, res.StdDev.Text('g', 40))
+			}
+		}
+		return append(vwaps, stdDevs...)
+	}
+
+	saved := newEngine()
+	produce(saved, "4606.8", "0.00269988", 10)
+	produce(saved, "4606.9", "0.00130012", 11)
+	produce(saved, "4607.15", "0.5", 12)
+	if err := saved.MarkSuspect("ETH-USD"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "windows.json")
+	if err := vwap.WriteState(path, saved.State(t0.Add(time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	state, err := vwap.ReadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := state.Sequences(); len(got) != 1 || got["ETH-USD"] != 12 {
+		t.Errorf("Sequences() = %v, want ETH-USD 12", got)
+	}
+	if got, want := state.Products[1].Windows[1].Points[0].Vol, "0.00269988"; got != want {
+		t.Errorf("first time window data point volume = %s, want %s", got, want)
+	}
+	if !state.Stale(t0.Add(time.Hour), time.Minute) || state.Stale(t0.Add(2*time.Minute), time.Minute) {
+		t.Error("Stale() misjudges the state's age")
+	}
+
+	restored := newEngine()
+	if err := restored.Restore(state); err != nil {
+		t.Fatal(err)
+	}
+	want, got := saved.Snapshots(), restored.Snapshots()
+	for p := range want {
+		for w := range want[p].Windows {
+			ws, rs := want[p].Windows[w], got[p].Windows[w]
+			if ws.Vwap.Cmp(rs.Vwap) != 0 || ws.Fill != rs.Fill || ws.Trades != rs.Trades ||
+				ws.Suspect != rs.Suspect || !ws.Updated.Equal(rs.Updated) {
+				t.Errorf("%s window %s restored as %+v, want %+v", want[p].ProductID, ws.Window, rs, ws)
+			}
+		}
+	}
+
+	// the restored windows carry on exactly as the saved ones
+	if got, want := produce(restored, "4606.95", "1.25", 13), produce(saved, "4606.95", "1.25", 13); !equal(got, want) {
+		t.Errorf("restored windows VWAP, TWAP and σ = %v, want %v", got, want)
+	}
+}
+
+func TestProductsVwap_RestoreErrors(t *testing.T) {
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	engine := vwap.New([]string{"Prod"}, 2)
+	if err := engine.ProduceVwap(ctx, "Prod", big.NewFloat(2), big.NewFloat(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-engine.GetResultsQ()
+
+	valid := func() *vwap.State {
+		return engine.State(time.Now())
+	}
+	tests := []struct {
+		name  string
+		state func() *vwap.State
+	}{
+		{
+			name: "Unsupported version",
+			state: func() *vwap.State {
+				s := valid()
+				s.Version++
+				return s
+			},
+		},
+		{
+			name: "Invalid decimal",
+			state: func() *vwap.State {
+				s := valid()
+				s.Products[0].Windows[0].Points[0].TPV = "2.x"
+				return s
+			},
+		},
+		{
+			name: "Invalid precision",
+			state: func() *vwap.State {
+				s := valid()
+				s.Products[0].Windows[0].Points[0].Prec = 0
+				return s
+			},
+		},
+		{
+			name: "Exceeds the window",
+			state: func() *vwap.State {
+				s := valid()
+				points := s.Products[0].Windows[0].Points
+				s.Products[0].Windows[0].Points = append(points, points[0], points[0])
+				return s
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := engine.Restore(tt.state()); err == nil {
+				t.Fatal("Restore() expected an error")
+			}
+			// the windows are left as they were
+			snapshot, err := engine.Snapshot("Prod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ws := snapshot.Windows[0]; ws.Fill != 1 || ws.Vwap.String() != "2" {
+				t.Errorf("window after a failed restore = %+v", ws)
+			}
+		})
+	}
+
+	// the products and windows no longer configured are skipped
+	s := valid()
+	s.Products = append(s.Products, vwap.ProductState{ProductID: "Gone"})
+	s.Products[0].Windows[0].Window = "3"
+	if err := engine.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, _ := engine.Snapshot("Prod"); snapshot.Windows[0].Fill != 1 {
+		t.Errorf("the unmatched window was restored: %+v", snapshot.Windows[0])
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
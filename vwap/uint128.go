@@ -0,0 +1,37 @@
+package vwap
+
+import "math/bits"
+
+// uint128 is the unsigned 128-bit integer of the fixed-point PV sums.
+type uint128 struct {
+	hi, lo uint64
+}
+
+func mul64(x, y uint64) uint128 {
+	hi, lo := bits.Mul64(x, y)
+	return uint128{hi: hi, lo: lo}
+}
+
+// add returns u+v and whether it overflowed.
+func (u uint128) add(v uint128) (uint128, bool) {
+	lo, carry := bits.Add64(u.lo, v.lo, 0)
+	hi, carry := bits.Add64(u.hi, v.hi, carry)
+	return uint128{hi: hi, lo: lo}, carry != 0
+}
+
+// sub returns u-v with v no greater than u.
+func (u uint128) sub(v uint128) uint128 {
+	lo, borrow := bits.Sub64(u.lo, v.lo, 0)
+	hi, _ := bits.Sub64(u.hi, v.hi, borrow)
+	return uint128{hi: hi, lo: lo}
+}
+
+// div64 returns the quotient and remainder of u/y and false if the quotient
+// overflows 64 bits.
+func (u uint128) div64(y uint64) (uint64, uint64, bool) {
+	if u.hi >= y {
+		return 0, 0, false
+	}
+	q, r := bits.Div64(u.hi, u.lo, y)
+	return q, r, true
+}
@@ -0,0 +1,150 @@
+package vwap
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// WindowKind tells apart the moving windows bounded by their count of trades
+// from those bounded by their time horizon.
+type WindowKind uint8
+
+const (
+	// CountWindow holds the last Size trades.
+	CountWindow WindowKind = iota
+	// TimeWindow holds the trades of the last Duration.
+	TimeWindow
+	// AnchoredWindow accumulates the trades since the last session boundary
+	// of its Anchor schedule.
+	AnchoredWindow
+)
+
+func (k WindowKind) String() string {
+	switch k {
+	case CountWindow:
+		return "count"
+	case TimeWindow:
+		return "time"
+	case AnchoredWindow:
+		return "anchored"
+	default:
+		return "unknown"
+	}
+}
+
+// WindowSpec defines a product's moving window.
+type WindowSpec struct {
+	Kind WindowKind
+	// Size is the count window's number of trades
+	Size uint16
+	// Duration is the time window's horizon
+	Duration time.Duration
+	// Anchor is the anchored window's session reset schedule
+	Anchor Schedule
+}
+
+// CountWindowSpec returns the window spec of the last size trades.
+func CountWindowSpec(size uint16) WindowSpec {
+	return WindowSpec{Kind: CountWindow, Size: size}
+}
+
+// TimeWindowSpec returns the window spec of the trades within the horizon.
+func TimeWindowSpec(horizon time.Duration) WindowSpec {
+	return WindowSpec{Kind: TimeWindow, Duration: horizon}
+}
+
+// AnchoredWindowSpec returns the window spec of the trades since the last
+// boundary of the anchor schedule.
+func AnchoredWindowSpec(anchor Schedule) WindowSpec {
+	return WindowSpec{Kind: AnchoredWindow, Anchor: anchor}
+}
+
+// ParseWindowSpec parses a count window i.e. "200" or a time window i.e.
+// "5m".
+func ParseWindowSpec(label string) (WindowSpec, error) {
+	if size, err := strconv.ParseUint(label, 10, 16); err == nil {
+		return CountWindowSpec(uint16(size)), nil
+	}
+	if horizon, err := time.ParseDuration(label); err == nil {
+		return TimeWindowSpec(horizon), nil
+	}
+
+	return WindowSpec{}, fmt.Errorf("window %q is neither a trades count nor a duration", label)
+}
+
+// String labels the window i.e. "200" trades, "5m0s" or
+// "anchored TZ=UTC 00:00:00".
+func (s WindowSpec) String() string {
+	switch s.Kind {
+	case TimeWindow:
+		return s.Duration.String()
+	case AnchoredWindow:
+		return "anchored " + s.Anchor.String()
+	}
+
+	return strconv.Itoa(int(s.Size))
+}
+
+// Validate returns an error for an empty window.
+func (s WindowSpec) Validate() error {
+	switch {
+	case s.Kind == CountWindow && s.Size == 0:
+		return fmt.Errorf("the count window size must be positive")
+	case s.Kind == TimeWindow && s.Duration <= 0:
+		return fmt.Errorf("the time window duration must be positive")
+	case s.Kind == AnchoredWindow && s.Anchor == nil:
+		return fmt.Errorf("the anchored window lacks a reset schedule")
+	case s.Kind > AnchoredWindow:
+		return fmt.Errorf("unknown window kind %d", s.Kind)
+	}
+
+	return nil
+}
+
+const (
+	// timeWindowCapacity is the initial queue capacity of a time window. The
+	// queue grows up to math.MaxUint16 data points beyond which the oldest are
+	// dropped regardless of their time.
+	timeWindowCapacity = 256
+	// maxEvictionInterval bounds the eviction timer period of time windows.
+	maxEvictionInterval = time.Second
+	minEvictionInterval = 10 * time.Millisecond
+)
+
+// capacity returns the initial queue capacity of the window.
+func (s WindowSpec) capacity() uint16 {
+	switch s.Kind {
+	case TimeWindow:
+		return timeWindowCapacity
+	case AnchoredWindow:
+		// only the last data point carrying the session's sums is kept
+		return 1
+	}
+
+	return s.Size
+}
+
+// resultsCapacity returns the results queue capacity of the window's engine.
+func (s WindowSpec) resultsCapacity() uint16 {
+	if s.Kind == AnchoredWindow {
+		// the single data point does not bound the queued results
+		return timeWindowCapacity
+	}
+
+	return s.capacity()
+}
+
+// evictionInterval returns the period of the timer evicting the expired data
+// points of a quiet time window.
+func (s WindowSpec) evictionInterval() time.Duration {
+	interval := s.Duration / 10
+	if interval > maxEvictionInterval {
+		return maxEvictionInterval
+	}
+	if interval < minEvictionInterval {
+		return minEvictionInterval
+	}
+
+	return interval
+}
@@ -0,0 +1,273 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/blewater/zh/batch"
+	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+// computeBufferLen is the write buffer of the results file.
+const computeBufferLen = 1 << 20
+
+// tmpSuffix names the results file until it is complete.
+const tmpSuffix = ".tmp"
+
+// Compute computes the VWAP results of the configured trades file to the
+// results file with the same windows as the live feed but without any
+// network. The trades are produced in the file's order by a single go
+// routine; each product is added to the engine on its first trade with its
+// own windows. The engine's timers do not run, so that the results depend on
+// the trades alone. The malformed trades up to the configured maximum are
+// logged and skipped. It returns on the first error beyond them or once every
+// result is written. The results file is written under a temporary name and
+// renamed once complete, so that a failed run leaves no partial results.
+func Compute(ctx context.Context, cfg cmd.Config) error {
+	logger := log.FromContext(ctx)
+
+	engine, err := vwap.NewPerProductEngine(cfg.Engine, cfg.ProductWindows(), cfg.ProductScales(), cfg.FixedScale)
+	if err != nil {
+		return err
+	}
+	for _, p := range cfg.EngineProductIDs() {
+		if err := configureProduct(engine, p, cfg.Product(p)); err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(cfg.Compute.In)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	trades, err := batch.NewReader(in, cfg.Compute.Reader)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if cfg.Compute.Out != "" && cfg.Compute.Out != "-" {
+		tmp := cfg.Compute.Out + tmpSuffix
+		if out, err = os.Create(tmp); err != nil {
+			return err
+		}
+		defer func() {
+			// a no-op once renamed
+			_ = out.Close()
+			_ = os.Remove(tmp)
+		}()
+	}
+	w := bufio.NewWriterSize(out, computeBufferLen)
+	results, err := sink.NewWriterSink(cfg.Compute.OutFormat, w)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rw := newResultsWriter(cfg, results)
+	producing := make(chan struct{})
+	written := make(chan error, 1)
+	go func() {
+		written <- rw.writeUntil(cancel, engine.GetResultsQ(), producing)
+	}()
+
+	start := time.Now()
+	n, skipped, err := computeTrades(ctx, cfg, engine, trades)
+	close(producing)
+	// a write error cancels the computation
+	if werr := <-written; werr != nil {
+		err = werr
+	}
+	if err != nil {
+		return err
+	}
+	if err := results.Close(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(out.Name(), cfg.Compute.Out); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Computed the trades file",
+		zap.String("in", cfg.Compute.In),
+		zap.Int64("trades", n),
+		zap.Int("skipped", skipped),
+		zap.Duration("elapsed", time.Since(start)),
+	)
+
+	return nil
+}
+
+// computeTrades produces the trades in order until the last one, the first
+// malformed one beyond the configured maximum or ctx is cancelled. It returns
+// the number of produced trades and of the skipped malformed ones.
+func computeTrades(ctx context.Context, cfg cmd.Config, engine vwap.Engine, trades *batch.Reader) (int64, int, error) {
+	logger := log.FromContext(ctx)
+
+	// the products known to the engine
+	products := make(map[string]bool)
+	for _, p := range cfg.EngineProductIDs() {
+		products[p] = true
+	}
+
+	var (
+		n       int64
+		skipped int
+	)
+	for ctx.Err() == nil {
+		tradeValue := getMemPoolTradeVal(cfg.Precision, cfg.RoundingMode)
+		if err := trades.Read(tradeValue); err != nil {
+			recycleTradeVal(tradeValue)
+			if err == io.EOF {
+				return n, skipped, nil
+			}
+			if !batch.Malformed(err) || cfg.Compute.MaxErrors >= 0 && skipped >= cfg.Compute.MaxErrors {
+				return n, skipped, err
+			}
+			skipped++
+			logger.Warn("Skipped a malformed trade", zap.Error(err), zap.Int("skipped", skipped))
+			continue
+		}
+
+		if !products[tradeValue.ProductID] {
+			if err := addProduct(engine, tradeValue.ProductID, cfg.Product(tradeValue.ProductID)); err != nil {
+				recycleTradeVal(tradeValue)
+				return n, skipped, err
+			}
+			products[tradeValue.ProductID] = true
+		}
+
+		productID := tradeValue.ProductID
+		err := engine.ProduceTrade(ctx, tradeValue)
+		types.TradeValueMemPool.Put(tradeValue)
+		if err != nil {
+			return n, skipped, fmt.Errorf("%s trade %d: %w", productID, n+1, err)
+		}
+		n++
+	}
+
+	return n, skipped, ctx.Err()
+}
+
+// resultsWriter writes the computed results a row per trade or a row per
+// product window and metric.
+type resultsWriter struct {
+	cfg  cmd.Config
+	rows batch.Rows
+	sink sink.Sink
+	// products caches the products' settings
+	products map[string]cmd.ProductConfig
+	// last are the product windows' last results in their first result's
+	// order
+	last  map[string]*sink.Result
+	order []string
+}
+
+func newResultsWriter(cfg cmd.Config, results sink.Sink) *resultsWriter {
+	return &resultsWriter{
+		cfg:      cfg,
+		rows:     cfg.Compute.Rows,
+		sink:     results,
+		products: make(map[string]cmd.ProductConfig),
+		last:     make(map[string]*sink.Result),
+	}
+}
+
+// writeUntil writes the queued results until producing is closed and its
+// last results are written. The first write error cancels the computation;
+// the results are drained regardless, so that the producer is not blocked.
+func (rw *resultsWriter) writeUntil(cancel context.CancelFunc, resultsQ <-chan *types.VWAPResult, producing <-chan struct{}) error {
+	var firstErr error
+	write := func(res *types.VWAPResult) {
+		if err := rw.write(res); err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		types.VWAPResultMemPool.Put(res)
+	}
+
+	for {
+		select {
+		case res := <-resultsQ:
+			write(res)
+		case <-producing:
+			for {
+				select {
+				case res := <-resultsQ:
+					write(res)
+				default:
+					if firstErr == nil && rw.rows == batch.ProductRows {
+						firstErr = rw.writeLast()
+					}
+					return firstErr
+				}
+			}
+		}
+	}
+}
+
+// write writes the trade's result or keeps it as its product window's last.
+func (rw *resultsWriter) write(res *types.VWAPResult) error {
+	product, ok := rw.products[res.ProductID]
+	if !ok {
+		product = rw.cfg.Product(res.ProductID)
+		rw.products[res.ProductID] = product
+	}
+	if !product.Enabled(vwap.CalculatorOf(res.Metric)) {
+		return nil
+	}
+
+	if rw.rows == batch.TradeRows {
+		return rw.sink.Write(&sink.Result{
+			VWAPResult: *res,
+			Precision:  product.OutputPrecision,
+		})
+	}
+
+	key := res.ProductID + "\x00" + res.Window + "\x00" + res.Metric.String()
+	if _, ok := rw.last[key]; !ok {
+		rw.order = append(rw.order, key)
+	}
+	// a copy as the result is recycled
+	rw.last[key] = &sink.Result{
+		VWAPResult: *res,
+		Precision:  product.OutputPrecision,
+	}
+
+	return nil
+}
+
+// writeLast writes the product windows' last results ordered by product ID.
+func (rw *resultsWriter) writeLast() error {
+	sort.SliceStable(rw.order, func(i, j int) bool {
+		return rw.last[rw.order[i]].ProductID < rw.last[rw.order[j]].ProductID
+	})
+	for _, key := range rw.order {
+		if err := rw.sink.Write(rw.last[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
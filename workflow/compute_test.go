@@ -0,0 +1,270 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/batch"
+	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+const tradesCSV = `product_id,price,size,time
+BTC-USD,100,1,2021-11-10T21:00:00Z
+ETH-USD,10,2,2021-11-10T21:00:01Z
+BTC-USD,200,3,2021-11-10T21:00:02Z
+BTC-USD,300,1,2021-11-10T21:00:03Z
+ETH-USD,20,2,2021-11-10T21:00:04Z
+`
+
+func TestCompute(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "trades.csv")
+	if err := os.WriteFile(in, []byte(tradesCSV), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		rows        batch.Rows
+		calculators []vwap.Calculator
+		bands       []float64
+		want        []string
+	}{
+		{
+			name: "A row per trade",
+			rows: batch.TradeRows,
+			want: []string{
+				"product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands",
+				"BTC-USD,2,100.00,false,false,,vwap,,",
+				"ETH-USD,2,10.00,false,false,,vwap,,",
+				"BTC-USD,2,175.00,false,false,,vwap,,",
+				"BTC-USD,2,225.00,false,false,,vwap,,",
+				"ETH-USD,2,15.00,false,false,,vwap,,",
+			},
+		},
+		{
+			name: "A row per product",
+			rows: batch.ProductRows,
+			want: []string{
+				"product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands",
+				"BTC-USD,2,225.00,false,false,,vwap,,",
+				"ETH-USD,2,15.00,false,false,,vwap,,",
+			},
+		},
+		{
+			name:        "A row per product and metric",
+			rows:        batch.ProductRows,
+			calculators: []vwap.Calculator{vwap.VWAPCalculator, vwap.TWAPCalculator},
+			want: []string{
+				"product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands",
+				"BTC-USD,2,225.00,false,false,,vwap,,",
+				"BTC-USD,2,200.00,false,false,,twap,,",
+				"ETH-USD,2,15.00,false,false,,vwap,,",
+				"ETH-USD,2,10.00,false,false,,twap,,",
+			},
+		},
+		{
+			name:  "A row per product with bands",
+			rows:  batch.ProductRows,
+			bands: []float64{1, 2},
+			want: []string{
+				"product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands",
+				"BTC-USD,2,225.00,false,false,,vwap,43.30,1=181.70/268.30;2=138.40/311.60",
+				"ETH-USD,2,15.00,false,false,,vwap,5.00,1=10.00/20.00;2=5.00/25.00",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "vwap.csv")
+			cfg := cmd.Config{
+				WindowsSize:     2,
+				Calculators:     tt.calculators,
+				Bands:           tt.bands,
+				Precision:       128,
+				OutputPrecision: 2,
+				Compute: cmd.ComputeConfig{
+					In:  in,
+					Out: out,
+					Reader: batch.ReaderConfig{
+						Format:     batch.CSVInput,
+						Columns:    batch.DefaultColumns(),
+						TimeLayout: time.RFC3339,
+						Precision:  128,
+					},
+					OutFormat: sink.CSVFormat,
+					Rows:      tt.rows,
+				},
+			}
+
+			ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+			if err := Compute(ctx, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Join(tt.want, "\n") + "\n"; string(got) != want {
+				t.Errorf("Compute() wrote\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestCompute_MalformedTrade(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "trades.csv")
+	if err := os.WriteFile(in, []byte(tradesCSV+"BTC-USD,1e,1,2021-11-10T21:00:05Z\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := cmd.Config{
+		WindowsSize: 2,
+		Precision:   64,
+		Compute: cmd.ComputeConfig{
+			In:  in,
+			Out: filepath.Join(t.TempDir(), "vwap.csv"),
+			Reader: batch.ReaderConfig{
+				Columns:    batch.DefaultColumns(),
+				TimeLayout: time.RFC3339,
+				Precision:  64,
+			},
+			OutFormat: sink.CSVFormat,
+		},
+	}
+
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	err := Compute(ctx, cfg)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 7:") {
+		t.Errorf("Compute() error = %v, want the line 7 error", err)
+	}
+	if paths, _ := filepath.Glob(filepath.Join(filepath.Dir(cfg.Compute.Out), "*")); len(paths) > 0 {
+		t.Errorf("Compute() left the partial results %v", paths)
+	}
+}
+
+func TestCompute_SkipsMalformedTrades(t *testing.T) {
+	trades := strings.Replace(tradesCSV, "ETH-USD,10,2", "ETH-USD,ten,2", 1) + "BTC-USD,1e,1,2021-11-10T21:00:05Z\n"
+	in := filepath.Join(t.TempDir(), "trades.csv")
+	if err := os.WriteFile(in, []byte(trades), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		maxErrors int
+		wantErr   string
+	}{
+		{name: "Within the maximum", maxErrors: 2},
+		{name: "Skipping them all", maxErrors: -1},
+		{name: "Beyond the maximum", maxErrors: 1, wantErr: "line 7:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "vwap.csv")
+			cfg := cmd.Config{
+				WindowsSize:     2,
+				Precision:       64,
+				OutputPrecision: 2,
+				Compute: cmd.ComputeConfig{
+					In:  in,
+					Out: out,
+					Reader: batch.ReaderConfig{
+						Columns:    batch.DefaultColumns(),
+						TimeLayout: time.RFC3339,
+						Precision:  64,
+					},
+					OutFormat: sink.CSVFormat,
+					Rows:      batch.ProductRows,
+					MaxErrors: tt.maxErrors,
+				},
+			}
+
+			ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+			err := Compute(ctx, cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("Compute() error = %v, want the %s error", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Join([]string{
+				"product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands",
+				"BTC-USD,2,225.00,false,false,,vwap,,",
+				"ETH-USD,2,20.00,false,false,,vwap,,",
+			}, "\n") + "\n"
+			if string(got) != want {
+				t.Errorf("Compute() wrote\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestCompute_ConfigProducts(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "trades.csv")
+	if err := os.WriteFile(in, []byte(tradesCSV), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "vwap.csv")
+
+	cfg := cmd.Config{
+		WindowsSize:     2,
+		Precision:       64,
+		OutputPrecision: 2,
+		// a config file's product outside the product IDs keyed in lower
+		// case
+		Products: map[string]cmd.ProductConfig{
+			"eth-usd": {
+				Windows:         []vwap.WindowSpec{vwap.CountWindowSpec(1)},
+				Calculators:     []vwap.Calculator{vwap.VWAPCalculator},
+				OutputPrecision: 1,
+			},
+		},
+		Compute: cmd.ComputeConfig{
+			In:  in,
+			Out: out,
+			Reader: batch.ReaderConfig{
+				Columns:    batch.DefaultColumns(),
+				TimeLayout: time.RFC3339,
+				Precision:  64,
+			},
+			OutFormat: sink.CSVFormat,
+			Rows:      batch.ProductRows,
+		},
+	}
+
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	if err := Compute(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"product_id,window,vwap,suspect,session_close,venues,metric,stddev,bands",
+		"BTC-USD,2,225.00,false,false,,vwap,,",
+		"ETH-USD,1,20.0,false,false,,vwap,,",
+	}, "\n") + "\n"
+	if string(got) != want {
+		t.Errorf("Compute() wrote\n%s\nwant\n%s", got, want)
+	}
+}
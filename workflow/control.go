@@ -0,0 +1,173 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/log"
+	"go.uber.org/zap"
+)
+
+// catalog is the engine products' settings, added and removed at runtime.
+type catalog struct {
+	mu       sync.RWMutex
+	products map[string]cmd.ProductConfig
+	// generations number the runtime additions of each product, zero for
+	// the configured products
+	generations map[string]uint64
+	added       uint64
+}
+
+func newCatalog(products map[string]cmd.ProductConfig) *catalog {
+	return &catalog{
+		products:    products,
+		generations: make(map[string]uint64),
+	}
+}
+
+// get returns the product's settings and whether it is in the catalog.
+func (c *catalog) get(productID string) (cmd.ProductConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	product, ok := c.products[productID]
+	return product, ok
+}
+
+func (c *catalog) has(productID string) bool {
+	_, ok := c.get(productID)
+	return ok
+}
+
+// generation returns the product's generation and whether it is in the
+// catalog. A product added again after its removal is of a new generation.
+func (c *catalog) generation(productID string) (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.products[productID]
+	return c.generations[productID], ok
+}
+
+func (c *catalog) add(productID string, product cmd.ProductConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.products[productID] = product
+	c.added++
+	c.generations[productID] = c.added
+}
+
+func (c *catalog) remove(productID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.products, productID)
+}
+
+// productIDs returns the catalog's products ordered by product ID.
+func (c *catalog) productIDs() []string {
+	c.mu.RLock()
+	productIDs := make([]string, 0, len(c.products))
+	for p := range c.products {
+		productIDs = append(productIDs, p)
+	}
+	c.mu.RUnlock()
+	sort.Strings(productIDs)
+
+	return productIDs
+}
+
+// Subscribe adds the products at runtime: their windows are created and their
+// trades are subscribed on the live connection and again on every reconnect.
+// When streaming several venues, the product IDs are venue qualified i.e.
+// "binance:BTC-USDT". The subscribed products are skipped.
+func (c Client) Subscribe(ctx context.Context, productIDs []string) error {
+	for _, key := range productIDs {
+		f, productID, err := c.feedOf(key)
+		if err != nil {
+			return err
+		}
+		if c.products.has(key) {
+			continue
+		}
+
+		product := c.cfg.Product(key)
+		if err := addProduct(c.productsVwap, key, product); err != nil {
+			return err
+		}
+		c.products.add(key, product)
+		if c.api != nil {
+			c.api.SetPrecision(key, product.OutputPrecision)
+		}
+
+		if err := f.subscribe(ctx, productID); err != nil {
+			// so that the product may be subscribed again
+			c.products.remove(key)
+			if rerr := c.productsVwap.RemoveProduct(key); rerr != nil {
+				log.FromContext(ctx).Warn("Failed to remove the unsubscribed product", zap.String("productID", key), zap.Error(rerr))
+			}
+			return err
+		}
+		log.FromContext(ctx).Info("Subscribed product", zap.String("productID", key))
+	}
+
+	return nil
+}
+
+// Unsubscribe removes the products at runtime: their trades are unsubscribed
+// on the live connection and their windows are dropped. The products'
+// trades still in flight are dropped.
+func (c Client) Unsubscribe(ctx context.Context, productIDs []string) error {
+	for _, key := range productIDs {
+		f, productID, err := c.feedOf(key)
+		if err != nil {
+			return err
+		}
+		product, ok := c.products.get(key)
+		if !ok {
+			return fmt.Errorf("product ID %s is not subscribed", key)
+		}
+
+		// the engine keeps the consolidated products
+		if err := c.productsVwap.RemoveProduct(key); err != nil {
+			return err
+		}
+		c.products.remove(key)
+		c.metrics.removeProduct(key, product.Windows)
+
+		if err := f.unsubscribe(ctx, productID); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Unsubscribed product", zap.String("productID", key))
+	}
+
+	return nil
+}
+
+// Products returns the engine's products ordered by product ID.
+func (c Client) Products() []string {
+	return c.products.productIDs()
+}
+
+// feedOf returns the feed of the product and its feed's product ID.
+func (c Client) feedOf(key string) (*feed, string, error) {
+	if key == "" {
+		return nil, "", fmt.Errorf("empty product ID")
+	}
+	if len(c.feeds) == 1 && c.feeds[0].venue == "" {
+		return c.feeds[0], key, nil
+	}
+
+	for _, f := range c.feeds {
+		if productID := strings.TrimPrefix(key, f.venue+":"); productID != key && productID != "" {
+			return f, productID, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("product ID %s is not venue qualified i.e. coinbase:BTC-USD", key)
+}
@@ -0,0 +1,162 @@
+package workflow
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// produceBuckets are the trade's produce latency buckets in seconds from 10µs
+// to 1s.
+var produceBuckets = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .1, 1}
+
+// pipelineMetrics are the health metrics of the ingestion, the workers and
+// the results.
+type pipelineMetrics struct {
+	registry *prometheus.Registry
+
+	trades        *prometheus.CounterVec
+	parseFailures *prometheus.CounterVec
+	unknownMsgs   *prometheus.CounterVec
+	reconnects    prometheus.Counter
+	produce       *prometheus.HistogramVec
+	workerBusy    *prometheus.CounterVec
+	vwap          *prometheus.GaugeVec
+}
+
+// newPipelineMetrics registers the pipeline metrics. The queues depths are
+// sampled on each scrape.
+func newPipelineMetrics(qs []chan *types.TradeValue, productsVwap vwap.Engine) *pipelineMetrics {
+	r := prometheus.NewRegistry()
+	factory := promauto.With(r)
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "vwap_trades_queue_depth",
+		Help: "Trades queued to the workers.",
+	}, func() float64 {
+		depth := 0
+		for _, q := range qs {
+			depth += len(q)
+		}
+		return float64(depth)
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "vwap_results_queue_depth",
+		Help: "VWAP results queued to the sinks.",
+	}, func() float64 {
+		return float64(len(productsVwap.GetResultsQ()))
+	})
+
+	return &pipelineMetrics{
+		registry: r,
+		trades: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "vwap_trades_ingested_total",
+			Help: "Trades ingested by product.",
+		}, []string{"product_id"}),
+		parseFailures: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "vwap_parse_failures_total",
+			Help: "Messages failing to parse by field.",
+		}, []string{"field"}),
+		unknownMsgs: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "vwap_unknown_messages_total",
+			Help: "Socket messages of unknown type by their known type or other.",
+		}, []string{"type"}),
+		reconnects: factory.NewCounter(prometheus.CounterOpts{
+			Name: "vwap_reconnects_total",
+			Help: "Socket redials after dropping.",
+		}),
+		produce: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "vwap_produce_seconds",
+			Help:    "Latency of producing a trade's VWAP results by product.",
+			Buckets: produceBuckets,
+		}, []string{"product_id"}),
+		workerBusy: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "vwap_worker_busy_seconds_total",
+			Help: "Time each worker spent producing VWAP results. Its rate is the worker's utilization.",
+		}, []string{"worker"}),
+		vwap: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "vwap_current",
+			Help: "The latest VWAP by product and window.",
+		}, []string{"product_id", "window"}),
+	}
+}
+
+// handler serves the scrapes of the metrics.
+func (m *pipelineMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// unknownMsgTypes are the feeds' known message types of the unknown messages
+// counts by type. The rest count as other, so that a feed's arbitrary types
+// do not add series without bound.
+var unknownMsgTypes = map[string]bool{
+	// Coinbase
+	"heartbeat": true,
+	"ticker":    true,
+	"status":    true,
+	"snapshot":  true,
+	"l2update":  true,
+	"received":  true,
+	"open":      true,
+	"done":      true,
+	"change":    true,
+	"activate":  true,
+	// Binance
+	"aggTrade":       true,
+	"kline":          true,
+	"24hrTicker":     true,
+	"24hrMiniTicker": true,
+	"depthUpdate":    true,
+	"bookTicker":     true,
+}
+
+// unknownMsg counts the unknown message by its type if known or as other.
+func (m *pipelineMetrics) unknownMsg(msgType string) {
+	if !unknownMsgTypes[msgType] {
+		msgType = "other"
+	}
+	m.unknownMsgs.WithLabelValues(msgType).Inc()
+}
+
+// removeProduct deletes the series of the product and its windows, so that an
+// unsubscribed product is not scraped.
+func (m *pipelineMetrics) removeProduct(productID string, windows []vwap.WindowSpec) {
+	m.trades.DeleteLabelValues(productID)
+	m.produce.DeleteLabelValues(productID)
+	for _, window := range windows {
+		m.vwap.DeleteLabelValues(productID, window.String())
+	}
+}
+
+// parseFailed counts the failure by the erred field or by the fallback one when
+// err is not a field error.
+func (m *pipelineMetrics) parseFailed(err error, fallback string) {
+	field := fallback
+	var fieldErr *types.FieldError
+	if errors.As(err, &fieldErr) {
+		field = fieldErr.Field
+	}
+	m.parseFailures.WithLabelValues(field).Inc()
+}
+
+// produced observes the latency of producing the product's trade by the worker.
+func (m *pipelineMetrics) produced(worker, productID string, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	m.produce.WithLabelValues(productID).Observe(seconds)
+	m.workerBusy.WithLabelValues(worker).Add(seconds)
+}
+
+// result sets the current VWAP gauge of the VWAP result's product and window.
+func (m *pipelineMetrics) result(res *types.VWAPResult) {
+	if res.Metric != types.VWAPMetric {
+		return
+	}
+	value, _ := res.Vwap.Float64()
+	m.vwap.WithLabelValues(res.ProductID, res.Window).Set(value)
+}
@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/types"
+	"github.com/blewater/zh/vwap"
+)
+
+func TestPipelineMetrics(t *testing.T) {
+	qs := []chan *types.TradeValue{make(chan *types.TradeValue, 2), make(chan *types.TradeValue, 2)}
+	qs[0] <- &types.TradeValue{}
+	qs[1] <- &types.TradeValue{}
+	m := newPipelineMetrics(qs, vwap.New([]string{"BTC-USD"}, 1))
+
+	_, err := types.ParseSequence([]byte(`{"type":"match"}`))
+	m.parseFailed(err, "sequence")
+	err = types.ParseBigPrice([]byte(`{"price":"x"}`), new(big.Float))
+	m.parseFailed(err, "size")
+	m.parseFailed(errors.New("untyped"), "type")
+	m.produced("1", "BTC-USD", time.Millisecond)
+	m.result(&types.VWAPResult{ProductID: "BTC-USD", Window: "200", Vwap: big.NewFloat(1.5)})
+	m.reconnects.Inc()
+	m.unknownMsg("heartbeat")
+	m.unknownMsg("aggTrade")
+	m.unknownMsg("x-1")
+	m.unknownMsg("x-2")
+	m.unknownMsg("")
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"vwap_trades_queue_depth 2\n",
+		"vwap_results_queue_depth 0\n",
+		`vwap_parse_failures_total{field="sequence"} 1` + "\n",
+		`vwap_parse_failures_total{field="price"} 1` + "\n",
+		`vwap_parse_failures_total{field="type"} 1` + "\n",
+		`vwap_produce_seconds_count{product_id="BTC-USD"} 1` + "\n",
+		`vwap_worker_busy_seconds_total{worker="1"} 0.001` + "\n",
+		`vwap_current{product_id="BTC-USD",window="200"} 1.5` + "\n",
+		"vwap_reconnects_total 1\n",
+		`vwap_unknown_messages_total{type="heartbeat"} 1` + "\n",
+		`vwap_unknown_messages_total{type="aggTrade"} 1` + "\n",
+		`vwap_unknown_messages_total{type="other"} 3` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q in\n%s", want, body)
+		}
+	}
+
+	// an unsubscribed product's series are dropped
+	m.trades.WithLabelValues("BTC-USD").Inc()
+	m.removeProduct("BTC-USD", []vwap.WindowSpec{vwap.CountWindowSpec(200)})
+	rec = httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if body := rec.Body.String(); strings.Contains(body, `product_id="BTC-USD"`) {
+		t.Errorf("metrics of the removed product remain in\n%s", body)
+	}
+}
@@ -2,6 +2,8 @@ package workflow
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"github.com/blewater/zh/log"
 	"github.com/blewater/zh/types"
@@ -10,7 +12,9 @@ import (
 )
 
 // StartPool starts the configured number of go routines to crunch VWAP results
-// streaming off the client queue.
+// streaming off the client queues. Each worker drains its own queue, so a
+// product's trades are produced in order by a single worker and the product's
+// last VWAP result is that of its latest window.
 func (c Client) StartPool(ctx context.Context) error {
 	logger := log.FromContext(ctx)
 	// nolint:errcheck
@@ -18,18 +22,38 @@ func (c Client) StartPool(ctx context.Context) error {
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	var w uint16
-	for w = 1; w <= c.cfg.WorkerPoolSize; w++ {
+	// the engine's timers i.e. evicting the expired trades of time windows
+	g.Go(
+		func() error {
+			c.productsVwap.Run(ctx)
+			return nil
+		},
+	)
+
+	c.startWorkers(ctx, g)
+
+	return g.Wait()
+}
+
+// startWorkers starts a go routine per queue producing the VWAP results of
+// its trades until the queue is closed.
+func (c Client) startWorkers(ctx context.Context, g *errgroup.Group) {
+	logger := log.FromContext(ctx)
+
+	for i, q := range c.qs {
 		// localize to avoid capture
-		w := w
+		w, q := uint16(i+1), q
+		worker := strconv.Itoa(int(w))
 		g.Go(
 			func() error {
-				for tradeValue := range c.q {
-					if err := c.productsVwap.ProduceVwap(ctx,
-						tradeValue.ProductID,
-						tradeValue.Price,
-						tradeValue.Size,
-					); err != nil {
+				for tradeValue := range q {
+					if tradeValue.Gap {
+						c.applyGapPolicy(ctx, tradeValue.ProductID)
+					}
+					start := time.Now()
+					err := c.productsVwap.ProduceTrade(ctx, tradeValue)
+					c.metrics.produced(worker, tradeValue.ProductID, time.Since(start))
+					if err != nil {
 						logger.Error(tradeValue.ProductID, zap.Error(err))
 					}
 
@@ -51,6 +75,4 @@ func (c Client) StartPool(ctx context.Context) error {
 			},
 		)
 	}
-
-	return g.Wait()
 }
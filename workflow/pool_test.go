@@ -0,0 +1,103 @@
+package workflow
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+// TestClient_StartPool_PerProductOrder streams interleaved trades of several
+// products through the pool. With a single trade window each VWAP result is
+// its trade's price which is its sequence, so each product's results must
+// arrive in sequence order.
+func TestClient_StartPool_PerProductOrder(t *testing.T) {
+	const tradesPerProduct = 5000
+	products := []string{"BTC-USD", "USDC-EUR", "ETH-BTC", "ETH-EUR", "BTC-EUR", "ETH-USD", "LTC-USD"}
+
+	c, err := New(cmd.Config{
+		WorkerPoolSize: 4,
+		WindowsSize:    1,
+		ProductIDs:     products,
+		Precision:      64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	defer cancel()
+	// nolint:errcheck
+	go c.StartPool(ctx)
+
+	go func() {
+		for seq := int64(1); seq <= tradesPerProduct; seq++ {
+			for _, p := range products {
+				tradeValue := getMemPoolTradeVal(64, big.ToNearestEven)
+				tradeValue.ProductID = p
+				tradeValue.Sequence = seq
+				tradeValue.Time = time.Now()
+				tradeValue.Price.SetInt64(seq)
+				tradeValue.Size.SetInt64(1)
+				c.GetTradesQConsumer(p) <- tradeValue
+			}
+		}
+	}()
+
+	last := make(map[string]int64, len(products))
+	timeout := time.After(30 * time.Second)
+	for i := 0; i < tradesPerProduct*len(products); i++ {
+		var res *types.VWAPResult
+		select {
+		case res = <-c.productsVwap.GetResultsQ():
+		case <-timeout:
+			t.Fatalf("received %d of %d results", i, tradesPerProduct*len(products))
+		}
+
+		seq, _ := res.Vwap.Int64()
+		if seq != last[res.ProductID]+1 {
+			t.Fatalf("%s result of sequence %d after %d", res.ProductID, seq, last[res.ProductID])
+		}
+		last[res.ProductID] = seq
+		types.VWAPResultMemPool.Put(res)
+	}
+}
+
+func TestClient_Worker(t *testing.T) {
+	c, err := New(cmd.Config{
+		WorkerPoolSize: 2,
+		WindowsSize:    1,
+		ProductIDs:     []string{"BTC-USD", "ETH-USD", "ETH-BTC"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		productID string
+		want      int
+	}{
+		{"BTC-USD", 0},
+		{"ETH-USD", 1},
+		{"ETH-BTC", 0},
+	}
+	for _, tt := range tests {
+		if got := c.worker(tt.productID); got != tt.want {
+			t.Errorf("worker(%s) = %d, want %d", tt.productID, got, tt.want)
+		}
+	}
+
+	// an unconfigured product sticks to a worker
+	if w := c.worker("LTC-USD"); w != c.worker("LTC-USD") || w < 0 || w > 1 {
+		t.Errorf("worker(LTC-USD) = %d, want a stable worker in [0, 1]", w)
+	}
+
+	if _, err := New(cmd.Config{WindowsSize: 1}); err == nil {
+		t.Errorf("New() expected an error for an empty pool")
+	}
+}
@@ -0,0 +1,158 @@
+package workflow
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/blewater/zh/capture"
+	"github.com/blewater/zh/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// Replay drives the pipeline off the capture files' messages in order instead
+// of the socket. The messages are paced by their receive times sped up by
+// speed i.e. 1 for the original speed and 10 for 10x, or else streamed as fast
+// as possible when speed is 0. The engine's timers do not run during a
+// replay, so that the results depend on the recorded trades alone. It returns
+// once every result is written to the sinks.
+func (c *Client) Replay(ctx context.Context, paths []string, speed float64) error {
+	logger := log.FromContext(ctx)
+	c.sinks.Start(ctx)
+
+	g, poolCtx := errgroup.WithContext(ctx)
+	c.startWorkers(poolCtx, g)
+
+	workersDone := make(chan struct{})
+	resultsDone := make(chan struct{})
+	go func() {
+		defer close(resultsDone)
+		c.writeResultsUntil(ctx, workersDone)
+	}()
+
+	err := c.replayFiles(ctx, paths, &pacer{speed: speed})
+
+	// the workers drain their queues before quitting
+	for _, q := range c.qs {
+		close(q)
+	}
+	g.Wait()
+	close(workersDone)
+	<-resultsDone
+
+	if cerr := c.sinks.Close(); cerr != nil {
+		logger.Error("closing the sinks erred", zap.Error(cerr))
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// writeResultsUntil writes the results to the sinks, and keeps the closed
+// candles, until the workers are done and their last results are written.
+func (c *Client) writeResultsUntil(ctx context.Context, workersDone <-chan struct{}) {
+	for {
+		select {
+		case res := <-c.productsVwap.GetResultsQ():
+			c.writeResult(ctx, res)
+		case candle := <-c.candlesQ:
+			c.writeCandle(candle)
+		case <-workersDone:
+			for {
+				select {
+				case res := <-c.productsVwap.GetResultsQ():
+					c.writeResult(ctx, res)
+				case candle := <-c.candlesQ:
+					c.writeCandle(candle)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// replayFiles ingests the capture files' messages until the last one or ctx
+// is cancelled.
+func (c *Client) replayFiles(ctx context.Context, paths []string, p *pacer) error {
+	logger := log.FromContext(ctx)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		replayed, err := c.replayFile(ctx, f, p)
+		f.Close()
+		logger.Info("Replayed", zap.String("path", path), zap.Int("messages", replayed))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replayFile ingests the capture's messages returning their number.
+func (c *Client) replayFile(ctx context.Context, r io.Reader, p *pacer) (int, error) {
+	cr, err := capture.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	for replayed := 0; ; replayed++ {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		if err := p.wait(ctx, rec.Received); err != nil {
+			return replayed, err
+		}
+		c.ingestMessage(ctx, c.feeds[0], rec.Msg)
+	}
+}
+
+// pacer delays the replayed messages to their receive times' offsets from the
+// first message divided by speed.
+type pacer struct {
+	speed float64
+	// first is the receive time of the first message replayed at start
+	first time.Time
+	start time.Time
+}
+
+// wait waits till the message received at the time is due or ctx is
+// cancelled.
+func (p *pacer) wait(ctx context.Context, received time.Time) error {
+	if p.speed == 0 {
+		return ctx.Err()
+	}
+	if p.start.IsZero() {
+		p.first, p.start = received, time.Now()
+		return ctx.Err()
+	}
+
+	due := p.start.Add(time.Duration(float64(received.Sub(p.first)) / p.speed))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
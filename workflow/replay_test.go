@@ -0,0 +1,152 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blewater/zh/capture"
+	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/sink"
+	"github.com/blewater/zh/types"
+	"go.uber.org/zap"
+)
+
+func matchMsg(productID string, sequence int64, price, size string) []byte {
+	return []byte(fmt.Sprintf(
+		`{"type":"match","trade_id":%d,"maker_order_id":"a","taker_order_id":"b","side":"buy","size":"%s","price":"%s","product_id":"%s","sequence":%d,"time":"2021-10-17T12:00:%02d.000000Z"}`,
+		sequence, size, price, productID, sequence, sequence,
+	))
+}
+
+// writeCapture writes the messages received a millisecond apart to a capture
+// file.
+func writeCapture(t *testing.T, msgs [][]byte, step time.Duration) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "feed.vcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := capture.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1634472000, 0)
+	for i, msg := range msgs {
+		if _, err := w.Write(capture.Record{Received: t0.Add(time.Duration(i) * step), Msg: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+// replay replays the capture files returning each product's JSON Lines
+// results.
+func replay(t *testing.T, paths []string, speed float64) map[string][]string {
+	t.Helper()
+
+	c, err := New(cmd.Config{
+		WorkerPoolSize:  3,
+		WindowsSize:     2,
+		ProductIDs:      []string{"BTC-USD", "ETH-USD"},
+		Precision:       64,
+		GapPolicy:       types.GapPolicySuspect,
+		OutputPrecision: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	jsonl, err := sink.NewWriterSink(sink.JSONLinesFormat, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.sinks = sink.NewFanout(1024, jsonl)
+
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	if err := c.Replay(ctx, paths, speed); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		for _, p := range []string{"BTC-USD", "ETH-USD"} {
+			if strings.Contains(line, p) {
+				results[p] = append(results[p], line)
+			}
+		}
+	}
+
+	return results
+}
+
+func TestClient_Replay(t *testing.T) {
+	msgs := [][]byte{
+		[]byte(`{"type":"subscriptions","channels":[{"name":"matches","product_ids":["BTC-USD","ETH-USD"]}]}`),
+		matchMsg("BTC-USD", 1, "10", "1"),
+		matchMsg("ETH-USD", 1, "100", "2"),
+		matchMsg("BTC-USD", 2, "20", "1"),
+		// malformed frame
+		[]byte(`{"type":"match","product_id":"BTC-USD","pri`),
+		// duplicate
+		matchMsg("BTC-USD", 2, "20", "1"),
+		matchMsg("ETH-USD", 2, "200", "2"),
+		// gap
+		matchMsg("BTC-USD", 4, "30", "1"),
+	}
+	paths := []string{
+		writeCapture(t, msgs[:4], time.Millisecond),
+		writeCapture(t, msgs[4:], time.Millisecond),
+	}
+
+	want := map[string][]string{
+		"BTC-USD": {
+			`{"product_id":"BTC-USD","window":"2","metric":"vwap","vwap":"10.00","suspect":false,"session_close":false}`,
+			`{"product_id":"BTC-USD","window":"2","metric":"vwap","vwap":"15.00","suspect":false,"session_close":false}`,
+			`{"product_id":"BTC-USD","window":"2","metric":"vwap","vwap":"25.00","suspect":true,"session_close":false}`,
+		},
+		"ETH-USD": {
+			`{"product_id":"ETH-USD","window":"2","metric":"vwap","vwap":"100.00","suspect":false,"session_close":false}`,
+			`{"product_id":"ETH-USD","window":"2","metric":"vwap","vwap":"150.00","suspect":false,"session_close":false}`,
+		},
+	}
+
+	// the results are deterministic across replays
+	for i := 0; i < 3; i++ {
+		got := replay(t, paths, 0)
+		for p, lines := range want {
+			if strings.Join(got[p], "\n") != strings.Join(lines, "\n") {
+				t.Fatalf("replay %d %s results =\n%s\nwant\n%s", i, p, strings.Join(got[p], "\n"), strings.Join(lines, "\n"))
+			}
+		}
+	}
+}
+
+func TestClient_Replay_Speed(t *testing.T) {
+	msgs := [][]byte{
+		matchMsg("BTC-USD", 1, "10", "1"),
+		matchMsg("BTC-USD", 2, "20", "1"),
+		matchMsg("BTC-USD", 3, "30", "1"),
+	}
+	// 2 seconds recorded replayed 40x in 50ms
+	paths := []string{writeCapture(t, msgs, time.Second)}
+
+	start := time.Now()
+	if got := replay(t, paths, 40)["BTC-USD"]; len(got) != len(msgs) {
+		t.Fatalf("replayed %d results, want %d", len(got), len(msgs))
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("replay at 40x took %v, want about 50ms", elapsed)
+	}
+}
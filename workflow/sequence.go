@@ -0,0 +1,76 @@
+package workflow
+
+import "github.com/blewater/zh/types"
+
+// sequenceTracker follows the last matches sequence number of each product to
+// detect gaps, duplicates and regressions. It is confined to its feed's trades
+// ingestion go routine and outlives reconnects.
+type sequenceTracker struct {
+	last map[string]int64
+	// generations are the products' catalog generations of their baselines
+	generations map[string]uint64
+}
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{
+		last:        make(map[string]int64),
+		generations: make(map[string]uint64),
+	}
+}
+
+// subscribed forgets the product's baseline when the product's catalog
+// generation changed since i.e. the product was unsubscribed and subscribed
+// again at runtime, so that its first trade is not taken for a gap.
+func (s *sequenceTracker) subscribed(productID string, generation uint64) {
+	if s.generations[productID] != generation {
+		s.forget(productID)
+		s.generations[productID] = generation
+	}
+}
+
+// forget drops the product's baseline.
+func (s *sequenceTracker) forget(productID string) {
+	delete(s.last, productID)
+}
+
+// observe records the product's sequence number and returns the discontinuity
+// event if any. A regression is taken as the new baseline i.e. a restarted
+// feed, while a duplicate leaves the baseline as is.
+func (s *sequenceTracker) observe(productID string, sequence int64) (types.SequenceEvent, bool) {
+	last, seen := s.last[productID]
+	if !seen {
+		s.last[productID] = sequence
+		return types.SequenceEvent{}, false
+	}
+
+	event := types.SequenceEvent{
+		ProductID: productID,
+		Last:      last,
+		Sequence:  sequence,
+	}
+
+	switch {
+	case sequence == last+1:
+		s.last[productID] = sequence
+		return types.SequenceEvent{}, false
+	case sequence == last:
+		event.Kind = types.SequenceDuplicate
+	case sequence < last:
+		event.Kind = types.SequenceRegression
+		s.last[productID] = sequence
+	default:
+		event.Kind = types.SequenceGap
+		s.last[productID] = sequence
+	}
+
+	return event, true
+}
+
+// seed takes the product's sequence number as its baseline unless one was
+// observed i.e. the restored windows' last sequence number, so that the
+// trades missed in between are detected as a gap.
+func (s *sequenceTracker) seed(productID string, sequence int64) {
+	if _, seen := s.last[productID]; !seen {
+		s.last[productID] = sequence
+	}
+}
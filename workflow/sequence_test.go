@@ -0,0 +1,94 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/blewater/zh/types"
+)
+
+func TestSequenceTracker_Subscribed(t *testing.T) {
+	s := newSequenceTracker()
+	// the configured products' restored baseline stays
+	s.seed("BTC-USD", 10)
+	s.subscribed("BTC-USD", 0)
+	if event, ok := s.observe("BTC-USD", 13); !ok || event.Kind != types.SequenceGap {
+		t.Errorf("observe() = %+v, %t, want a gap", event, ok)
+	}
+
+	// subscribed again at runtime
+	s.subscribed("BTC-USD", 1)
+	if event, ok := s.observe("BTC-USD", 500); ok {
+		t.Errorf("observe() = %+v after subscribing again, want a new baseline", event)
+	}
+	s.subscribed("BTC-USD", 1)
+	if _, ok := s.observe("BTC-USD", 501); ok {
+		t.Error("observe() the next sequence expected no event")
+	}
+}
+
+func TestSequenceTracker_Observe(t *testing.T) {
+	type observation struct {
+		productID string
+		sequence  int64
+		want      types.SequenceEventKind
+	}
+	tests := []struct {
+		name         string
+		observations []observation
+	}{
+		{
+			name: "In sequence",
+			observations: []observation{
+				{"BTC-USD", 10, 0},
+				{"BTC-USD", 11, 0},
+				{"BTC-USD", 12, 0},
+			},
+		},
+		{
+			name: "Per product sequences",
+			observations: []observation{
+				{"BTC-USD", 10, 0},
+				{"ETH-USD", 500, 0},
+				{"BTC-USD", 11, 0},
+				{"ETH-USD", 501, 0},
+			},
+		},
+		{
+			name: "Gap",
+			observations: []observation{
+				{"BTC-USD", 10, 0},
+				{"BTC-USD", 13, types.SequenceGap},
+				{"BTC-USD", 14, 0},
+			},
+		},
+		{
+			name: "Duplicate",
+			observations: []observation{
+				{"BTC-USD", 10, 0},
+				{"BTC-USD", 10, types.SequenceDuplicate},
+				{"BTC-USD", 11, 0},
+			},
+		},
+		{
+			name: "Regression rebases",
+			observations: []observation{
+				{"BTC-USD", 10, 0},
+				{"BTC-USD", 2, types.SequenceRegression},
+				{"BTC-USD", 3, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				s := newSequenceTracker()
+				for i, o := range tt.observations {
+					event, ok := s.observe(o.productID, o.sequence)
+					if ok != (o.want != 0) || event.Kind != o.want {
+						t.Errorf("observe() #%d = %v, %v, want %v", i, event.Kind, ok, o.want)
+					}
+				}
+			},
+		)
+	}
+}
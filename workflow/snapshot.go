@@ -0,0 +1,75 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/blewater/zh/log"
+	"github.com/blewater/zh/vwap"
+	"go.uber.org/zap"
+)
+
+// restoreSnapshot refills the engine's windows off the snapshot file unless
+// missing, invalid or older than the configured max age in which case the
+// windows start empty. The restored products' last sequence numbers become
+// their feeds' baselines, so that the trades missed while down are flagged
+// as a gap.
+func (c *Client) restoreSnapshot(ctx context.Context) {
+	logger := log.FromContext(ctx).With(zap.String("snapshot", c.cfg.Snapshot.Path))
+
+	state, err := vwap.ReadState(c.cfg.Snapshot.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Info("No windows snapshot to restore")
+		return
+	case err != nil:
+		logger.Error("Discarding the windows snapshot", zap.Error(err))
+		return
+	case state.Stale(time.Now(), c.cfg.Snapshot.MaxAge):
+		logger.Warn("Discarding the stale windows snapshot", zap.Time("taken", state.Taken))
+		return
+	}
+
+	if err := c.persister.Restore(state); err != nil {
+		logger.Error("Discarding the windows snapshot", zap.Error(err))
+		return
+	}
+	for productID, sequence := range state.Sequences() {
+		if f, _, err := c.feedOf(productID); err == nil {
+			f.seqs.seed(productID, sequence)
+		}
+	}
+
+	logger.Info("Restored the windows snapshot",
+		zap.Time("taken", state.Taken),
+		zap.Int("products", len(state.Products)),
+	)
+}
+
+// runSnapshots saves the engine's windows to the snapshot file every
+// configured interval until ctx is cancelled.
+func (c *Client) runSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Snapshot.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.saveSnapshot(log.FromContext(ctx), now)
+		}
+	}
+}
+
+// saveSnapshot saves the engine's windows as of now to the snapshot file.
+func (c *Client) saveSnapshot(logger *zap.Logger, now time.Time) {
+	if err := vwap.WriteState(c.cfg.Snapshot.Path, c.persister.State(now)); err != nil {
+		logger.Error("Saving the windows snapshot erred", zap.Error(err))
+		return
+	}
+
+	logger.Debug("Saved the windows snapshot", zap.String("snapshot", c.cfg.Snapshot.Path))
+}
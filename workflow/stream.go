@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/blewater/zh/api"
+	"github.com/blewater/zh/capture"
 	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/exchange"
 	"github.com/blewater/zh/log"
 	"github.com/blewater/zh/server"
+	"github.com/blewater/zh/sink"
 	"github.com/blewater/zh/types"
 	"github.com/blewater/zh/vwap"
 	"github.com/gorilla/websocket"
@@ -19,169 +25,763 @@ import (
 
 // Client to socket listen -> ingestTradesStream trades
 type Client struct {
+	// reconnects counts the socket redials across the client's copies
+	reconnects *atomic.Uint64
+
 	cfg cmd.Config
 
-	productsVwap *vwap.ProductsVwap
+	// Each product's settings
+	products *catalog
 
-	// Inbound messages to be processed
-	q chan *types.TradeValue
+	// Outbound VWAP results
+	sinks *sink.Fanout
 
-	// Inbound connection
-	conn *websocket.Conn
+	// The optional HTTP API
+	api *api.Server
+
+	// The trade streams, one per venue
+	feeds []*feed
+
+	// The optional raw messages recording
+	recorder *capture.Recorder
+
+	productsVwap vwap.Engine
+
+	// The optional windows snapshots of the engine
+	persister vwap.Persister
+
+	// The optional closed candles of the engine and their recent ones served
+	// by the HTTP API
+	candlesQ <-chan *types.Candle
+	candles  *api.Candles
+
+	// Inbound messages to be processed, one queue per worker
+	qs []chan *types.TradeValue
+
+	// routes assigns each configured product to its worker's queue
+	routes map[string]int
+
+	// The pipeline health metrics
+	metrics *pipelineMetrics
 }
 
-func New(cfg cmd.Config) Client {
-	return Client{
-		q:            make(types.TradesQ, cfg.WorkerPoolSize),
+func New(cfg cmd.Config) (Client, error) {
+	if cfg.WorkerPoolSize == 0 {
+		return Client{}, fmt.Errorf("the workers pool size must be positive")
+	}
+
+	productsVwap, err := vwap.NewPerProductEngine(cfg.Engine, cfg.ProductWindows(), cfg.ProductScales(), cfg.FixedScale)
+	if err != nil {
+		return Client{}, err
+	}
+	if err := consolidate(productsVwap, cfg.Consolidated); err != nil {
+		return Client{}, err
+	}
+
+	var persister vwap.Persister
+	if cfg.Snapshot.Path != "" {
+		var ok bool
+		if persister, ok = productsVwap.(vwap.Persister); !ok {
+			return Client{}, fmt.Errorf("the VWAP engine does not snapshot its windows")
+		}
+	}
+
+	var candlesQ <-chan *types.Candle
+	if len(cfg.Candles) > 0 {
+		candler, ok := productsVwap.(vwap.Candler)
+		if !ok {
+			return Client{}, fmt.Errorf("the VWAP engine does not aggregate candles")
+		}
+		if err := candler.Candles(cfg.Candles); err != nil {
+			return Client{}, err
+		}
+		candlesQ = candler.GetCandlesQ()
+	}
+
+	productIDs := cfg.EngineProductIDs()
+	products := make(map[string]cmd.ProductConfig, len(productIDs))
+	for _, p := range productIDs {
+		products[p] = cfg.Product(p)
+		if err := configureProduct(productsVwap, p, products[p]); err != nil {
+			return Client{}, err
+		}
+	}
+
+	sinks := make([]sink.Sink, len(cfg.Sinks))
+	for i, format := range cfg.Sinks {
+		if sinks[i], err = sink.NewWriterSink(format, os.Stdout); err != nil {
+			return Client{}, err
+		}
+	}
+
+	feeds, err := newFeeds(cfg)
+	if err != nil {
+		return Client{}, err
+	}
+
+	var recorder *capture.Recorder
+	if cfg.Record.Path != "" {
+		if recorder, err = capture.NewRecorder(cfg.Record); err != nil {
+			return Client{}, err
+		}
+	}
+
+	qs := make([]chan *types.TradeValue, cfg.WorkerPoolSize)
+	for w := range qs {
+		qs[w] = make(chan *types.TradeValue, workerQueueLen)
+	}
+	pipelineMetrics := newPipelineMetrics(qs, productsVwap)
+
+	var apiServer *api.Server
+	var candles *api.Candles
+	if cfg.HTTPAddr != "" {
+		history := api.NewHistory(cfg.HistoryLen)
+		sinks = append(sinks, history)
+
+		precisions := make(map[string]int, len(products))
+		for p, product := range products {
+			precisions[p] = product.OutputPrecision
+		}
+		apiServer = api.New(cfg.HTTPAddr, productsVwap, history, precisions)
+		sinks = append(sinks, apiServer.WebSocket(cfg.WSSendLen, cfg.WSOrigins))
+		apiServer.Metrics(pipelineMetrics.handler())
+		if candlesQ != nil {
+			candles = api.NewCandles(cfg.Candles, cfg.CandleHistoryLen)
+			apiServer.Candles(candles)
+		}
+	}
+
+	// spread the products evenly across the workers
+	routes := make(map[string]int, len(productIDs))
+	for i, p := range productIDs {
+		routes[p] = i % len(qs)
+	}
+
+	c := Client{
+		reconnects:   new(atomic.Uint64),
+		qs:           qs,
+		routes:       routes,
+		sinks:        sink.NewFanout(cfg.SinkBufferLen, sinks...),
+		api:          apiServer,
+		feeds:        feeds,
+		recorder:     recorder,
 		cfg:          cfg,
-		productsVwap: vwap.New(cfg.ProductIDs, cfg.WindowsSize),
+		products:     newCatalog(products),
+		productsVwap: productsVwap,
+		persister:    persister,
+		candlesQ:     candlesQ,
+		candles:      candles,
+		metrics:      pipelineMetrics,
 	}
+	if apiServer != nil && cfg.Control {
+		// the client's copy shares the products, feeds and engine
+		apiServer.Control(c)
+	}
+
+	return c, nil
 }
 
-// GetTradesQConsumer returns a trades queue consumer that receives
-// trade values
-func (c Client) GetTradesQConsumer() types.TradesQConsumer {
-	return c.q
+// feed is an exchange's trade stream.
+type feed struct {
+	exchange exchange.Exchange
+	url      string
+	// venue names the feed when streaming several venues, empty otherwise
+	venue string
+	// keys are the venue qualified product IDs of the configured products
+	keys map[string]string
+	// seqs are the products' matches sequence numbers
+	seqs *sequenceTracker
+
+	// mu guards the subscribed products and serializes the writes to the
+	// live connection
+	mu         sync.Mutex
+	productIDs []string
+	// conn is the live connection, nil while redialing
+	conn *websocket.Conn
+}
+
+// newFeeds returns the configured venues' feeds or else the single exchange's
+// feed.
+func newFeeds(cfg cmd.Config) ([]*feed, error) {
+	if len(cfg.Venues) == 0 {
+		ex, err := exchange.New(cfg.Exchange, cfg.ProductIDs)
+		if err != nil {
+			return nil, err
+		}
+		return []*feed{{
+			exchange:   ex,
+			url:        cfg.SocketURL,
+			productIDs: append([]string(nil), cfg.ProductIDs...),
+			seqs:       newSequenceTracker(),
+		}}, nil
+	}
+
+	feeds := make([]*feed, len(cfg.Venues))
+	for i, venue := range cfg.Venues {
+		ex, err := exchange.New(venue.Exchange, venue.ProductIDs)
+		if err != nil {
+			return nil, err
+		}
+		keys := make(map[string]string, len(venue.ProductIDs))
+		for _, p := range venue.ProductIDs {
+			keys[p] = venue.Key(p)
+		}
+		feeds[i] = &feed{
+			exchange:   ex,
+			url:        venue.SocketURL,
+			productIDs: append([]string(nil), venue.ProductIDs...),
+			venue:      venue.Name(),
+			keys:       keys,
+			seqs:       newSequenceTracker(),
+		}
+	}
+
+	return feeds, nil
+}
+
+// qualify keys the trade's product by the feed's venue when streaming several
+// venues.
+func (f *feed) qualify(tradeValue *types.TradeValue) {
+	if f.venue == "" {
+		return
+	}
+
+	key, ok := f.keys[tradeValue.ProductID]
+	if !ok {
+		key = f.venue + ":" + tradeValue.ProductID
+	}
+	tradeValue.ProductID = key
+	tradeValue.Venue = f.venue
+}
+
+// subscribe adds the product to the feed's subscriptions sending its
+// subscription on the live connection if any.
+func (f *feed) subscribe(ctx context.Context, productID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		if err := f.exchange.Subscribe(ctx, f.conn, []string{productID}); err != nil {
+			return err
+		}
+	}
+	// else subscribed on reconnecting
+	f.productIDs = append(f.productIDs, productID)
+
+	return nil
+}
+
+// unsubscribe removes the product from the feed's subscriptions sending its
+// unsubscription on the live connection if any.
+func (f *feed) unsubscribe(ctx context.Context, productID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, p := range f.productIDs {
+		if p == productID {
+			f.productIDs = append(f.productIDs[:i], f.productIDs[i+1:]...)
+			break
+		}
+	}
+	if f.conn == nil {
+		return nil
+	}
+
+	return f.exchange.Unsubscribe(ctx, f.conn, []string{productID})
+}
+
+// drop clears the feed's dropped live connection.
+func (f *feed) drop(conn *websocket.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn == conn {
+		f.conn = nil
+	}
+}
+
+// consolidate sets up the engine's consolidated instruments.
+func consolidate(engine vwap.Engine, instruments []cmd.InstrumentConfig) error {
+	if len(instruments) == 0 {
+		return nil
+	}
+
+	consolidator, ok := engine.(vwap.Consolidator)
+	if !ok {
+		return fmt.Errorf("the VWAP engine does not consolidate venues")
+	}
+	for _, instrument := range instruments {
+		weights := make(map[string]*big.Float, len(instrument.Weights))
+		for p, weight := range instrument.Weights {
+			weights[p] = big.NewFloat(weight)
+		}
+		if err := consolidator.Consolidate(instrument.Instrument, weights); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectCalculators selects the metrics of the product's results. An engine
+// computing the VWAP alone serves no other calculator.
+func selectCalculators(engine vwap.Engine, productID string, calculators []vwap.Calculator) error {
+	multi, ok := engine.(vwap.MultiCalculator)
+	if !ok {
+		for _, calculator := range calculators {
+			if calculator != vwap.VWAPCalculator {
+				return fmt.Errorf("the VWAP engine does not compute the %s of %s", calculator, productID)
+			}
+		}
+		return nil
+	}
+
+	return multi.SetCalculators(productID, calculators)
+}
+
+// selectBands selects the σ multipliers of the product's VWAP results' bands.
+// An engine without bands serves none.
+func selectBands(engine vwap.Engine, productID string, multipliers []float64) error {
+	bander, ok := engine.(vwap.Bander)
+	if !ok {
+		if len(multipliers) > 0 {
+			return fmt.Errorf("the VWAP engine does not compute the bands of %s", productID)
+		}
+		return nil
+	}
+
+	return bander.SetBands(productID, multipliers)
+}
+
+// configureProduct selects the product's calculators and bands.
+func configureProduct(engine vwap.Engine, productID string, product cmd.ProductConfig) error {
+	if err := selectCalculators(engine, productID, product.Calculators); err != nil {
+		return err
+	}
+
+	return selectBands(engine, productID, product.Bands)
+}
+
+// addProduct adds the product's windows, calculators and bands to the engine
+// at runtime.
+func addProduct(engine vwap.Engine, productID string, product cmd.ProductConfig) error {
+	if err := engine.AddProduct(productID, product.Windows); err != nil {
+		return err
+	}
+
+	return configureProduct(engine, productID, product)
+}
+
+// workerQueueLen is the buffered trades of each worker's queue.
+const workerQueueLen = 64
+
+// GetTradesQConsumer returns the trades queue consumer that receives the
+// product's trade values. A product's trades are queued to the same worker, so
+// that they are processed in their arrival order.
+func (c Client) GetTradesQConsumer(productID string) types.TradesQConsumer {
+	return c.qs[c.worker(productID)]
+}
+
+// worker returns the index of the worker processing the product's trades.
+// Products beyond the configured ones are hashed to a worker.
+func (c Client) worker(productID string) int {
+	if w, ok := c.routes[productID]; ok {
+		return w
+	}
+
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(productID); i++ {
+		h ^= uint32(productID[i])
+		h *= 16777619
+	}
+
+	return int(h % uint32(len(c.qs)))
+}
+
+// Reconnects returns the number of times the socket was redialed after
+// dropping.
+func (c *Client) Reconnects() uint64 {
+	return c.reconnects.Load()
 }
 
 // TradesToVwap pipes trades to the go routines pool and receives back here the
-// transformed VWAP results by the Results Queue.
+// transformed VWAP results by the Results Queue. When configured, the windows
+// are restored from their snapshot first and saved periodically and once more
+// on shutdown.
 func (c *Client) TradesToVwap(ctx context.Context) error {
 	logger := log.FromContext(ctx)
 
-	var err error
-	c.conn, err = server.Connect(ctx, c.cfg.SocketURL)
-	if err != nil {
-		return err
+	if c.api != nil {
+		go func() {
+			if err := c.api.ListenAndServe(ctx); err != nil {
+				logger.Error("HTTP API erred", zap.Error(err))
+			}
+		}()
 	}
-	defer c.conn.Close()
 
-	if err := server.Subscribe(ctx, c.conn, c.cfg.ProductIDs); err != nil {
-		return err
+	if c.recorder != nil {
+		c.recorder.Start(ctx)
+	}
+
+	// the restored windows precede the first trade
+	if c.persister != nil {
+		c.restoreSnapshot(ctx)
+	}
+
+	conns := make([]*websocket.Conn, len(c.feeds))
+	for i, f := range c.feeds {
+		conn, err := c.connect(ctx, f)
+		if err != nil {
+			for _, conn := range conns[:i] {
+				conn.Close()
+			}
+			c.closeRecorder(logger)
+			return err
+		}
+		conns[i] = conn
 	}
 
 	doneTradesStreaming := make(chan struct{})
-	go ingestTradesStream(ctx, c.conn, c.GetTradesQConsumer(), doneTradesStreaming)
+	go c.streamFeeds(ctx, conns, doneTradesStreaming)
+
+	if c.persister == nil {
+		return c.IngestVWAPResults(ctx, logger, doneTradesStreaming)
+	}
 
-	return c.IngestVWAPResults(ctx, logger, doneTradesStreaming)
+	doneSnapshots := make(chan struct{})
+	go func() {
+		defer close(doneSnapshots)
+		c.runSnapshots(ctx)
+	}()
+	err := c.IngestVWAPResults(ctx, logger, doneTradesStreaming)
+	<-doneSnapshots
+	// the last snapshot follows the streaming's shutdown
+	c.saveSnapshot(logger, time.Now())
+
+	return err
 }
 
+// IngestVWAPResults fans the VWAP results out to the sinks, and keeps the
+// closed candles, until ctx is cancelled.
 func (c *Client) IngestVWAPResults(ctx context.Context, logger *zap.Logger, doneTradesStreaming chan struct{}) error {
+	c.sinks.Start(ctx)
+
 	for {
 		select {
 		case res := <-c.productsVwap.GetResultsQ():
-			_, _ = fmt.Fprintf(
-				os.Stderr, "ProductID:%s VWAP:%f\n", res.ProductID, res.Vwap,
-			)
-			// recycle into the mem pool
-			types.VWAPResultMemPool.Put(res)
+			c.writeResult(ctx, res)
+		case candle := <-c.candlesQ:
+			c.writeCandle(candle)
 		case <-ctx.Done():
-			c.gracefulSocketClose(logger, doneTradesStreaming)
+			// wait (with timeout) for the server to close the connection
+			select {
+			case <-doneTradesStreaming:
+			case <-time.After(time.Second):
+			}
+			if err := c.sinks.Close(); err != nil {
+				logger.Error("closing the sinks erred", zap.Error(err))
+			}
+			logger.Sync()
 			return nil
 		}
 	}
 }
 
-func ingestTradesStream(ctx context.Context, conn *websocket.Conn, broadcast chan<- *types.TradeValue, quit chan<- struct{}) {
+// writeResult writes the result to the sinks when its calculator is enabled
+// for the product and recycles it.
+func (c *Client) writeResult(ctx context.Context, res *types.VWAPResult) {
+	c.metrics.result(res)
+	product, _ := c.products.get(res.ProductID)
+	if product.Enabled(vwap.CalculatorOf(res.Metric)) {
+		c.sinks.Write(ctx, &sink.Result{
+			VWAPResult: *res,
+			Precision:  product.OutputPrecision,
+		})
+	}
+	// recycle into the mem pool
+	types.VWAPResultMemPool.Put(res)
+}
+
+// writeCandle keeps the closed candle for the HTTP API.
+func (c *Client) writeCandle(candle *types.Candle) {
+	if c.candles != nil {
+		c.candles.Write(candle)
+	}
+}
+
+// connect dials the feed's socket and subscribes to its products making it the
+// feed's live connection.
+func (c *Client) connect(ctx context.Context, f *feed) (*websocket.Conn, error) {
+	conn, err := f.exchange.Dial(ctx, f.url)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// every product may be unsubscribed at runtime
+	if len(f.productIDs) > 0 {
+		if err := f.exchange.Subscribe(ctx, conn, f.productIDs); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	f.conn = conn
+
+	return conn, nil
+}
+
+// streamFeeds streams the trades of each feed off its connection in conns
+// until ctx is cancelled and then closes quit.
+func (c *Client) streamFeeds(ctx context.Context, conns []*websocket.Conn, quit chan<- struct{}) {
 	defer close(quit)
+	// the recording ends with the ingestion, its only writer
+	defer c.closeRecorder(log.FromContext(ctx))
+
+	var wg sync.WaitGroup
+	for i, f := range c.feeds {
+		wg.Add(1)
+		go func(f *feed, conn *websocket.Conn) {
+			defer wg.Done()
+			c.streamTrades(ctx, f, conn)
+		}(f, conns[i])
+	}
+	wg.Wait()
+}
+
+// streamTrades ingests the feed's trades off conn and redials whenever the
+// connection drops until ctx is cancelled. The products VWAP windows are owned
+// by the client, so they carry over across reconnects.
+func (c *Client) streamTrades(ctx context.Context, f *feed, conn *websocket.Conn) {
+	logger := log.FromContext(ctx)
+	backoff := server.NewBackoff(c.cfg.ReconnectMinDelay, c.cfg.ReconnectMaxDelay)
+
+	for {
+		err := c.ingestUntilDone(ctx, f, conn)
+		f.drop(conn)
+		conn.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("trades stream dropped", zap.Error(err))
+
+		if conn = c.reconnect(ctx, f, backoff); conn == nil {
+			return
+		}
+	}
+}
+
+// closeRecorder closes the optional recording.
+func (c *Client) closeRecorder(logger *zap.Logger) {
+	if c.recorder == nil {
+		return
+	}
+	if err := c.recorder.Close(); err != nil {
+		logger.Error("closing the recording erred", zap.Error(err))
+	}
+}
+
+// reconnect redials with a jittered exponential backoff until it succeeds or
+// ctx is cancelled in which case it returns nil.
+func (c *Client) reconnect(ctx context.Context, f *feed, backoff *server.Backoff) *websocket.Conn {
+	logger := log.FromContext(ctx)
 
+	for {
+		delay := backoff.Next()
+		logger.Info(
+			"reconnecting",
+			zap.Int("attempt", backoff.Attempt()),
+			zap.Duration("delay", delay),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := c.connect(ctx, f)
+		if err != nil {
+			continue
+		}
+
+		reconnects := c.reconnects.Add(1)
+		c.metrics.reconnects.Inc()
+		logger.Info(
+			"reconnected",
+			zap.Int("attempts", backoff.Attempt()),
+			zap.Uint64("reconnects", reconnects),
+		)
+		backoff.Reset()
+
+		return conn
+	}
+}
+
+// ingestUntilDone ingests the trades off conn until the connection drops or
+// ctx is cancelled in which case the socket is closed cleanly.
+func (c *Client) ingestUntilDone(ctx context.Context, f *feed, conn *websocket.Conn) error {
+	logger := log.FromContext(ctx)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.mu.Lock()
+			gracefulSocketClose(logger, conn)
+			f.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	return c.ingestTradesStream(ctx, f, conn)
+}
+
+func (c *Client) ingestTradesStream(ctx context.Context, f *feed, conn *websocket.Conn) error {
 	logger := log.FromContext(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("Stopping trades stream ingestion")
-			return
+			return nil
 
 		default:
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
 				logger.Error("msg reading erred", zap.Error(err))
-				return
+				return err
 			}
-
-			msgType, idx := types.ParseType(msg)
-			if idx == -1 {
-				continue
+			if c.recorder != nil {
+				c.recorder.Record(ctx, msg, time.Now())
 			}
+			c.ingestMessage(ctx, f, msg)
+		}
+	}
+}
 
-			switch msgType {
-			case server.SubAckMsgType:
-				logger.Info("Subscribed:")
-			/*
-			* Undocumented message type? Appears to propagate the same info as
-			* `match`
-			 */
-			case server.MatchLastMsgType:
-				fallthrough
-			case server.MatchMsgType:
-				msgProductID, idx := types.ParseProductID(msg)
-				if idx == -1 {
-					logger.Error("Failed to parse the product:"+string(msg))
-					continue
-				}
-
-				msgPrice, idx := types.ParsePrice(msg)
-				if idx == -1 {
-					logger.Error("Failed to parse the price:"+string(msg))
-					continue
-				}
-
-				msgVolume, idx := types.ParseVolume(msg)
-				if idx == -1 {
-					logger.Error("Failed to parse the volume:"+string(msg))
-					continue
-				}
-
-				tradeValue := getMemPoolTradeVal()
-				tradeValue.ProductID = msgProductID
-				tradeValue.Price.SetFloat64(msgPrice)
-				tradeValue.Size.SetFloat64(msgVolume)
-
-				broadcast <- tradeValue
-
-				logger.Debug(
-					"received trade",
-					zap.Object(tradeValue.ProductID, tradeValue),
-				)
-			case server.ErrorMsgType:
-				logger.Error(
-					"socket error",
-					zap.String("msg", string(msg)),
-				)
-			default:
-				logger.Warn(
-					"unknown socket message",
-					zap.String(msgType, string(msg)),
-				)
+// ingestMessage classifies the feed's socket message by its exchange and
+// queues its trade to the product's worker.
+func (c *Client) ingestMessage(ctx context.Context, f *feed, msg []byte) {
+	logger := log.FromContext(ctx)
+
+	kind, msgType, err := f.exchange.Classify(msg)
+	if err != nil {
+		c.metrics.parseFailed(err, "type")
+		logger.Debug("untyped socket message", zap.Error(err), zap.ByteString("msg", msg))
+		return
+	}
+
+	switch kind {
+	case exchange.SubscribedMessage:
+		logger.Info("Subscribed:")
+	case exchange.TradeMessage:
+		tradeValue := getMemPoolTradeVal(c.cfg.Precision, c.cfg.RoundingMode)
+
+		if err := f.exchange.ParseTrade(msg, tradeValue); err != nil {
+			c.metrics.parseFailed(err, "trade")
+			logger.Error("Failed to parse the trade", zap.Error(err), zap.ByteString("msg", msg))
+			recycleTradeVal(tradeValue)
+			return
+		}
+		f.qualify(tradeValue)
+		generation, subscribed := c.products.generation(tradeValue.ProductID)
+		if !subscribed {
+			// i.e. in flight past its unsubscription
+			logger.Debug("unsubscribed product trade", zap.String("productID", tradeValue.ProductID))
+			recycleTradeVal(tradeValue)
+			return
+		}
+
+		f.seqs.subscribed(tradeValue.ProductID, generation)
+		if event, ok := f.seqs.observe(tradeValue.ProductID, tradeValue.Sequence); ok {
+			if !c.handleSequenceEvent(ctx, &event) {
+				recycleTradeVal(tradeValue)
+				return
 			}
+			tradeValue.Gap = true
 		}
+
+		c.GetTradesQConsumer(tradeValue.ProductID) <- tradeValue
+		c.metrics.trades.WithLabelValues(tradeValue.ProductID).Inc()
+
+		logger.Debug(
+			"received trade",
+			zap.Object(tradeValue.ProductID, tradeValue),
+		)
+	case exchange.ErrorMessage:
+		logger.Error(
+			"socket error",
+			zap.String("msg", string(msg)),
+		)
+	default:
+		c.metrics.unknownMsg(msgType)
+		logger.Warn(
+			"unknown socket message",
+			zap.String(msgType, string(msg)),
+		)
 	}
 }
 
-func getMemPoolTradeVal() *types.TradeValue {
+// handleSequenceEvent reports the sequence discontinuity. It returns false
+// when the trade at hand is to be dropped i.e. a duplicate.
+func (c *Client) handleSequenceEvent(ctx context.Context, event *types.SequenceEvent) bool {
+	log.FromContext(ctx).Warn(
+		"sequence discontinuity",
+		zap.Object("event", event),
+		zap.Stringer("policy", c.cfg.GapPolicy),
+	)
+
+	return event.Kind != types.SequenceDuplicate
+}
+
+// applyGapPolicy applies the configured gap policy to the product's VWAP.
+func (c Client) applyGapPolicy(ctx context.Context, productID string) {
+	var err error
+	switch c.cfg.GapPolicy {
+	case types.GapPolicySuspect:
+		err = c.productsVwap.MarkSuspect(productID)
+	case types.GapPolicyReset:
+		err = c.productsVwap.Reset(productID)
+	}
+	if err != nil {
+		log.FromContext(ctx).Error("applying the gap policy erred", zap.Error(err))
+	}
+}
+
+// getMemPoolTradeVal returns a recycled trade value whose price and size
+// parse the decimal text at the given precision and rounding mode.
+func getMemPoolTradeVal(prec uint, mode big.RoundingMode) *types.TradeValue {
 	tradeValue := types.TradeValueMemPool.Get().(*types.TradeValue)
-	tradeValue.Price = types.BigFloatMemPool.Get().(*big.Float)
-	tradeValue.Size = types.BigFloatMemPool.Get().(*big.Float)
+	tradeValue.Gap = false
+	tradeValue.Venue = ""
+	tradeValue.Price = types.BigFloatMemPool.Get().(*big.Float).SetPrec(prec).SetMode(mode)
+	tradeValue.Size = types.BigFloatMemPool.Get().(*big.Float).SetPrec(prec).SetMode(mode)
 	return tradeValue
 }
 
-func (c *Client) gracefulSocketClose(logger *zap.Logger, doneTradesStreaming <-chan struct{}) {
+// recycleTradeVal puts back a trade value dropped before reaching the pool.
+func recycleTradeVal(tradeValue *types.TradeValue) {
+	types.BigFloatMemPool.Put(tradeValue.Price)
+	types.BigFloatMemPool.Put(tradeValue.Size)
+	types.TradeValueMemPool.Put(tradeValue)
+}
+
+func gracefulSocketClose(logger *zap.Logger, conn *websocket.Conn) {
 	defer logger.Sync()
 	logger.Info("Closing socket")
 
 	// Cleanly close the inboundConn by sending a close message and then
 	// wait (with timeout) for the server to close the inboundConn.
-	err := c.conn.WriteMessage(
+	err := conn.WriteMessage(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
 	)
 	if err != nil {
 		logger.Error("write close error:", zap.Error(err))
 	}
-
-	select {
-	case <-doneTradesStreaming:
-	case <-time.After(time.Second):
-	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
 }
@@ -2,116 +2,334 @@ package workflow
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/blewater/zh/cmd"
+	"github.com/blewater/zh/exchange"
 	"github.com/blewater/zh/log"
-	"github.com/blewater/zh/server"
+	"github.com/blewater/zh/mockfeed"
+	"github.com/blewater/zh/mockfeed/feedtest"
+	"github.com/blewater/zh/vwap"
+	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
 func TestClient_TradesToVwapQ(t *testing.T) {
-	c, err := setupStream(5)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(t, 5)
 
 	t.Run(
 		"trx", func(t *testing.T) {
-			tradesToVwapTrxs(&c, 1)
+			tradesToVwapTrxs(c, 1)
 		},
 	)
 }
 
 func Benchmark_100_VWAP_Trx_1Thread(b *testing.B) {
-	c, err := setupStream(1)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 1)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
 	}
 }
 
 func Benchmark_100_VWAP_Trx_2Threads(b *testing.B) {
-	c, err := setupStream(2)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 2)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
 	}
 }
 
 func Benchmark_100_VWAP_Trx_3Threads(b *testing.B) {
-	c, err := setupStream(3)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 3)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
 	}
 }
 
 func Benchmark_100_VWAP_Trx_5Threads(b *testing.B) {
-	c, err := setupStream(5)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 5)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
 	}
 }
 
 func Benchmark_100_VWAP_Trx_10Threads(b *testing.B) {
-	c, err := setupStream(10)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 10)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
 	}
 }
 
 func Benchmark_100_VWAP_Trx_100Threads(b *testing.B) {
-	c, err := setupStream(100)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 100)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
 	}
 }
 
 func Benchmark_100_VWAP_Trx_200Threads(b *testing.B) {
-	c, err := setupStream(200)
-	if err != nil {
-		return
-	}
-	defer c.conn.Close()
+	c := setupStream(b, 200)
 
 	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
-		tradesToVwapTrxs(&c, 100)
+		tradesToVwapTrxs(c, 100)
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	// each connection serves a single trade and then drops
+	var conns int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		n := atomic.AddInt32(&conns, 1)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscriptions","channels":[{"name":"matches","product_ids":["ETH-USD"]}]}`))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(
+			`{"type":"match","trade_id":178622422,"maker_order_id":"253c56b0-f115-4364-9e06-65ffd2412f3b","taker_order_id":"928f8eb1-b6b4-4735-b12a-a512a0da684f","side":"sell","size":"1","price":"%d","product_id":"ETH-USD","sequence":%d,"time":"2021-11-10T21:37:07.988255Z"}`,
+			10*n, 22394045199+int64(n),
+		)))
+	}))
+	defer srv.Close()
+
+	c, err := New(cmd.Config{
+		WorkerPoolSize:    1,
+		WindowsSize:       200,
+		SocketURL:         "ws" + strings.TrimPrefix(srv.URL, "http"),
+		ProductIDs:        []string{"ETH-USD"},
+		ReconnectMinDelay: time.Millisecond,
+		ReconnectMaxDelay: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	defer cancel()
+
+	// nolint:errcheck
+	go c.StartPool(ctx)
+
+	conn, err := c.connect(ctx, c.feeds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneTradesStreaming := make(chan struct{})
+	go c.streamFeeds(ctx, []*websocket.Conn{conn}, doneTradesStreaming)
+
+	// the window carries over the reconnect: (10*1 + 20*1) / 2
+	want := []string{"10", "15"}
+	for i, w := range want {
+		select {
+		case res := <-c.productsVwap.GetResultsQ():
+			if got := res.Vwap.String(); got != w {
+				t.Errorf("result %d VWAP = %s, want %s", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for result %d", i)
+		}
+	}
+
+	if got := c.Reconnects(); got < 1 {
+		t.Errorf("Reconnects() = %d, want >= 1", got)
+	}
+
+	cancel()
+	<-doneTradesStreaming
+}
+
+func TestClient_ConsolidatedVenues(t *testing.T) {
+	c, err := New(cmd.Config{
+		WorkerPoolSize: 1,
+		WindowsSize:    10,
+		Precision:      128,
+		Venues: []cmd.VenueConfig{
+			{Exchange: exchange.CoinbaseExchange, ProductIDs: []string{"BTC-USD"}},
+			{Exchange: exchange.BinanceExchange, ProductIDs: []string{"BTC-USDT"}},
+		},
+		Consolidated: []cmd.InstrumentConfig{{
+			Instrument: "BTC-USD",
+			Weights: map[string]float64{
+				"coinbase:BTC-USD": 1,
+				"binance:BTC-USDT": 0.5,
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	defer cancel()
+	// nolint:errcheck
+	go c.StartPool(ctx)
+
+	c.ingestMessage(ctx, c.feeds[0], []byte(`{"type":"match","trade_id":1,"side":"sell","size":"3","price":"100","product_id":"BTC-USD","sequence":1,"time":"2021-11-10T21:37:07.988255Z"}`))
+	c.ingestMessage(ctx, c.feeds[1], []byte(`{"e":"trade","E":1636580227990,"s":"BTCUSDT","t":1,"p":"200","q":"2","T":1636580227989}`))
+
+	// a single worker produces the venue product's result ahead of the
+	// instrument's
+	want := []struct {
+		productID, vwap, venues string
+	}{
+		{"coinbase:BTC-USD", "100", ""},
+		{"BTC-USD", "100", "coinbase=1"},
+		{"binance:BTC-USDT", "200", ""},
+		{"BTC-USD", "125", "binance=0.25 coinbase=0.75"},
+	}
+	for i, w := range want {
+		select {
+		case res := <-c.productsVwap.GetResultsQ():
+			var venues []string
+			for _, share := range res.Venues {
+				venues = append(venues, fmt.Sprintf("%s=%v", share.Venue, share.Share))
+			}
+			got := []string{res.ProductID, res.Vwap.String(), strings.Join(venues, " ")}
+			if wanted := []string{w.productID, w.vwap, w.venues}; strings.Join(got, "|") != strings.Join(wanted, "|") {
+				t.Errorf("result %d = %v, want %v", i, got, wanted)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for result %d", i)
+		}
+	}
+}
+
+func TestClient_RuntimeSubscriptions(t *testing.T) {
+	_, url := feedtest.Start(t, mockfeed.Config{Seed: 1})
+	c, err := New(cmd.Config{
+		WorkerPoolSize: 2,
+		WindowsSize:    2,
+		SocketURL:      url,
+		ProductIDs:     []string{"BTC-USD"},
+		Precision:      128,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	defer cancel()
+	// nolint:errcheck
+	go c.StartPool(ctx)
+	conn, err := c.connect(ctx, c.feeds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneTradesStreaming := make(chan struct{})
+	go c.streamFeeds(ctx, []*websocket.Conn{conn}, doneTradesStreaming)
+
+	// awaitResult drains the results until one of the product's
+	awaitResult := func(productID string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case res := <-c.productsVwap.GetResultsQ():
+				if res.ProductID == productID {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for a %s result", productID)
+			}
+		}
+	}
+	awaitResult("BTC-USD")
+
+	if err := c.Subscribe(ctx, []string{"ETH-USD"}); err != nil {
+		t.Fatal(err)
+	}
+	awaitResult("ETH-USD")
+	if got := c.Products(); !reflect.DeepEqual(got, []string{"BTC-USD", "ETH-USD"}) {
+		t.Errorf("Products() = %v", got)
+	}
+	// a subscribed product is skipped
+	if err := c.Subscribe(ctx, []string{"ETH-USD"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.Unsubscribe(ctx, []string{"ETH-USD"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Products(); !reflect.DeepEqual(got, []string{"BTC-USD"}) {
+		t.Errorf("Products() = %v", got)
+	}
+	if _, err := c.productsVwap.Snapshot("ETH-USD"); err == nil {
+		t.Error("the unsubscribed product's windows remain")
+	}
+	if err := c.Unsubscribe(ctx, []string{"ETH-USD"}); err == nil {
+		t.Error("unsubscribing twice expected an error")
+	}
+	awaitResult("BTC-USD")
+
+	// drains the results until the ingestion quits
+	cancel()
+	for {
+		select {
+		case <-c.productsVwap.GetResultsQ():
+		case <-doneTradesStreaming:
+			return
+		}
+	}
+}
+
+func TestClient_SubscribeFailureRollsBack(t *testing.T) {
+	_, url := feedtest.Start(t, mockfeed.Config{Seed: 1})
+	c, err := New(cmd.Config{
+		WorkerPoolSize: 1,
+		WindowsSize:    2,
+		SocketURL:      url,
+		ProductIDs:     []string{"BTC-USD"},
+		Precision:      128,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := log.ContextWithLogger(context.Background(), zap.NewNop())
+	conn, err := c.connect(ctx, c.feeds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the subscription fails on the closed live connection
+	conn.Close()
+
+	if err := c.Subscribe(ctx, []string{"ETH-USD"}); err == nil {
+		t.Fatal("Subscribe() on a closed connection expected an error")
+	}
+	if got := c.Products(); !reflect.DeepEqual(got, []string{"BTC-USD"}) {
+		t.Errorf("Products() = %v, want BTC-USD only", got)
+	}
+	if _, err := c.productsVwap.Snapshot("ETH-USD"); err == nil {
+		t.Error("the failed product's windows remain")
+	}
+	if got := c.feeds[0].productIDs; !reflect.DeepEqual(got, []string{"BTC-USD"}) {
+		t.Errorf("feed products = %v, want BTC-USD only", got)
 	}
 }
 
@@ -122,38 +340,113 @@ func tradesToVwapTrxs(c *Client, trxCount int) {
 	}
 }
 
-func setupStream(workersCnt uint16) (Client, error) {
+// setupStream streams the mock feed's trades of several products to the
+// pool.
+func setupStream(tb testing.TB, workersCnt uint16) *Client {
 	products := []string{"BTC-USD", "USDC-EUR", "ETH-BTC", "ETH-EUR", "BTC-EUR"}
+	_, url := feedtest.Start(tb, mockfeed.Config{Seed: 1})
 
 	cfg := cmd.Config{
-		WorkerPoolSize: workersCnt,
-		WindowsSize:    200,
-		DevLogLevel:    false,
-		SocketURL:      "wss://ws-feed.exchange.coinbase.com",
-		ProductIDs:     products,
+		WorkerPoolSize:    workersCnt,
+		WindowsSize:       200,
+		DevLogLevel:       false,
+		SocketURL:         url,
+		ProductIDs:        products,
+		ReconnectMinDelay: 100 * time.Millisecond,
+		ReconnectMaxDelay: time.Second,
+		Precision:         128,
 	}
 
-	w := New(cfg)
+	w, err := New(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
 
 	logger := zap.NewNop()
-	ctx := log.ContextWithLogger(context.Background(), logger)
+	ctx, cancel := context.WithCancel(log.ContextWithLogger(context.Background(), logger))
 
 	// nolint:errcheck
 	go w.StartPool(ctx)
 
-	var err error
-	w.conn, err = server.Connect(ctx, w.cfg.SocketURL)
+	conn, err := w.connect(ctx, w.feeds[0])
 	if err != nil {
-		return Client{}, err
-	}
-
-	if err := server.Subscribe(ctx, w.conn, products); err != nil {
-		return Client{}, err
+		cancel()
+		tb.Fatal(err)
 	}
 
 	doneTradesStreaming := make(chan struct{})
-	go ingestTradesStream(
-		ctx, w.conn, w.GetTradesQConsumer(), doneTradesStreaming,
+	go w.streamFeeds(ctx, []*websocket.Conn{conn}, doneTradesStreaming)
+	tb.Cleanup(
+		func() {
+			cancel()
+			<-doneTradesStreaming
+		},
 	)
-	return w, nil
+
+	return &w
+}
+
+func TestClient_Snapshot(t *testing.T) {
+	_, url := feedtest.Start(t, mockfeed.Config{Seed: 1, Interval: time.Millisecond})
+	cfg := cmd.Config{
+		WorkerPoolSize: 2,
+		WindowsSize:    5,
+		SocketURL:      url,
+		ProductIDs:     []string{"BTC-USD"},
+		Precision:      128,
+		Snapshot: cmd.SnapshotConfig{
+			Path:     filepath.Join(t.TempDir(), "windows.json"),
+			Interval: 10 * time.Millisecond,
+			MaxAge:   time.Minute,
+		},
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	// nolint:errcheck
+	go c.StartPool(ctx)
+	done := make(chan error)
+	go func() {
+		done <- c.TradesToVwap(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the shutdown's snapshot holds the last windows
+	state, err := vwap.ReadState(cfg.Snapshot.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Products) != 1 || len(state.Products[0].Windows[0].Points) == 0 {
+		t.Fatalf("no trades were saved: %+v", state)
+	}
+
+	restored, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.restoreSnapshot(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	if got := restored.persister.State(state.Taken); !reflect.DeepEqual(got, state) {
+		t.Errorf("restored windows = %+v, want %+v", got, state)
+	}
+	if _, seeded := restored.feeds[0].seqs.last["BTC-USD"]; !seeded {
+		t.Error("the restored sequence number is not the feed's baseline")
+	}
+
+	// a stale snapshot is discarded
+	cfg.Snapshot.MaxAge = time.Nanosecond
+	stale, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.restoreSnapshot(log.ContextWithLogger(context.Background(), zap.NewNop()))
+	if got, _ := stale.productsVwap.Snapshot("BTC-USD"); got.Windows[0].Fill != 0 {
+		t.Errorf("the stale snapshot was restored: %+v", got.Windows[0])
+	}
 }